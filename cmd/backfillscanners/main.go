@@ -0,0 +1,96 @@
+// backfillscanners fills check_in_logs.scanned_by_uid for check-ins recorded
+// before it existed, looking each scanner's email up in Firebase. Emails that
+// no longer belong to an account are reported and left as they are; the
+// staff statistics then keep them apart by email. It takes the same flags and
+// environment as the server and is safe to run more than once.
+//
+//	go run ./cmd/backfillscanners -config config.yaml
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+
+	"firebase.google.com/go/auth"
+	"github.com/koiraladarwin/scanin/config"
+	"github.com/koiraladarwin/scanin/database/postgres"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+)
+
+func main() {
+	ctx := context.Background()
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	creds, err := cfg.FirebaseCredentials()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fbAuth, err := firebaseauth.NewFirebaseAuth(ctx, creds, firebaseauth.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pool, err := sql.Open("pgx", cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	// runs the migrations, so scanned_by_uid exists on an old database
+	if _, err := postgres.NewPostgresDB(ctx, pool); err != nil {
+		log.Fatal(err)
+	}
+
+	emails, err := unresolvedScanners(ctx, pool)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d scanner emails without a uid", len(emails))
+
+	var updated int64
+	for _, email := range emails {
+		user, err := fbAuth.AuthClient.GetUserByEmail(ctx, email)
+		if auth.IsUserNotFound(err) {
+			log.Printf("skipping %s: no firebase account", email)
+			continue
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", email, err)
+		}
+		res, err := pool.ExecContext(ctx,
+			`UPDATE check_in_logs SET scanned_by_uid = $1 WHERE scanned_by = $2 AND scanned_by_uid IS NULL`,
+			user.UID, email)
+		if err != nil {
+			log.Fatalf("%s: %v", email, err)
+		}
+		n, _ := res.RowsAffected()
+		updated += n
+		log.Printf("%s -> %s: %d check-ins", email, user.UID, n)
+	}
+	log.Printf("updated %d check-ins", updated)
+}
+
+func unresolvedScanners(ctx context.Context, pool *sql.DB) ([]string, error) {
+	rows, err := pool.QueryContext(ctx,
+		`SELECT DISTINCT scanned_by FROM check_in_logs WHERE scanned_by_uid IS NULL AND scanned_by <> '' ORDER BY scanned_by`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
@@ -2,82 +2,193 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	"github.com/koiraladarwin/scanin/config"
 	"github.com/koiraladarwin/scanin/constants"
 	"github.com/koiraladarwin/scanin/database/postgres"
+	"github.com/koiraladarwin/scanin/features/apikeys"
+	"github.com/koiraladarwin/scanin/features/cors"
 	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/mailer"
+	"github.com/koiraladarwin/scanin/features/metrics"
+	"github.com/koiraladarwin/scanin/features/ratelimit"
+	"github.com/koiraladarwin/scanin/features/reports"
+	"github.com/koiraladarwin/scanin/features/webhooks"
 	"github.com/koiraladarwin/scanin/handlers"
 )
 
+func main() {
 
+	ctx := context.Background()
 
-func withCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		slog.Error("failed to load configuration", "err", err)
+		os.Exit(1)
+	}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
+	// Validate already rejected a bad level
+	logLevel, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.Setup(os.Stdout, logLevel)
 
-func main() {
+	creds, _ := cfg.FirebaseCredentials()
+	fbAuth, err := firebaseauth.NewFirebaseAuth(ctx, creds, firebaseauth.Options{
+		UserCacheTTL:  cfg.Firebase.UserCacheTTL,
+		UserCacheSize: cfg.Firebase.UserCacheSize,
+	})
+	if err != nil {
+		slog.Error("firebase Auth could not be instatitated", "err", err)
+		os.Exit(1)
+	}
 
-	ctx := context.Background()
-	if os.Getenv("RAILWAY_ENVIRONMENT_ID") == "" {
-		if err := godotenv.Load(); err != nil {
-			log.Println(".env file not found, using environment variables instead")
-		}
+	db, err := postgres.ConnectPostgres(ctx, cfg.DatabaseURL)
+	if err != nil {
+		slog.Error("database count not be connected", "err", err)
+		os.Exit(1)
+	}
+	metrics.RegisterDBStats(db.Stats)
+	if cfg.Features.APIKeys {
+		fbAuth.APIKeys = apikeys.NewVerifier(db)
 	}
 
-	port := os.Getenv("PORT") 
-	if port == "" {
-		port = "4000" 
+	handler := handlers.New(db, fbAuth, cfg)
+	if cfg.Features.ReportEmails && cfg.SMTP.Host != "" {
+		sender := mailer.New(mailer.Options{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+			TLS:      cfg.SMTP.TLS,
+		})
+		handler.Reports = reports.NewScheduler(db, fbAuth, sender, cfg.Reports.PollInterval, cfg.SMTP.Timeout)
+	}
+	if cfg.Features.Webhooks {
+		handler.Webhooks = webhooks.NewDispatcher(db, webhooks.Options{
+			PollInterval: cfg.Webhooks.PollInterval,
+			Timeout:      cfg.Webhooks.Timeout,
+			MaxAttempts:  cfg.Webhooks.MaxAttempts,
+		})
 	}
 
-	fbAuth, err := firebaseauth.NewFirebaseAuth(ctx)
-	if err != nil {
-		log.Fatal("firebase Auth could not be instatitated")
+	// root only holds routes that must not require a Firebase token; they have
+	// to be registered before Router, which matches everything else and puts
+	// it behind AuthMiddleware
+	root := mux.NewRouter()
+	root.Use(logging.Middleware, metrics.Middleware)
+	root.HandleFunc("/healthz", handler.Healthz).Methods(constants.Get)
+	root.HandleFunc("/readyz", handler.Readyz).Methods(constants.Get)
+
+	// calendar apps can't send a Firebase token; the feed checks the token in
+	// its URL instead, and is limited per client IP
+	calendarFeed := http.Handler(http.HandlerFunc(handler.GetCalendarFeed))
+	if cfg.Features.RateLimit {
+		calendarFeed = ratelimit.New(ratelimit.Default, cfg.RateLimit.TrustedProxies).Middleware(calendarFeed)
 	}
+	root.Handle("/events/{id}/calendar.ics", calendarFeed).Methods(constants.Get)
 
-	connStr := os.Getenv("POSTGRESS_URL")
-	if connStr == "" {
-		log.Fatal("DATABASE_URL not set in environment")
+	var metricsSrv *http.Server
+	if cfg.Metrics.Addr != "" {
+		metricsSrv = &http.Server{
+			Addr:              cfg.Metrics.Addr,
+			Handler:           metrics.Handler(cfg.Metrics.Token),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		}
+		go func() {
+			slog.Info("metrics server running", "addr", cfg.Metrics.Addr)
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("metrics server stopped", "err", err)
+			}
+		}()
+	} else if cfg.Metrics.Token != "" {
+		root.Handle("/metrics", metrics.Handler(cfg.Metrics.Token)).Methods(constants.Get)
 	}
 
-	Router := mux.NewRouter()
+	Router := root.NewRoute().Subrouter()
 	Router.Use(fbAuth.AuthMiddleware)
 
-	db, err := postgres.ConnectPostgres(connStr)
-	if err != nil {
-		log.Print(err.Error())
-		log.Fatal("database count not be connected")
+	// rateLimited wraps h with limiter unless rate limiting is switched off
+	rateLimited := func(limiter *ratelimit.Limiter, h http.HandlerFunc) http.Handler {
+		if !cfg.Features.RateLimit {
+			return h
+		}
+		return limiter.Middleware(h)
 	}
-
-	handler := handlers.New(db, fbAuth)
+	if cfg.Features.RateLimit {
+		Router.Use(ratelimit.New(ratelimit.Default, cfg.RateLimit.TrustedProxies).Middleware)
+	}
+	redeemLimiter := ratelimit.New(ratelimit.CodeRedemption, cfg.RateLimit.TrustedProxies)
 
 	Router.HandleFunc("/user", handler.CreateUser).Methods(constants.Post)
 	Router.HandleFunc("/modifyuser", handler.UpdateUser).Methods(constants.Put)
 	Router.HandleFunc("/users/{event_id}", handler.GetUsersByEvent).Methods(constants.Get)
-	Router.HandleFunc("/importusers/{event_id}", handler.ImportUser).Methods(constants.Post)
+	Router.HandleFunc("/events/{id}/attendees/export", handler.ExportAttendees).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/badges", handler.GetBadgeSheet).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/certificates", handler.GetCertificates).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/certificates/{attendee_id}", handler.GetCertificate).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/credits", handler.ExportCredits).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/credits/{attendee_id}", handler.GetCreditTranscript).Methods(constants.Get)
+	if cfg.Features.AttendeeImport {
+		Router.HandleFunc("/importusers/{event_id}", handler.ImportUser).Methods(constants.Post)
+	}
 
 	Router.HandleFunc("/event", handler.CreateEvent).Methods(constants.Post)
 	Router.HandleFunc("/modifyevent", handler.ModifyEvent).Methods(constants.Put)
 	Router.HandleFunc("/event", handler.GetEvent).Methods(constants.Get)
 	Router.HandleFunc("/eventinfo", handler.GetEventInfo).Methods(constants.Get)
-	Router.HandleFunc("/addeventwithcode/{code}", handler.AddEventWithEventCode).Methods(constants.Post)
+	Router.Handle("/addeventwithcode/{code}", rateLimited(redeemLimiter, handler.AddEventWithEventCode)).Methods(constants.Post)
+	Router.HandleFunc("/transferownership", handler.TransferOwnership).Methods(constants.Post)
 	Router.HandleFunc("/giveRoleToStaffs", handler.GiveRoleToStaff).Methods(constants.Post)
 	Router.HandleFunc("/modifyRoleToStaffs", handler.ModifyRoleToStaff).Methods(constants.Post)
 	Router.HandleFunc("/getstaffs/{event_id}", handler.GetStaffsByEvent).Methods(constants.Get)
+	Router.HandleFunc("/staffs/{event_id}/{firebase_id}", handler.RemoveStaff).Methods(constants.Delete)
+	Router.HandleFunc("/leaveevent/{event_id}", handler.LeaveEvent).Methods(constants.Post)
+	Router.HandleFunc("/roles/{event_id}", handler.GetRoleTemplates).Methods(constants.Get)
+	Router.HandleFunc("/roles/{event_id}", handler.SaveRoleTemplate).Methods(constants.Put)
+	Router.HandleFunc("/roles/{event_id}/{name}", handler.DeleteRoleTemplate).Methods(constants.Delete)
+	Router.HandleFunc("/invitations", handler.CreateInvitation).Methods(constants.Post)
+	Router.HandleFunc("/invitations/{event_id}", handler.GetInvitationsByEvent).Methods(constants.Get)
+	Router.HandleFunc("/invitations/{id}", handler.RevokeInvitation).Methods(constants.Delete)
+	Router.HandleFunc("/rotatecodes/{event_id}", handler.RotateEventCodes).Methods(constants.Post)
+	Router.HandleFunc("/badgesettings/{event_id}", handler.GetBadgeSettings).Methods(constants.Get)
+	Router.HandleFunc("/badgesettings/{event_id}", handler.UpdateBadgeSettings).Methods(constants.Put)
+	Router.HandleFunc("/badgelayout/{event_id}", handler.GetBadgeLayout).Methods(constants.Get)
+	Router.HandleFunc("/badgelayout/{event_id}", handler.UpdateBadgeLayout).Methods(constants.Put)
+	Router.HandleFunc("/certificatetemplate/{event_id}", handler.GetCertificateTemplate).Methods(constants.Get)
+	Router.HandleFunc("/certificatetemplate/{event_id}", handler.UpdateCertificateTemplate).Methods(constants.Put)
+	Router.HandleFunc("/events/{id}/audit", handler.GetAuditLog).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/analytics", handler.GetEventAnalytics).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/staffstats", handler.GetStaffStats).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/reports", handler.GetReportSchedules).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/reports", handler.CreateReportSchedule).Methods(constants.Post)
+	Router.HandleFunc("/events/{id}/reports/deliveries", handler.GetReportDeliveries).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/reports/{report_id}", handler.UpdateReportSchedule).Methods(constants.Put)
+	Router.HandleFunc("/events/{id}/reports/{report_id}", handler.DeleteReportSchedule).Methods(constants.Delete)
+	Router.HandleFunc("/events/{id}/reports/{report_id}/send", handler.SendReport).Methods(constants.Post)
+	Router.HandleFunc("/events/{id}/reportsubscription", handler.SubscribeReports).Methods(constants.Put)
+	Router.HandleFunc("/events/{id}/reportsubscription", handler.UnsubscribeReports).Methods(constants.Delete)
+	Router.HandleFunc("/events/{id}/webhooks", handler.GetWebhooks).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/webhooks", handler.CreateWebhook).Methods(constants.Post)
+	Router.HandleFunc("/events/{id}/webhooks/deliveries", handler.GetWebhookDeliveries).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/webhooks/deliveries/{delivery_id}/redeliver", handler.RedeliverWebhook).Methods(constants.Post)
+	Router.HandleFunc("/events/{id}/webhooks/{webhook_id}", handler.UpdateWebhook).Methods(constants.Put)
+	Router.HandleFunc("/events/{id}/webhooks/{webhook_id}", handler.DeleteWebhook).Methods(constants.Delete)
+	Router.HandleFunc("/events/{id}/webhooks/{webhook_id}/secret", handler.RotateWebhookSecret).Methods(constants.Post)
+	Router.HandleFunc("/events/{id}/apikeys", handler.GetAPIKeys).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/apikeys", handler.CreateAPIKey).Methods(constants.Post)
+	Router.HandleFunc("/events/{id}/apikeys/{key_id}", handler.RevokeAPIKey).Methods(constants.Delete)
+	Router.HandleFunc("/events/{id}/calendar", handler.GetCalendarLink).Methods(constants.Get)
+	Router.HandleFunc("/events/{id}/calendar/rotate", handler.RotateCalendarLink).Methods(constants.Post)
 
 	Router.HandleFunc("/activity", handler.CreateActivity).Methods(constants.Post)
 	Router.HandleFunc("/modifyactivity", handler.UpdateActivity).Methods(constants.Put)
@@ -88,12 +199,115 @@ func main() {
 	Router.HandleFunc("/attendeecheckins/{attendee_id}", handler.GetCheckInByUserId).Methods(constants.Get)
 	Router.HandleFunc("/checkins", handler.CreateCheckIn).Methods(constants.Post)
 	Router.HandleFunc("/checkins/{id}", handler.ModifyCheckIn).Methods(constants.Put)
-	Router.HandleFunc("/exportcheckins/{event_id}", handler.ExportCheckIn).Methods(constants.Get)
-
-	log.Printf("Server running on port %s", port)
-	err = http.ListenAndServe(":"+port, withCORS(Router))
+	Router.HandleFunc("/checkouts", handler.CheckOut).Methods(constants.Post)
+	if cfg.Features.CheckInExport {
+		Router.HandleFunc("/exportcheckins/{event_id}", handler.ExportCheckIn).Methods(constants.Get)
+	}
 
+	if len(cfg.CORS.AllowedOrigins) == 0 {
+		slog.Warn("no cors allowed origins configured, cross-origin requests are denied")
+	}
+	corsPolicy, err := cors.New(cors.Options{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+	}, root)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("invalid cors configuration", "err", err)
+		os.Exit(1)
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           corsPolicy.Middleware(root),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// background workers stop with stop and are waited for before the
+	// database is closed under them
+	var workers sync.WaitGroup
+	if handler.Reports != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			handler.Reports.Run(stop)
+		}()
+	}
+	if handler.Webhooks != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			handler.Webhooks.Run(stop)
+		}()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server running", "port", cfg.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		slog.Error("server stopped", "err", err)
+		exitCode = 1
+	case <-stop.Done():
+		slog.Info("shutdown signal received, draining")
+	}
+
+	// fail readiness first and give the load balancer time to notice, so it
+	// stops sending new scans, then let in-flight requests finish before the
+	// database goes away
+	handler.StartDraining()
+	if exitCode == 0 && cfg.Server.DrainDelay > 0 {
+		slog.Info("waiting for the load balancer to drain", "delay", cfg.Server.DrainDelay)
+		time.Sleep(cfg.Server.DrainDelay)
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelShutdown()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("graceful shutdown failed", "err", err)
+		exitCode = 1
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("metrics server shutdown failed", "err", err)
+		}
+	}
+
+	// the server may have stopped on its own, with stop still live
+	cancel()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		slog.Error("background workers did not stop in time")
+		exitCode = 1
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("failed to close database", "err", err)
+		exitCode = 1
+	}
+
+	slog.Info("server stopped")
+	if exitCode != 0 {
+		cancelShutdown()
+		cancel()
+		os.Exit(exitCode)
 	}
 }
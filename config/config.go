@@ -0,0 +1,499 @@
+// Package config loads the server configuration once at startup. Values are
+// layered, later sources overriding earlier ones: built-in defaults, an
+// optional YAML or TOML file, environment variables (and .env outside
+// Railway), and finally command line flags.
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/koiraladarwin/scanin/features/cors"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Port        string `yaml:"port" toml:"port"`
+	DatabaseURL string `yaml:"database_url" toml:"database_url"`
+	LogLevel    string `yaml:"log_level" toml:"log_level"`
+
+	Firebase  Firebase  `yaml:"firebase" toml:"firebase"`
+	Server    Server    `yaml:"server" toml:"server"`
+	Metrics   Metrics   `yaml:"metrics" toml:"metrics"`
+	CORS      CORS      `yaml:"cors" toml:"cors"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Features  Features  `yaml:"features" toml:"features"`
+	SMTP      SMTP      `yaml:"smtp" toml:"smtp"`
+	Reports   Reports   `yaml:"reports" toml:"reports"`
+	Webhooks  Webhooks  `yaml:"webhooks" toml:"webhooks"`
+
+	// SuperAdminEmails may create activities in any event.
+	SuperAdminEmails []string `yaml:"super_admin_emails" toml:"super_admin_emails"`
+
+	// DefaultAvatarURL is the image_url given to attendees created by an
+	// import, which has no image column.
+	DefaultAvatarURL string `yaml:"default_avatar_url" toml:"default_avatar_url"`
+}
+
+// Firebase holds the service account JSON, either base64 encoded or raw.
+// The base64 form wins when both are set.
+type Firebase struct {
+	CredentialsB64  string `yaml:"credentials_b64" toml:"credentials_b64"`
+	CredentialsJSON string `yaml:"credentials_json" toml:"credentials_json"`
+
+	// UserCacheTTL and UserCacheSize bound the user record cache used for
+	// staff listings; a size of 0 disables it.
+	UserCacheTTL  time.Duration `yaml:"user_cache_ttl" toml:"user_cache_ttl"`
+	UserCacheSize int           `yaml:"user_cache_size" toml:"user_cache_size"`
+}
+
+type Server struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	// exports of large events stream for a while, so writes get more room
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// DrainDelay is how long /readyz fails before the server stops taking
+	// requests, so the load balancer notices and stops sending new ones.
+	DrainDelay      time.Duration `yaml:"drain_delay" toml:"drain_delay"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// Metrics are either served on their own (private) Addr, or on the public
+// port behind Token; with neither set they aren't exposed.
+type Metrics struct {
+	Addr  string `yaml:"addr" toml:"addr"`
+	Token string `yaml:"token" toml:"token"`
+}
+
+// CORS is passed to cors.New; see cors.Options. With no AllowedOrigins,
+// the default, browsers get no cross-origin access at all.
+type CORS struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" toml:"allowed_origins"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" toml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" toml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials" toml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age"`
+}
+
+type RateLimit struct {
+	// TrustedProxies is how many proxies sit in front of the service, each
+	// appending to X-Forwarded-For; the client IP is read from there. Behind
+	// Railway's proxy, one, every connection comes from the proxy itself.
+	// 0 uses the connection's address.
+	TrustedProxies int `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+type Features struct {
+	RateLimit      bool `yaml:"rate_limit" toml:"rate_limit"`
+	AttendeeImport bool `yaml:"attendee_import" toml:"attendee_import"`
+	CheckInExport  bool `yaml:"checkin_export" toml:"checkin_export"`
+	// ReportEmails runs the report scheduler; it also needs SMTP.Host.
+	ReportEmails bool `yaml:"report_emails" toml:"report_emails"`
+	Webhooks     bool `yaml:"webhooks" toml:"webhooks"`
+	// APIKeys lets requests authenticate with an event's API keys.
+	APIKeys bool `yaml:"api_keys" toml:"api_keys"`
+}
+
+// SMTP is the server report emails go out through, see mailer.Options. With
+// no Host nothing is sent. For development point it at a local catcher,
+// e.g. Mailpit on localhost:1025 with tls: none.
+type SMTP struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+	From     string `yaml:"from" toml:"from"`
+	// TLS is starttls, tls (implicit, port 465) or none.
+	TLS string `yaml:"tls" toml:"tls"`
+	// Timeout bounds sending one email.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Webhooks is passed to webhooks.NewDispatcher; see webhooks.Options.
+type Webhooks struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout"`
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts"`
+}
+
+type Reports struct {
+	// PollInterval is how often the scheduler looks for due reports; a
+	// schedule fires up to this late.
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+}
+
+func Default() *Config {
+	return &Config{
+		Port:     "4000",
+		LogLevel: "info",
+		Firebase: Firebase{
+			UserCacheTTL:  5 * time.Minute,
+			UserCacheSize: 10000,
+		},
+		Server: Server{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			WriteTimeout:      5 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			DrainDelay:        5 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		CORS: CORS{
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-Device-ID", "X-Request-ID"},
+			// Content-Disposition carries the file name of exports
+			ExposedHeaders: []string{"Content-Disposition", "X-Request-ID", "Retry-After",
+				"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
+			MaxAge: 10 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			TrustedProxies: 1,
+		},
+		Features: Features{
+			RateLimit:      true,
+			AttendeeImport: true,
+			CheckInExport:  true,
+			ReportEmails:   true,
+			Webhooks:       true,
+			APIKeys:        true,
+		},
+		SMTP: SMTP{
+			Port:    587,
+			TLS:     "starttls",
+			Timeout: 30 * time.Second,
+		},
+		Reports: Reports{
+			PollInterval: time.Minute,
+		},
+		Webhooks: Webhooks{
+			PollInterval: 5 * time.Second,
+			Timeout:      10 * time.Second,
+			MaxAttempts:  10,
+		},
+		SuperAdminEmails: []string{"darwinkoirala123@gmail.com"},
+		DefaultAvatarURL: "https://res.cloudinary.com/dcvr2byrp/image/upload/v1753007426/qocwao1uaykjjnkzqxvo.jpg",
+	}
+}
+
+// Load builds the configuration from all sources and validates it. args are
+// the command line arguments without the program name.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("scanin", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a .yaml, .yml or .toml config file (env CONFIG_FILE)")
+	port := fs.String("port", "", "port to listen on (env PORT)")
+	databaseURL := fs.String("database-url", "", "postgres connection string (env POSTGRESS_URL)")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error (env LOG_LEVEL)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("RAILWAY_ENVIRONMENT_ID") == "" {
+		if err := godotenv.Load(); err != nil {
+			slog.Info(".env file not found, using environment variables instead")
+		}
+	}
+
+	cfg := Default()
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = *port
+		case "database-url":
+			cfg.DatabaseURL = *databaseURL
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes path over c, picking the format by its extension. Keys
+// the config doesn't know are errors in either format.
+func (c *Config) loadFile(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".yaml", ".yml", ".toml":
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, use .yaml, .yml or .toml", path, ext)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if ext == ".toml" {
+		md, err := toml.NewDecoder(bytes.NewReader(data)).Decode(c)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config file %s: unknown field %q", path, undecoded[0].String())
+		}
+		return nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = splitList(v)
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a boolean", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	integer := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration (e.g. 30s, 5m)", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("PORT", &c.Port)
+	str("POSTGRESS_URL", &c.DatabaseURL)
+	str("LOG_LEVEL", &c.LogLevel)
+	str("FIREBASE_CONFIG_B64", &c.Firebase.CredentialsB64)
+	str("FIREBASE_CONFIG_JSON", &c.Firebase.CredentialsJSON)
+	duration("FIREBASE_USER_CACHE_TTL", &c.Firebase.UserCacheTTL)
+	integer("FIREBASE_USER_CACHE_SIZE", &c.Firebase.UserCacheSize)
+
+	duration("SERVER_READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	duration("SERVER_READ_TIMEOUT", &c.Server.ReadTimeout)
+	duration("SERVER_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	duration("SERVER_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	duration("SERVER_DRAIN_DELAY", &c.Server.DrainDelay)
+	duration("SERVER_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	str("METRICS_ADDR", &c.Metrics.Addr)
+	str("METRICS_TOKEN", &c.Metrics.Token)
+
+	list("CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+	list("CORS_ALLOWED_HEADERS", &c.CORS.AllowedHeaders)
+	list("CORS_EXPOSED_HEADERS", &c.CORS.ExposedHeaders)
+	boolean("CORS_ALLOW_CREDENTIALS", &c.CORS.AllowCredentials)
+	duration("CORS_MAX_AGE", &c.CORS.MaxAge)
+	integer("RATE_LIMIT_TRUSTED_PROXIES", &c.RateLimit.TrustedProxies)
+
+	boolean("FEATURE_RATE_LIMIT", &c.Features.RateLimit)
+	boolean("FEATURE_ATTENDEE_IMPORT", &c.Features.AttendeeImport)
+	boolean("FEATURE_CHECKIN_EXPORT", &c.Features.CheckInExport)
+	boolean("FEATURE_REPORT_EMAILS", &c.Features.ReportEmails)
+	boolean("FEATURE_WEBHOOKS", &c.Features.Webhooks)
+	boolean("FEATURE_API_KEYS", &c.Features.APIKeys)
+
+	str("SMTP_HOST", &c.SMTP.Host)
+	integer("SMTP_PORT", &c.SMTP.Port)
+	str("SMTP_USERNAME", &c.SMTP.Username)
+	str("SMTP_PASSWORD", &c.SMTP.Password)
+	str("SMTP_FROM", &c.SMTP.From)
+	str("SMTP_TLS", &c.SMTP.TLS)
+	duration("SMTP_TIMEOUT", &c.SMTP.Timeout)
+	duration("REPORTS_POLL_INTERVAL", &c.Reports.PollInterval)
+	duration("WEBHOOKS_POLL_INTERVAL", &c.Webhooks.PollInterval)
+	duration("WEBHOOKS_TIMEOUT", &c.Webhooks.Timeout)
+	integer("WEBHOOKS_MAX_ATTEMPTS", &c.Webhooks.MaxAttempts)
+
+	list("SUPER_ADMIN_EMAILS", &c.SuperAdminEmails)
+	str("DEFAULT_AVATAR_URL", &c.DefaultAvatarURL)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every problem at once rather than the first one, so a
+// broken deploy can be fixed in one go.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		errs = append(errs, fmt.Errorf("port: %q is not a valid port", c.Port))
+	}
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("database url: POSTGRESS_URL is not set"))
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log level: %w", err))
+	}
+	if _, err := c.FirebaseCredentials(); err != nil {
+		errs = append(errs, fmt.Errorf("firebase: %w", err))
+	}
+	if c.Firebase.UserCacheSize < 0 {
+		errs = append(errs, fmt.Errorf("firebase.user_cache_size: must not be negative, got %d", c.Firebase.UserCacheSize))
+	}
+	if c.Firebase.UserCacheSize > 0 && c.Firebase.UserCacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("firebase.user_cache_ttl: must be positive, got %s", c.Firebase.UserCacheTTL))
+	}
+
+	timeouts := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"read_timeout", c.Server.ReadTimeout},
+		{"write_timeout", c.Server.WriteTimeout},
+		{"idle_timeout", c.Server.IdleTimeout},
+		{"shutdown_timeout", c.Server.ShutdownTimeout},
+	}
+	for _, t := range timeouts {
+		if t.d <= 0 {
+			errs = append(errs, fmt.Errorf("server.%s: must be positive, got %s", t.name, t.d))
+		}
+	}
+	if c.Server.DrainDelay < 0 {
+		errs = append(errs, fmt.Errorf("server.drain_delay: must not be negative, got %s", c.Server.DrainDelay))
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if err := cors.ValidateOrigin(origin); err != nil {
+			errs = append(errs, err)
+		}
+		if origin == "*" && c.CORS.AllowCredentials {
+			errs = append(errs, errors.New("cors: allow_credentials needs explicit origins, not \"*\""))
+		}
+	}
+
+	if c.SMTP.Host != "" {
+		if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+			errs = append(errs, fmt.Errorf("smtp.port: %d is not a valid port", c.SMTP.Port))
+		}
+		if _, err := mail.ParseAddress(c.SMTP.From); err != nil {
+			errs = append(errs, fmt.Errorf("smtp.from: %q is not an email address", c.SMTP.From))
+		}
+		switch c.SMTP.TLS {
+		case "starttls", "tls", "none":
+		default:
+			errs = append(errs, fmt.Errorf("smtp.tls: must be starttls, tls or none, got %q", c.SMTP.TLS))
+		}
+		if c.SMTP.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("smtp.timeout: must be positive, got %s", c.SMTP.Timeout))
+		}
+	}
+	if c.RateLimit.TrustedProxies < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.trusted_proxies: must not be negative, got %d", c.RateLimit.TrustedProxies))
+	}
+	if c.Reports.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("reports.poll_interval: must be positive, got %s", c.Reports.PollInterval))
+	}
+	if c.Webhooks.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("webhooks.poll_interval: must be positive, got %s", c.Webhooks.PollInterval))
+	}
+	if c.Webhooks.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("webhooks.timeout: must be positive, got %s", c.Webhooks.Timeout))
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("webhooks.max_attempts: must be at least 1, got %d", c.Webhooks.MaxAttempts))
+	}
+
+	if c.DefaultAvatarURL != "" {
+		if u, err := url.Parse(c.DefaultAvatarURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("default avatar url: %q is not an absolute url", c.DefaultAvatarURL))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// FirebaseCredentials returns the service account JSON.
+func (c *Config) FirebaseCredentials() ([]byte, error) {
+	if c.Firebase.CredentialsB64 != "" {
+		creds, err := base64.StdEncoding.DecodeString(c.Firebase.CredentialsB64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode FIREBASE_CONFIG_B64: %w", err)
+		}
+		return creds, nil
+	}
+	if c.Firebase.CredentialsJSON == "" {
+		return nil, errors.New("no credentials, set FIREBASE_CONFIG_B64 or FIREBASE_CONFIG_JSON")
+	}
+	return []byte(c.Firebase.CredentialsJSON), nil
+}
+
+func (c *Config) IsSuperAdmin(email string) bool {
+	for _, e := range c.SuperAdminEmails {
+		if strings.EqualFold(e, email) {
+			return email != ""
+		}
+	}
+	return false
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -4,3 +4,4 @@ var Put = "PUT"
 var Post = "POST"
 var Get = "GET"
 
+var Delete = "DELETE"
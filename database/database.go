@@ -1,54 +1,136 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"time"
+
 	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/features/permissions"
 	"github.com/koiraladarwin/scanin/models"
 )
 
 type Database interface {
-	CreateUser(*models.UserRequest) (*models.User, error)
-	GetUser(id uuid.UUID) (*models.User, error)
-	UpdateUser(user *models.UserModifyRequest)  (error)
-	GetUsersByEvent(eventID uuid.UUID) ([]models.User, error)
-
-	CreateEvent(*models.EventCreateRequest) error
-	UpdateEvent(*models.EventModifyRequest) error
-	DeleteEvent(id uuid.UUID) error
-	EventExists(eventID uuid.UUID) (bool, error)
-	GetAllEvents() ([]models.Event, error)
-	GetEventsByFirebaseUser(firebaseId string) ([]models.Event, error)
-	GetEventByFirebaseUser(firebaseId string, eventId uuid.UUID) (*models.Event, error)
-	GetEventByAdminId(id string) (*models.Event, error)
-	GetEventByStaffId(id string) (*models.Event, error)
-  GetStaffByEvent(eventId string) ([]models.Staff, error)
-
-	CreateActivity(*models.ActivityCreateRequest) error
-	GetActivity(id uuid.UUID) (*models.Activity, error)
-	UpdateActivity(*models.Activity) error
-	DeleteActivity(id uuid.UUID) error
-	GetActivitiesByEvent(firebaseId string, eventID uuid.UUID) ([]models.Activity, error)
-	GetEventIdByActivity(activityId uuid.UUID) (uuid.UUID, error)
-
-	CreateCheckInLog(*models.CheckInLog) error
-	GetCheckInLog(id uuid.UUID) (*models.CheckInLog, error)
-	UpdateCheckInLog(*models.CheckInLog) error
-	DeleteCheckInLog(id uuid.UUID) error
-	CheckInExists(userID uuid.UUID, activityID uuid.UUID) (uuid.UUID, error)
-	GetAllCheckInLog() ([]models.CheckInLog, error)
-	GetAllCheckInOfEvents(eventID uuid.UUID) ([]models.CheckInLog, error)
-	GetAllCheckInOfActivity(activityID uuid.UUID) ([]models.CheckInRespose, error)
-	GetAllCheckInOfUser(userID uuid.UUID) ([]models.CheckInRespose, error)
-
-	IsCreator(fbId string, eventId string) (bool, error)
-	CanSeeScanned(fbId string, eventId string) (bool, error)
-	CanCreateActivity(fbId string, eventId string) (bool, error)
-	CanCreateAttendee(fbId string, eventId string) (bool, error)
-	CanSeeAttendee(fbId string, eventId string) (bool, error)
-	CanSeeEventInfo(fbId, eventId string) (bool, error)
-	AddStaffToEvent(fbId, eventId string) error
-	AddAdminToEvent(fbId, eventId string) error
-	AddEventRole(role models.RoleRequest) error
-	ModifyEventRole(role models.EditRoleRequest) error
+	// WithTx runs fn inside a single transaction. The Database passed to fn is
+	// bound to that transaction; returning an error (or panicking) rolls it back.
+	// Calling WithTx on a Database that is already bound to a transaction joins it.
+	WithTx(ctx context.Context, fn func(tx Database) error) error
+
+	CreateUser(ctx context.Context, user *models.UserRequest) (*models.User, error)
+	GetUser(ctx context.Context, id uuid.UUID) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.UserModifyRequest) error
+	GetUsersByEvent(ctx context.Context, eventID uuid.UUID) ([]models.User, error)
+	GetBadgeSettings(ctx context.Context, eventId string) (*models.BadgeSettings, error)
+	UpdateBadgeSettings(ctx context.Context, settings *models.BadgeSettings) error
+	GetBadgeLayout(ctx context.Context, eventId string) (*models.BadgeLayout, error)
+	UpdateBadgeLayout(ctx context.Context, layout *models.BadgeLayout) error
+	GetCertificateTemplate(ctx context.Context, eventId string) (*models.CertificateTemplate, error)
+	SaveCertificateTemplate(ctx context.Context, tmpl *models.CertificateTemplate) error
+
+	CreateEvent(ctx context.Context, event *models.EventCreateRequest) (uuid.UUID, error)
+	UpdateEvent(ctx context.Context, event *models.EventModifyRequest) error
+	DeleteEvent(ctx context.Context, id uuid.UUID) error
+	EventExists(ctx context.Context, eventID uuid.UUID) (bool, error)
+	GetAllEvents(ctx context.Context) ([]models.Event, error)
+	GetEventsByFirebaseUser(ctx context.Context, firebaseId string) ([]models.Event, error)
+	GetEventByFirebaseUser(ctx context.Context, firebaseId string, eventId uuid.UUID) (*models.Event, error)
+	GetEventByAdminId(ctx context.Context, id string) (*models.Event, error)
+	GetEventByStaffId(ctx context.Context, id string) (*models.Event, error)
+	GetStaffByEvent(ctx context.Context, eventId string) ([]models.Staff, error)
+
+	CreateActivity(ctx context.Context, activity *models.ActivityCreateRequest) (uuid.UUID, error)
+	GetActivity(ctx context.Context, id uuid.UUID) (*models.Activity, error)
+	UpdateActivity(ctx context.Context, activity *models.Activity) error
+	DeleteActivity(ctx context.Context, id uuid.UUID) error
+	GetActivitiesByEvent(ctx context.Context, firebaseId string, eventID uuid.UUID) ([]models.Activity, error)
+	GetEventIdByActivity(ctx context.Context, activityId uuid.UUID) (uuid.UUID, error)
+
+	CreateCheckInLog(ctx context.Context, checkIn *models.CheckInLog) error
+	GetCheckInLog(ctx context.Context, id uuid.UUID) (*models.CheckInLog, error)
+	UpdateCheckInLog(ctx context.Context, checkIn *models.CheckInLog) error
+	DeleteCheckInLog(ctx context.Context, id uuid.UUID) error
+	CheckInExists(ctx context.Context, userID uuid.UUID, activityID uuid.UUID) (uuid.UUID, error)
+	EachCheckIn(ctx context.Context, fn func(*models.CheckInRespose) error) error
+	EachCheckInOfEvent(ctx context.Context, eventID uuid.UUID, filter models.CheckInFilter, fn func(*models.CheckInRespose) error) error
+	GetAllCheckInOfActivity(ctx context.Context, activityID uuid.UUID) ([]models.CheckInRespose, error)
+	GetAllCheckInOfUser(ctx context.Context, userID uuid.UUID) ([]models.CheckInRespose, error)
+	// GetAttendedActivities lists the activities of an event with a standing
+	// check-in, ordered by attendee and start time; uuid.Nil means every attendee.
+	GetAttendedActivities(ctx context.Context, eventID uuid.UUID, userID uuid.UUID) ([]models.AttendedActivity, error)
+	// GetCreditScans returns the standing check-ins of an event with their
+	// check-out times; uuid.Nil means every attendee.
+	GetCreditScans(ctx context.Context, eventID uuid.UUID, userID uuid.UUID) ([]models.CreditScan, error)
+	GetEventAnalytics(ctx context.Context, eventID uuid.UUID, interval time.Duration) (*models.EventAnalytics, error)
+	RecordRejectedScan(ctx context.Context, scan *models.RejectedScan) error
+	GetStaffStats(ctx context.Context, eventID uuid.UUID) ([]models.StaffStats, error)
+
+	CreateReportSchedule(ctx context.Context, schedule *models.ReportSchedule) error
+	GetReportSchedule(ctx context.Context, id uuid.UUID) (*models.ReportSchedule, error)
+	GetReportSchedules(ctx context.Context, eventID uuid.UUID) ([]models.ReportSchedule, error)
+	UpdateReportSchedule(ctx context.Context, schedule *models.ReportSchedule) error
+	DeleteReportSchedule(ctx context.Context, id uuid.UUID) error
+	ClaimDueReports(ctx context.Context, now time.Time, limit int) ([]models.DueReport, error)
+	MarkReportRun(ctx context.Context, id uuid.UUID, ranAt time.Time, next *time.Time) error
+	SetReportSubscription(ctx context.Context, eventID uuid.UUID, fbId string, subscribed bool) error
+	GetReportSubscribers(ctx context.Context, eventID uuid.UUID) ([]string, error)
+	RecordReportDelivery(ctx context.Context, delivery *models.ReportDelivery) error
+	GetReportDeliveries(ctx context.Context, eventID uuid.UUID, limit int) ([]models.ReportDelivery, error)
+
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	GetWebhook(ctx context.Context, id uuid.UUID) (*models.Webhook, error)
+	GetWebhooks(ctx context.Context, eventID uuid.UUID) ([]models.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook) error
+	RotateWebhookSecret(ctx context.Context, id uuid.UUID, secret string) error
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	EnqueueWebhook(ctx context.Context, eventId string, eventType string, payload []byte) error
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Time, limit int) ([]models.WebhookTarget, error)
+	RequeueWebhookDelivery(ctx context.Context, id int64, lease time.Time) (*models.WebhookTarget, error)
+	RecordWebhookAttempt(ctx context.Context, id int64, attempt models.WebhookAttempt) error
+	GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error)
+	GetWebhookDeliveries(ctx context.Context, eventID uuid.UUID, filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error)
+
+	CreateAPIKey(ctx context.Context, key *models.APIKey, hash string) error
+	GetAPIKey(ctx context.Context, id uuid.UUID) (*models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	GetAPIKeys(ctx context.Context, eventID uuid.UUID) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) error
+	TouchAPIKey(ctx context.Context, id uuid.UUID, usedAt time.Time) error
+
+	CreateInvitation(ctx context.Context, invitation *models.Invitation) error
+	GetInvitation(ctx context.Context, id uuid.UUID) (*models.Invitation, error)
+	GetInvitationByCode(ctx context.Context, code string) (*models.Invitation, error)
+	GetInvitationsByEvent(ctx context.Context, eventId uuid.UUID) ([]models.Invitation, error)
+	RevokeInvitation(ctx context.Context, id uuid.UUID) error
+	UseInvitation(ctx context.Context, id uuid.UUID) error
+	RotateEventCodes(ctx context.Context, eventId uuid.UUID) (*models.EventCodes, error)
+	GetCalendarFeed(ctx context.Context, eventId uuid.UUID, token string) (*models.Event, []models.Activity, error)
+	EnsureCalendarToken(ctx context.Context, eventId uuid.UUID, token string) (string, error)
+	RotateCalendarToken(ctx context.Context, eventId uuid.UUID, token string) error
+
+	IsCreator(ctx context.Context, fbId string, eventId string) (bool, error)
+	HasPermission(ctx context.Context, fbId, eventId string, action permissions.Action) (bool, error)
+	HasActivityPermission(ctx context.Context, fbId string, activityId uuid.UUID, action permissions.Action) (bool, error)
+	GetPermissions(ctx context.Context, fbId, eventId string) ([]permissions.Action, error)
+	CanSeeEventInfo(ctx context.Context, fbId, eventId string) (bool, error)
+	AddStaffToEvent(ctx context.Context, fbId, eventId string) error
+	AddAdminToEvent(ctx context.Context, fbId, eventId string) error
+	AddOwnerToEvent(ctx context.Context, fbId, eventId string) error
+	IsOwner(ctx context.Context, fbId, eventId string) (bool, error)
+	TransferEventOwnership(ctx context.Context, eventId, fromFbId, toFbId string) error
+	RemoveStaffFromEvent(ctx context.Context, eventId, fbId string) error
+	AddEventRole(ctx context.Context, role models.RoleRequest) error
+	AddStaffWithRole(ctx context.Context, role models.RoleRequest) (bool, error)
+	ModifyEventRole(ctx context.Context, role models.RoleRequest) error
+
+	GetRoleTemplates(ctx context.Context, eventId string) ([]permissions.Role, error)
+	GetRoleTemplate(ctx context.Context, eventId, name string) (*permissions.Role, error)
+	SaveRoleTemplate(ctx context.Context, eventId string, role permissions.Role) error
+	DeleteRoleTemplate(ctx context.Context, eventId, name string) error
+
+	RecordAudit(ctx context.Context, entry *models.AuditEntry) error
+	EachAuditEntry(ctx context.Context, filter models.AuditFilter, fn func(*models.AuditEntry) error) error
 
+	Ping(ctx context.Context) error
+	Stats() sql.DBStats
 	Close() error
 }
@@ -4,3 +4,5 @@ import "errors"
 
 var ErrAlreadyExists = errors.New("record already exists")
 var ErrNotFound = errors.New("record not found")
+var ErrIsOwner = errors.New("the event owner has to transfer ownership first")
+var ErrLastCreator = errors.New("an event needs at least one creator")
@@ -1,22 +1,25 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
 	"github.com/google/uuid"
 	"github.com/koiraladarwin/scanin/models"
 )
 
-func (p *PostgresDB) CreateActivity(a *models.ActivityCreateRequest) error {
-  var id string
-	query := `INSERT INTO activities (event_id, name, type, start_time, end_time) 
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	return p.sql.QueryRow(query, a.EventID, a.Name, a.Type, a.StartTime, a.EndTime).Scan(&id)
+func (p *PostgresDB) CreateActivity(ctx context.Context, a *models.ActivityCreateRequest) (uuid.UUID, error) {
+  var id uuid.UUID
+	query := `INSERT INTO activities (event_id, name, type, start_time, end_time, credits, credit_rule, min_dwell_percent) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err := p.sql.QueryRowContext(ctx, query, a.EventID, a.Name, a.Type, a.StartTime, a.EndTime, a.Credits, a.CreditRule, a.MinDwellPercent).Scan(&id)
+	return id, err
 }
 
-func (p *PostgresDB) GetActivity(id uuid.UUID) (*models.Activity, error) {
+func (p *PostgresDB) GetActivity(ctx context.Context, id uuid.UUID) (*models.Activity, error) {
 	scannedUsers := 0
 	a := &models.Activity{}
-	query := `SELECT id, event_id, name, type, start_time, end_time FROM activities WHERE id = $1 AND delete_at IS NULL`
-	err := p.sql.QueryRow(query, id).Scan(&a.ID, &a.EventID, &a.Name, &a.Type, &a.StartTime, &a.EndTime)
+	query := `SELECT id, event_id, name, type, start_time, end_time, credits, credit_rule, min_dwell_percent, updated_at, sequence FROM activities WHERE id = $1 AND delete_at IS NULL`
+	err := p.sql.QueryRowContext(ctx, query, id).Scan(&a.ID, &a.EventID, &a.Name, &a.Type, &a.StartTime, &a.EndTime, &a.Credits, &a.CreditRule, &a.MinDwellPercent, &a.UpdatedAt, &a.Sequence)
 	if err != nil {
 		return nil, err
 	}
@@ -25,18 +28,27 @@ func (p *PostgresDB) GetActivity(id uuid.UUID) (*models.Activity, error) {
 	return a, err
 }
 
-func (p *PostgresDB) UpdateActivity(a *models.Activity) error {
-	query := `UPDATE activities SET event_id=$1, name=$2, type=$3, start_time=$4, end_time=$5 WHERE id=$6`
-	_, err := p.sql.Exec(query, a.EventID, a.Name, a.Type, a.StartTime, a.EndTime, a.ID)
+func (p *PostgresDB) UpdateActivity(ctx context.Context, a *models.Activity) error {
+	// SET reads the old row, so sequence only moves when the times do. An
+	// activity stays in the event it was created in.
+	query := `UPDATE activities SET name=$1, type=$2, start_time=$3, end_time=$4, credits=$5, credit_rule=$6, min_dwell_percent=$7,
+		sequence = sequence + CASE WHEN start_time <> $3 OR end_time <> $4 THEN 1 ELSE 0 END, updated_at = now()
+		WHERE id=$8 RETURNING updated_at, sequence`
+	err := p.sql.QueryRowContext(ctx, query, a.Name, a.Type, a.StartTime, a.EndTime, a.Credits, a.CreditRule, a.MinDwellPercent, a.ID).
+		Scan(&a.UpdatedAt, &a.Sequence)
+	if err == sql.ErrNoRows {
+		// callers load the activity first; it was deleted in between
+		return nil
+	}
 	return err
 }
 
-func (p *PostgresDB) DeleteActivity(id uuid.UUID) error {
-	_, err := p.sql.Exec(`DELETE FROM activities WHERE id=$1`, id)
+func (p *PostgresDB) DeleteActivity(ctx context.Context, id uuid.UUID) error {
+	_, err := p.sql.ExecContext(ctx, `DELETE FROM activities WHERE id=$1`, id)
 	return err
 }
 
-func (p *PostgresDB) GetActivitiesByEvent(firebaseId string,eventID uuid.UUID) ([]models.Activity, error) {
+func (p *PostgresDB) GetActivitiesByEvent(ctx context.Context, firebaseId string,eventID uuid.UUID) ([]models.Activity, error) {
 	activities := []models.Activity{}
 
 	query := `
@@ -47,12 +59,17 @@ SELECT
   a.type,
   a.start_time,
   a.end_time,
+  a.credits,
+  a.credit_rule,
+  a.min_dwell_percent,
+  a.updated_at,
+  a.sequence,
   CASE
-   WHEN er.isCreator OR er.canSeeScanned THEN COALESCE(scanned.count, 0)
+   WHEN has_event_permission(er.isCreator, er.role, er.permissions, er.event_id, 'checkin.read') THEN COALESCE(scanned.count, 0)
   ELSE -1
   END AS number_of_scanned_users
 FROM activities a
-JOIN eventRoles er ON er.event_id = a.event_id AND er.fireBaseId = $2
+JOIN event_principals er ON er.event_id = a.event_id AND er.fireBaseId = $2
 LEFT JOIN (
   SELECT activity_id, COUNT(*) AS count
   FROM check_in_logs
@@ -62,7 +79,7 @@ LEFT JOIN (
 WHERE a.event_id = $1 AND a.delete_at IS NULL;
 `
 
-	rows, err := p.sql.Query(query, eventID, firebaseId)
+	rows, err := p.sql.QueryContext(ctx, query, eventID, firebaseId)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +87,7 @@ WHERE a.event_id = $1 AND a.delete_at IS NULL;
 
 	for rows.Next() {
 		var a models.Activity
-		if err := rows.Scan(&a.ID, &a.EventID, &a.Name, &a.Type, &a.StartTime, &a.EndTime, &a.NumberOfScanedUsers); err != nil {
+		if err := rows.Scan(&a.ID, &a.EventID, &a.Name, &a.Type, &a.StartTime, &a.EndTime, &a.Credits, &a.CreditRule, &a.MinDwellPercent, &a.UpdatedAt, &a.Sequence, &a.NumberOfScanedUsers); err != nil {
 			return nil, err
 		}
 		activities = append(activities, a)
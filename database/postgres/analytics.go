@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// checkedCTE is the event's standing check-ins; every analytics query starts
+// from it with the event id as $1.
+const checkedCTE = `
+WITH checked AS (
+  SELECT c.user_id, c.activity_id, c.scanned_at, c.scanned_by, c.scanned_by_uid
+  FROM check_in_logs c
+  JOIN activities a ON a.id = c.activity_id
+  JOIN users u ON u.id = c.user_id
+  WHERE a.event_id = $1 AND c.status = 'checked'
+    AND a.delete_at IS NULL AND u.delete_at IS NULL
+)`
+
+func (p *PostgresDB) GetEventAnalytics(ctx context.Context, eventID uuid.UUID, interval time.Duration) (*models.EventAnalytics, error) {
+	an := &models.EventAnalytics{
+		EventID:   eventID,
+		Interval:  interval.String(),
+		Timeline:  []models.ActivityTimeline{},
+		ByStaff:   []models.StaffScans{},
+		ByRole:    []models.GroupAttendance{},
+		ByCompany: []models.GroupAttendance{},
+		Repeat:    []models.RepeatAttendance{},
+	}
+
+	totals := checkedCTE + `,
+registered AS (SELECT COUNT(*) AS n FROM users WHERE event_id = $1 AND delete_at IS NULL),
+attended AS (SELECT COUNT(DISTINCT user_id) AS n FROM checked)
+SELECT
+  registered.n,
+  attended.n,
+  (SELECT COUNT(*) FROM checked),
+  COALESCE((registered.n - attended.n)::float8 / NULLIF(registered.n, 0), 0)
+FROM registered, attended`
+	err := p.sql.QueryRowContext(ctx, totals, eventID).Scan(&an.Registered, &an.UniqueAttendees, &an.TotalCheckIns, &an.NoShowRate)
+	if err != nil {
+		return nil, fmt.Errorf("analytics totals: %w", err)
+	}
+
+	// an attendee arrives with their first scan of the event
+	peak := checkedCTE + `
+SELECT date_trunc('minute', first_scan) AS minute, COUNT(*)
+FROM (SELECT user_id, MIN(scanned_at) AS first_scan FROM checked GROUP BY user_id) arrivals
+GROUP BY minute
+ORDER BY COUNT(*) DESC, minute
+LIMIT 1`
+	var arrival models.PeakArrival
+	err = p.sql.QueryRowContext(ctx, peak, eventID).Scan(&arrival.Minute, &arrival.Arrivals)
+	if err == nil {
+		an.PeakArrival = &arrival
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("analytics peak arrival: %w", err)
+	}
+
+	// scanned_at has no zone, so its epoch is taken as UTC and the buckets
+	// are aligned to the interval from 1970-01-01 UTC
+	timeline := checkedCTE + `
+SELECT c.activity_id, a.name,
+  to_timestamp(floor(extract(epoch FROM c.scanned_at)::float8 / $2::float8) * $2::float8) AS bucket,
+  COUNT(*)
+FROM checked c
+JOIN activities a ON a.id = c.activity_id
+GROUP BY c.activity_id, a.name, a.start_time, bucket
+ORDER BY a.start_time, a.name, c.activity_id, bucket`
+	err = p.collect(ctx, timeline, func(rows *sql.Rows) error {
+		var activityID uuid.UUID
+		var name string
+		var bucket models.TimeBucket
+		if err := rows.Scan(&activityID, &name, &bucket.Start, &bucket.CheckIns); err != nil {
+			return err
+		}
+		bucket.Start = bucket.Start.UTC()
+		if n := len(an.Timeline); n == 0 || an.Timeline[n-1].ActivityID != activityID {
+			an.Timeline = append(an.Timeline, models.ActivityTimeline{ActivityID: activityID, Name: name})
+		}
+		last := &an.Timeline[len(an.Timeline)-1]
+		last.Buckets = append(last.Buckets, bucket)
+		return nil
+	}, eventID, interval.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("analytics timeline: %w", err)
+	}
+
+	// staff are told apart by UID; older rows only have the email
+	staff := checkedCTE + `
+SELECT COALESCE(MAX(scanned_by_uid), ''), (array_agg(scanned_by ORDER BY scanned_at DESC))[1], COUNT(*)
+FROM checked
+GROUP BY COALESCE(scanned_by_uid, scanned_by)
+ORDER BY COUNT(*) DESC, 2`
+	err = p.collect(ctx, staff, func(rows *sql.Rows) error {
+		var s models.StaffScans
+		if err := rows.Scan(&s.ScannedByUID, &s.ScannedBy, &s.Scans); err != nil {
+			return err
+		}
+		an.ByStaff = append(an.ByStaff, s)
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("analytics staff: %w", err)
+	}
+
+	for _, group := range []struct {
+		column string
+		into   *[]models.GroupAttendance
+	}{
+		{"role", &an.ByRole},
+		{"company", &an.ByCompany},
+	} {
+		// column comes from the fixed list above, never from the request
+		query := checkedCTE + fmt.Sprintf(`
+SELECT u.%[1]s, COUNT(*), COUNT(att.user_id)
+FROM users u
+LEFT JOIN (SELECT DISTINCT user_id FROM checked) att ON att.user_id = u.id
+WHERE u.event_id = $1 AND u.delete_at IS NULL
+GROUP BY u.%[1]s
+ORDER BY COUNT(*) DESC, u.%[1]s`, group.column)
+		into := group.into
+		err = p.collect(ctx, query, func(rows *sql.Rows) error {
+			var g models.GroupAttendance
+			if err := rows.Scan(&g.Name, &g.Registered, &g.Attended); err != nil {
+				return err
+			}
+			*into = append(*into, g)
+			return nil
+		}, eventID)
+		if err != nil {
+			return nil, fmt.Errorf("analytics by %s: %w", group.column, err)
+		}
+	}
+
+	repeat := checkedCTE + `
+SELECT activities, COUNT(*)
+FROM (SELECT user_id, COUNT(DISTINCT activity_id) AS activities FROM checked GROUP BY user_id) per_attendee
+GROUP BY activities
+ORDER BY activities`
+	err = p.collect(ctx, repeat, func(rows *sql.Rows) error {
+		var r models.RepeatAttendance
+		if err := rows.Scan(&r.Activities, &r.Attendees); err != nil {
+			return err
+		}
+		an.Repeat = append(an.Repeat, r)
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("analytics repeat attendance: %w", err)
+	}
+
+	return an, nil
+}
+
+// collect runs query and hands every row to fn.
+func (p *PostgresDB) collect(ctx context.Context, query string, fn func(*sql.Rows) error, args ...any) error {
+	rows, err := p.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	db "github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+const apiKeyColumns = `id, event_id, name, prefix, array_to_string(scopes, ','), expires_at, last_used_at, created_by, created_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }, k *models.APIKey) error {
+	var scopes string
+	err := row.Scan(&k.ID, &k.EventID, &k.Name, &k.Prefix, &scopes, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedBy, &k.CreatedAt, &k.RevokedAt)
+	k.Scopes = permissions.FromList(scopes)
+	return err
+}
+
+// CreateAPIKey stores a key by the hash of its token.
+func (p *PostgresDB) CreateAPIKey(ctx context.Context, k *models.APIKey, hash string) error {
+	query := `
+INSERT INTO api_keys (event_id, name, prefix, key_hash, scopes, expires_at, created_by)
+VALUES ($1, $2, $3, $4, $5::text[], $6, $7)
+RETURNING id, created_at`
+	return p.sql.QueryRowContext(ctx, query, k.EventID, k.Name, k.Prefix, hash, permissions.ToArray(k.Scopes), k.ExpiresAt, k.CreatedBy).
+		Scan(&k.ID, &k.CreatedAt)
+}
+
+func (p *PostgresDB) GetAPIKey(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
+	k := &models.APIKey{}
+	err := scanAPIKey(p.sql.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id), k)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// GetAPIKeyByHash finds a key by the hash of its token, whether or not it
+// is still usable.
+func (p *PostgresDB) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	k := &models.APIKey{}
+	err := scanAPIKey(p.sql.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash), k)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// GetAPIKeys returns an event's keys, revoked ones included, newest first.
+func (p *PostgresDB) GetAPIKeys(ctx context.Context, eventID uuid.UUID) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE event_id = $1 ORDER BY created_at DESC`
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var k models.APIKey
+		if err := scanAPIKey(rows, &k); err != nil {
+			return err
+		}
+		keys = append(keys, k)
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey stops a key from working. The row stays for the record;
+// revoking it again keeps the first revocation time.
+func (p *PostgresDB) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	res, err := p.sql.ExecContext(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresDB) TouchAPIKey(ctx context.Context, id uuid.UUID, usedAt time.Time) error {
+	_, err := p.sql.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id, usedAt)
+	return err
+}
@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/koiraladarwin/scanin/models"
+)
+
+func (p *PostgresDB) RecordAudit(ctx context.Context, entry *models.AuditEntry) error {
+	query := `INSERT INTO audit_log
+		(event_id, actor_uid, actor_email, action, entity_type, entity_id, before, after, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at`
+	return p.sql.QueryRowContext(ctx, query,
+		entry.EventId, entry.ActorUID, entry.ActorEmail, entry.Action, entry.EntityType, entry.EntityId,
+		nullJSON(entry.Before), nullJSON(entry.After), entry.IP, entry.UserAgent,
+	).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// EachAuditEntry hands the entries matching filter to fn newest first, one at
+// a time, so exports don't hold the whole log in memory.
+func (p *PostgresDB) EachAuditEntry(ctx context.Context, filter models.AuditFilter, fn func(*models.AuditEntry) error) error {
+	where := []string{"event_id = $1"}
+	args := []any{filter.EventId}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.ActorUID != "" {
+		add("actor_uid = $%d", filter.ActorUID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.EntityType != "" {
+		add("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityId != "" {
+		add("entity_id = $%d", filter.EntityId)
+	}
+	if filter.From != nil {
+		add("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("created_at < $%d", *filter.To)
+	}
+	if filter.BeforeID > 0 {
+		add("id < $%d", filter.BeforeID)
+	}
+
+	query := `SELECT id, event_id, actor_uid, actor_email, action, entity_type, entity_id,
+		COALESCE(before::text, 'null'), COALESCE(after::text, 'null'), ip, user_agent, created_at
+		FROM audit_log WHERE ` + strings.Join(where, " AND ") + ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := p.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.AuditEntry
+		var before, after string
+		err := rows.Scan(&entry.ID, &entry.EventId, &entry.ActorUID, &entry.ActorEmail, &entry.Action,
+			&entry.EntityType, &entry.EntityId, &before, &after, &entry.IP, &entry.UserAgent, &entry.CreatedAt)
+		if err != nil {
+			return err
+		}
+		entry.Before, entry.After = []byte(before), []byte(after)
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// nullJSON stores an empty document as SQL NULL rather than invalid JSON.
+func nullJSON(doc []byte) any {
+	if len(doc) == 0 {
+		return nil
+	}
+	return string(doc)
+}
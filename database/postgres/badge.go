@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	db "github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// nextAutoId allocates the next badge number for role within an event. The
+// counter row is created on first use, seeded from the event's start number or
+// from whatever auto_ids already exist, and the upsert's row lock makes
+// concurrent allocations for the same (event, role) queue instead of collide.
+func nextAutoId(ctx context.Context, q querier, eventId, role string) (int, error) {
+	query := `
+INSERT INTO auto_id_sequences (event_id, role, last_value)
+SELECT
+  e.id,
+  $2,
+  GREATEST(
+    e.auto_id_start,
+    COALESCE((SELECT MAX(auto_id) + 1 FROM users WHERE event_id = e.id AND role = $2), 0)
+  )
+FROM events e
+WHERE e.id = $1
+ON CONFLICT (event_id, role) DO UPDATE
+SET last_value = GREATEST(auto_id_sequences.last_value + 1, EXCLUDED.last_value)
+RETURNING last_value
+`
+	var autoId int
+	err := q.QueryRowContext(ctx, query, eventId, role).Scan(&autoId)
+	if err == sql.ErrNoRows {
+		return 0, db.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate auto_id: %w", err)
+	}
+	return autoId, nil
+}
+
+func formatBadgeNumber(prefix string, padding int, autoId int) string {
+	return fmt.Sprintf("%s%0*d", prefix, padding, autoId)
+}
+
+func (p *PostgresDB) GetBadgeSettings(ctx context.Context, eventId string) (*models.BadgeSettings, error) {
+	settings := &models.BadgeSettings{EventId: eventId, Prefixes: map[string]string{}}
+
+	query := `SELECT auto_id_start, auto_id_padding FROM events WHERE id = $1 AND delete_at IS NULL`
+	err := p.sql.QueryRowContext(ctx, query, eventId).Scan(&settings.StartNumber, &settings.Padding)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.sql.QueryContext(ctx, `SELECT role, prefix FROM auto_id_prefixes WHERE event_id = $1`, eventId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role, prefix string
+		if err := rows.Scan(&role, &prefix); err != nil {
+			return nil, err
+		}
+		settings.Prefixes[role] = prefix
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func (p *PostgresDB) UpdateBadgeSettings(ctx context.Context, settings *models.BadgeSettings) error {
+	return p.inTransaction(ctx, func(tx *PostgresDB) error {
+		res, err := tx.sql.ExecContext(ctx, `UPDATE events SET auto_id_start = $1, auto_id_padding = $2 WHERE id = $3 AND delete_at IS NULL`,
+			settings.StartNumber, settings.Padding, settings.EventId)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return db.ErrNotFound
+		}
+
+		if _, err := tx.sql.ExecContext(ctx, `DELETE FROM auto_id_prefixes WHERE event_id = $1`, settings.EventId); err != nil {
+			return err
+		}
+
+		for role, prefix := range settings.Prefixes {
+			_, err := tx.sql.ExecContext(ctx, `INSERT INTO auto_id_prefixes (event_id, role, prefix) VALUES ($1, $2, $3)`,
+				settings.EventId, role, prefix)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *PostgresDB) GetBadgeLayout(ctx context.Context, eventId string) (*models.BadgeLayout, error) {
+	layout := &models.BadgeLayout{EventId: eventId, RoleColors: map[string]string{}}
+
+	query := `SELECT badge_columns, badge_rows, badge_color FROM events WHERE id = $1 AND delete_at IS NULL`
+	err := p.sql.QueryRowContext(ctx, query, eventId).Scan(&layout.Columns, &layout.Rows, &layout.DefaultColor)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.sql.QueryContext(ctx, `SELECT role, color FROM badge_role_colors WHERE event_id = $1`, eventId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role, color string
+		if err := rows.Scan(&role, &color); err != nil {
+			return nil, err
+		}
+		layout.RoleColors[role] = color
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
+
+func (p *PostgresDB) UpdateBadgeLayout(ctx context.Context, layout *models.BadgeLayout) error {
+	return p.inTransaction(ctx, func(tx *PostgresDB) error {
+		res, err := tx.sql.ExecContext(ctx, `UPDATE events SET badge_columns = $1, badge_rows = $2, badge_color = $3 WHERE id = $4 AND delete_at IS NULL`,
+			layout.Columns, layout.Rows, layout.DefaultColor, layout.EventId)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return db.ErrNotFound
+		}
+
+		if _, err := tx.sql.ExecContext(ctx, `DELETE FROM badge_role_colors WHERE event_id = $1`, layout.EventId); err != nil {
+			return err
+		}
+
+		for role, color := range layout.RoleColors {
+			_, err := tx.sql.ExecContext(ctx, `INSERT INTO badge_role_colors (event_id, role, color) VALUES ($1, $2, $3)`,
+				layout.EventId, role, color)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	db "github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// GetCalendarFeed returns the event and its activities, by start time, for
+// the calendar feed. Both reads match on the feed token rather than a
+// principal, so nothing is returned without it: db.ErrNotFound for an
+// unknown event or a wrong token.
+func (p *PostgresDB) GetCalendarFeed(ctx context.Context, eventId uuid.UUID, token string) (*models.Event, []models.Activity, error) {
+	e := &models.Event{}
+	query := `SELECT id, name, description, start_time, end_time, location FROM events
+		WHERE id = $1 AND calendar_token = $2 AND delete_at IS NULL`
+	err := p.sql.QueryRowContext(ctx, query, eventId, token).Scan(&e.ID, &e.Name, &e.Description, &e.StartTime, &e.EndTime, &e.Location)
+	if err == sql.ErrNoRows {
+		return nil, nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	activities := []models.Activity{}
+	query = `
+SELECT a.id, a.event_id, a.name, a.type, a.start_time, a.end_time, a.credits, a.updated_at, a.sequence
+FROM activities a
+JOIN events e ON e.id = a.event_id
+WHERE a.event_id = $1 AND e.calendar_token = $2 AND a.delete_at IS NULL
+ORDER BY a.start_time, a.id`
+	err = p.collect(ctx, query, func(rows *sql.Rows) error {
+		var a models.Activity
+		if err := rows.Scan(&a.ID, &a.EventID, &a.Name, &a.Type, &a.StartTime, &a.EndTime, &a.Credits, &a.UpdatedAt, &a.Sequence); err != nil {
+			return err
+		}
+		activities = append(activities, a)
+		return nil
+	}, eventId, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, activities, nil
+}
+
+// EnsureCalendarToken gives the event's feed token if it isn't set yet, and
+// returns whichever token the event ends up with.
+func (p *PostgresDB) EnsureCalendarToken(ctx context.Context, eventId uuid.UUID, token string) (string, error) {
+	query := `UPDATE events SET calendar_token = COALESCE(calendar_token, $2) WHERE id = $1 AND delete_at IS NULL RETURNING calendar_token`
+	err := p.sql.QueryRowContext(ctx, query, eventId, token).Scan(&token)
+	if err == sql.ErrNoRows {
+		return "", db.ErrNotFound
+	}
+	return token, err
+}
+
+// RotateCalendarToken replaces the feed token, so subscriptions made with
+// the old URL stop updating.
+func (p *PostgresDB) RotateCalendarToken(ctx context.Context, eventId uuid.UUID, token string) error {
+	res, err := p.sql.ExecContext(ctx, `UPDATE events SET calendar_token = $2 WHERE id = $1 AND delete_at IS NULL`, eventId, token)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	db "github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// DefaultCertificateTitle is used until an event saves a template of its own.
+const DefaultCertificateTitle = "Certificate of Attendance"
+
+func (p *PostgresDB) GetCertificateTemplate(ctx context.Context, eventId string) (*models.CertificateTemplate, error) {
+	tmpl := &models.CertificateTemplate{EventId: eventId}
+
+	query := `
+SELECT COALESCE(t.title, $2), COALESCE(t.signatory, ''), COALESCE(t.signatory_title, ''), t.logo
+FROM events e
+LEFT JOIN certificate_templates t ON t.event_id = e.id
+WHERE e.id = $1 AND e.delete_at IS NULL
+`
+	err := p.sql.QueryRowContext(ctx, query, eventId, DefaultCertificateTitle).
+		Scan(&tmpl.Title, &tmpl.Signatory, &tmpl.SignatoryTitle, &tmpl.Logo)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func (p *PostgresDB) SaveCertificateTemplate(ctx context.Context, tmpl *models.CertificateTemplate) error {
+	query := `
+INSERT INTO certificate_templates (event_id, title, signatory, signatory_title, logo)
+SELECT id, $2, $3, $4, $5 FROM events WHERE id = $1 AND delete_at IS NULL
+ON CONFLICT (event_id) DO UPDATE
+SET title = EXCLUDED.title, signatory = EXCLUDED.signatory,
+    signatory_title = EXCLUDED.signatory_title, logo = EXCLUDED.logo
+`
+	res, err := p.sql.ExecContext(ctx, query, tmpl.EventId, tmpl.Title, tmpl.Signatory, tmpl.SignatoryTitle, tmpl.Logo)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresDB) GetAttendedActivities(ctx context.Context, eventID uuid.UUID, userID uuid.UUID) ([]models.AttendedActivity, error) {
+	query := `
+SELECT c.user_id, a.name, a.start_time, a.end_time
+FROM check_in_logs c
+JOIN activities a ON a.id = c.activity_id
+JOIN users u ON u.id = c.user_id
+WHERE a.event_id = $1 AND c.status = 'checked'
+  AND a.delete_at IS NULL AND u.delete_at IS NULL`
+	args := []any{eventID}
+	if userID != uuid.Nil {
+		query += ` AND c.user_id = $2`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY c.user_id, a.start_time, a.name`
+
+	rows, err := p.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attended []models.AttendedActivity
+	for rows.Next() {
+		var a models.AttendedActivity
+		if err := rows.Scan(&a.UserId, &a.Name, &a.StartTime, &a.EndTime); err != nil {
+			return nil, err
+		}
+		attended = append(attended, a)
+	}
+	return attended, rows.Err()
+}
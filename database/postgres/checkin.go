@@ -1,68 +1,51 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	db "github.com/koiraladarwin/scanin/database"
 	"github.com/koiraladarwin/scanin/models"
 )
 
-func (p *PostgresDB) CreateCheckInLog(c *models.CheckInLog) error {
-	var id string
-	query := `INSERT INTO check_in_logs (user_id, activity_id, scanned_at, status, scanned_by)
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	return p.sql.QueryRow(query, c.UserID, c.ActivityID, c.ScannedAt, c.Status, c.ScannedBy).Scan(&id)
+func (p *PostgresDB) CreateCheckInLog(ctx context.Context, c *models.CheckInLog) error {
+	query := `INSERT INTO check_in_logs (user_id, activity_id, scanned_at, status, scanned_by, scanned_by_uid, checked_out_at)
+			  VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7) RETURNING id`
+	err := p.sql.QueryRowContext(ctx, query, c.UserID, c.ActivityID, c.ScannedAt, c.Status, c.ScannedBy, c.ScannedByUID, c.CheckedOutAt).Scan(&c.ID)
+	if isUniqueViolationError(err) {
+		return db.ErrAlreadyExists
+	}
+	return err
 }
 
-func (p *PostgresDB) GetCheckInLog(id uuid.UUID) (*models.CheckInLog, error) {
+func (p *PostgresDB) GetCheckInLog(ctx context.Context, id uuid.UUID) (*models.CheckInLog, error) {
 	c := &models.CheckInLog{}
-	query := `SELECT id, user_id, activity_id, scanned_at, status, scanned_by FROM check_in_logs WHERE id=$1`
-	err := p.sql.QueryRow(query, id).Scan(&c.ID, &c.UserID, &c.ActivityID, &c.ScannedAt, &c.Status, &c.ScannedBy)
+	query := `SELECT id, user_id, activity_id, scanned_at, status, scanned_by, COALESCE(scanned_by_uid, ''), checked_out_at FROM check_in_logs WHERE id=$1`
+	err := p.sql.QueryRowContext(ctx, query, id).Scan(&c.ID, &c.UserID, &c.ActivityID, &c.ScannedAt, &c.Status, &c.ScannedBy, &c.ScannedByUID, &c.CheckedOutAt)
 	return c, err
 }
 
-func (p *PostgresDB) GetAllCheckInLog() ([]models.CheckInLog, error) {
-	logs := []models.CheckInLog{}
-	query := `SELECT id, user_id, activity_id, scanned_at, status, scanned_by FROM check_in_logs`
-	rows, err := p.sql.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var log models.CheckInLog
-		err := rows.Scan(&log.ID, &log.UserID, &log.ActivityID, &log.ScannedAt, &log.Status, &log.ScannedBy)
-		if err != nil {
-			return nil, err
-		}
-		logs = append(logs, log)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return logs, nil
-}
-
-func (p *PostgresDB) UpdateCheckInLog(c *models.CheckInLog) error {
-	query := `UPDATE check_in_logs SET user_id=$1, activity_id=$2, scanned_at=$3, status=$4, scanned_by=$5 WHERE id=$6`
-	_, err := p.sql.Exec(query, c.UserID, c.ActivityID, c.ScannedAt, c.Status, c.ScannedBy, c.ID)
+func (p *PostgresDB) UpdateCheckInLog(ctx context.Context, c *models.CheckInLog) error {
+	query := `UPDATE check_in_logs SET user_id=$1, activity_id=$2, scanned_at=$3, status=$4, scanned_by=$5, scanned_by_uid=NULLIF($6, ''), checked_out_at=$7 WHERE id=$8`
+	_, err := p.sql.ExecContext(ctx, query, c.UserID, c.ActivityID, c.ScannedAt, c.Status, c.ScannedBy, c.ScannedByUID, c.CheckedOutAt, c.ID)
 	return err
 }
 
-func (p *PostgresDB) DeleteCheckInLog(id uuid.UUID) error {
-	_, err := p.sql.Exec(`DELETE FROM check_in_logs WHERE id=$1`, id)
+func (p *PostgresDB) DeleteCheckInLog(ctx context.Context, id uuid.UUID) error {
+	_, err := p.sql.ExecContext(ctx, `DELETE FROM check_in_logs WHERE id=$1`, id)
 	return err
 }
 
-func (p *PostgresDB) CheckInExists(attendeeID uuid.UUID, activityID uuid.UUID) (uuid.UUID, error) {
+func (p *PostgresDB) CheckInExists(ctx context.Context, attendeeID uuid.UUID, activityID uuid.UUID) (uuid.UUID, error) {
 	var id uuid.UUID
-	query := `SELECT id FROM check_in_logs WHERE user_id = $1 AND activity_id = $2`
-	err := p.sql.QueryRow(query, attendeeID, activityID).Scan(&id)
+	// FOR UPDATE holds the row until the surrounding transaction ends, so a
+	// concurrent scan of the same attendee waits instead of double-flipping it
+	query := `SELECT id FROM check_in_logs WHERE user_id = $1 AND activity_id = $2 FOR UPDATE`
+	err := p.sql.QueryRowContext(ctx, query, attendeeID, activityID).Scan(&id)
 
 	if err == sql.ErrNoRows {
 		return uuid.Nil, db.ErrNotFound
@@ -75,149 +58,136 @@ func (p *PostgresDB) CheckInExists(attendeeID uuid.UUID, activityID uuid.UUID) (
 	return id, nil
 }
 
-func (p *PostgresDB) GetAllCheckInOfEvents(eventID uuid.UUID) ([]models.CheckInLog, error) {
-  log.Print("Executing query to get all check-in logs: ")
-	var checkIns []models.CheckInLog
-	queryActivities := `SELECT id FROM activities WHERE event_id = $1`
-	rows, err := p.sql.Query(queryActivities, eventID)
-	if err != nil {
-		return nil, err
-	}
-
-	defer rows.Close()
-
-	for rows.Next() {
-		var activityID uuid.UUID
-		if err := rows.Scan(&activityID); err != nil {
-			return nil, err
-		}
-
-		queryCheckIn := `SELECT id, user_id, activity_id, scanned_at, status, scanned_by FROM check_in_logs WHERE activity_id = $1`
-		activityRows, err := p.sql.Query(queryCheckIn, activityID)
-		if err != nil {
-			return nil, err
-		}
-
-		for activityRows.Next() {
-			var checkIn models.CheckInLog
-			if err := activityRows.Scan(&checkIn.ID, &checkIn.UserID, &checkIn.ActivityID, &checkIn.ScannedAt, &checkIn.Status, &checkIn.ScannedBy); err != nil {
-				activityRows.Close()
-				return nil, err
-			}
-			checkIns = append(checkIns, checkIn)
-		}
-		activityRows.Close()
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return checkIns, nil
-}
-
-func (p *PostgresDB) GetAllCheckInOfUser(userID uuid.UUID) ([]models.CheckInRespose, error) {
-	var checkIns []models.CheckInRespose
-
-	query := `
-		SELECT 
+// checkInResponseQuery selects everything a CheckInRespose needs in one
+// round trip; callers append their own WHERE clause.
+const checkInResponseQuery = `
+		SELECT
 			c.id,
 			u.full_name,
-      u.auto_id,
-      u.role,
+			u.auto_id,
+			u.role,
 			c.user_id,
 			a.name as activity_name,
 			c.activity_id,
 			c.scanned_at,
 			c.status,
-			c.scanned_by
+			c.scanned_by,
+			COALESCE(c.scanned_by_uid, ''),
+			u.company,
+			a.start_time,
+			a.end_time,
+			COALESCE(bp.prefix, ''),
+			e.auto_id_padding
 		FROM check_in_logs c
 		JOIN users u ON u.id = c.user_id
 		JOIN activities a ON a.id = c.activity_id
-		WHERE c.user_id = $1
-	`
+		JOIN events e ON e.id = a.event_id
+		LEFT JOIN auto_id_prefixes bp ON bp.event_id = u.event_id AND bp.role = u.role
+`
+
+func scanCheckInResponse(rows *sql.Rows) (models.CheckInRespose, error) {
+	var checkIn models.CheckInRespose
+	var autoId, padding int
+	var prefix string
+	err := rows.Scan(
+		&checkIn.ID,
+		&checkIn.FullName,
+		&autoId,
+		&checkIn.Role,
+		&checkIn.UserID,
+		&checkIn.ActivityName,
+		&checkIn.ActivityID,
+		&checkIn.ScannedAt,
+		&checkIn.Status,
+		&checkIn.ScannedBy,
+		&checkIn.ScannedByUID,
+		&checkIn.Company,
+		&checkIn.ActivityStart,
+		&checkIn.ActivityEnd,
+		&prefix,
+		&padding,
+	)
+	checkIn.AutoId = strconv.Itoa(autoId)
+	checkIn.BadgeNumber = formatBadgeNumber(prefix, padding, autoId)
+	return checkIn, err
+}
 
-	rows, err := p.sql.Query(query, userID)
+// eachCheckIn runs query and hands rows to fn one at a time, so large events
+// can be written out without holding every check-in in memory.
+func (p *PostgresDB) eachCheckIn(ctx context.Context, fn func(*models.CheckInRespose) error, query string, args ...any) error {
+	rows, err := p.sql.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var checkIn models.CheckInRespose
-		if err := rows.Scan(
-			&checkIn.ID,
-			&checkIn.FullName,
-			&checkIn.AutoId,
-			&checkIn.Role,
-			&checkIn.UserID,
-			&checkIn.ActivityName,
-			&checkIn.ActivityID,
-			&checkIn.ScannedAt,
-			&checkIn.Status,
-			&checkIn.ScannedBy,
-		); err != nil {
-			return nil, err
+		checkIn, err := scanCheckInResponse(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(&checkIn); err != nil {
+			return err
 		}
-		checkIns = append(checkIns, checkIn)
 	}
 
-	if err := rows.Err(); err != nil {
+	return rows.Err()
+}
+
+func (p *PostgresDB) listCheckIns(ctx context.Context, query string, args ...any) ([]models.CheckInRespose, error) {
+	checkIns := []models.CheckInRespose{}
+	err := p.eachCheckIn(ctx, func(c *models.CheckInRespose) error {
+		checkIns = append(checkIns, *c)
+		return nil
+	}, query, args...)
+	if err != nil {
 		return nil, err
 	}
-
 	return checkIns, nil
 }
 
-func (p *PostgresDB) GetAllCheckInOfActivity(activityID uuid.UUID) ([]models.CheckInRespose, error) {
-	var checkIns []models.CheckInRespose
-
-	query := `
-		SELECT 
-			c.id,
-			u.full_name,
-      u.auto_id,
-      u.role,
-			c.user_id,
-			a.name as activity_name,
-			c.activity_id,
-			c.scanned_at,
-			c.status,
-			c.scanned_by
-		FROM check_in_logs c
-		JOIN users u ON u.id = c.user_id
-		JOIN activities a ON a.id = c.activity_id
-		WHERE c.activity_id = $1
-	`
+func (p *PostgresDB) EachCheckIn(ctx context.Context, fn func(*models.CheckInRespose) error) error {
+	return p.eachCheckIn(ctx, fn, checkInResponseQuery+` ORDER BY c.scanned_at`)
+}
 
-	rows, err := p.sql.Query(query, activityID)
-	if err != nil {
-		return nil, err
+// EachCheckInOfEvent streams the event's check-ins matching filter, oldest
+// scan first.
+func (p *PostgresDB) EachCheckInOfEvent(ctx context.Context, eventID uuid.UUID, filter models.CheckInFilter, fn func(*models.CheckInRespose) error) error {
+	where := []string{"a.event_id = $1"}
+	args := []any{eventID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var checkIn models.CheckInRespose
-		if err := rows.Scan(
-			&checkIn.ID,
-			&checkIn.FullName,
-			&checkIn.AutoId,
-			&checkIn.Role,
-			&checkIn.UserID,
-			&checkIn.ActivityName,
-			&checkIn.ActivityID,
-			&checkIn.ScannedAt,
-			&checkIn.Status,
-			&checkIn.ScannedBy,
-		); err != nil {
-			return nil, err
-		}
-		checkIns = append(checkIns, checkIn)
+	if filter.ActivityID != nil {
+		add("c.activity_id = $%d", *filter.ActivityID)
 	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
+	if filter.From != nil {
+		add("c.scanned_at >= $%d", *filter.From)
 	}
+	if filter.To != nil {
+		add("c.scanned_at < $%d", *filter.To)
+	}
+	if filter.Status != "" {
+		add("c.status = $%d", filter.Status)
+	}
+	if filter.Role != "" {
+		add("u.role = $%d", filter.Role)
+	}
+	if filter.ScannedBy != "" {
+		add("c.scanned_by = $%d", filter.ScannedBy)
+	}
+	if filter.ScannedByUID != "" {
+		add("c.scanned_by_uid = $%d", filter.ScannedByUID)
+	}
+	query := checkInResponseQuery + ` WHERE ` + strings.Join(where, " AND ") + ` ORDER BY c.scanned_at`
+	return p.eachCheckIn(ctx, fn, query, args...)
+}
 
-	return checkIns, nil
+func (p *PostgresDB) GetAllCheckInOfUser(ctx context.Context, userID uuid.UUID) ([]models.CheckInRespose, error) {
+	return p.listCheckIns(ctx, checkInResponseQuery+` WHERE c.user_id = $1`, userID)
+}
+
+func (p *PostgresDB) GetAllCheckInOfActivity(ctx context.Context, activityID uuid.UUID) ([]models.CheckInRespose, error) {
+	return p.listCheckIns(ctx, checkInResponseQuery+` WHERE c.activity_id = $1`, activityID)
 }
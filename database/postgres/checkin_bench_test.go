@@ -0,0 +1,236 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"flag"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// The check-in benchmarks need a Postgres database they may write to:
+//
+//	POSTGRESS_URL=postgres://... go test ./database/postgres -run '^$' -bench CheckIns -bench.attendees 5000
+//
+// Both strategies read the same seeded event, which is deleted afterwards.
+var (
+	benchAttendees  = flag.Int("bench.attendees", 2000, "attendees seeded for the check-in benchmarks")
+	benchActivities = flag.Int("bench.activities", 2, "activities seeded; every attendee is checked into each")
+)
+
+// queries counts the statements sent through the "pgx-counting" driver.
+var queries atomic.Int64
+
+var registerCounting sync.Once
+
+// pgxConn is the set of optional interfaces the pgx stdlib connection
+// implements; the wrapper has to expose them all or database/sql falls back
+// to slower prepare-then-execute paths and the numbers stop being comparable.
+type pgxConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.NamedValueChecker
+	driver.SessionResetter
+}
+
+type countingDriver struct {
+	driver.Driver
+}
+
+func (d countingDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{c.(pgxConn)}, nil
+}
+
+type countingConn struct {
+	pgxConn
+}
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queries.Add(1)
+	return c.pgxConn.QueryContext(ctx, query, args)
+}
+
+func (c countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	queries.Add(1)
+	return c.pgxConn.ExecContext(ctx, query, args)
+}
+
+// BenchmarkEventCheckIns compares listing an event's check-ins the way the
+// handlers used to, one query per activity and then two per row, with the
+// single joined query they use now.
+func BenchmarkEventCheckIns(b *testing.B) {
+	ctx := context.Background()
+	p, eventId := checkInFixture(b, *benchAttendees, *benchActivities)
+	want := *benchAttendees * *benchActivities
+
+	strategies := []struct {
+		name string
+		list func(context.Context, *PostgresDB, uuid.UUID) (int, error)
+	}{
+		{"per_activity_lookups", perActivityLookups},
+		{"joined", joinedCheckIns},
+	}
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			before := queries.Load()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n, err := s.list(ctx, p, eventId)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n != want {
+					b.Fatalf("listed %d check-ins, want %d", n, want)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(queries.Load()-before)/float64(b.N), "queries/op")
+			b.ReportMetric(float64(want), "rows/op")
+		})
+	}
+}
+
+// perActivityLookups is the old path: the event's activities, the logs of
+// each activity in turn, then the attendee and activity of every log.
+func perActivityLookups(ctx context.Context, p *PostgresDB, eventId uuid.UUID) (int, error) {
+	var activityIds []uuid.UUID
+	err := p.collect(ctx, `SELECT id FROM activities WHERE event_id = $1`, func(rows *sql.Rows) error {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		activityIds = append(activityIds, id)
+		return nil
+	}, eventId)
+	if err != nil {
+		return 0, err
+	}
+
+	var logs []models.CheckInLog
+	for _, activityId := range activityIds {
+		query := `SELECT id, user_id, activity_id, scanned_at, status, scanned_by FROM check_in_logs WHERE activity_id = $1`
+		err := p.collect(ctx, query, func(rows *sql.Rows) error {
+			var c models.CheckInLog
+			if err := rows.Scan(&c.ID, &c.UserID, &c.ActivityID, &c.ScannedAt, &c.Status, &c.ScannedBy); err != nil {
+				return err
+			}
+			logs = append(logs, c)
+			return nil
+		}, activityId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	responses := make([]models.CheckInRespose, 0, len(logs))
+	for _, l := range logs {
+		user, err := p.GetUser(ctx, l.UserID)
+		if err != nil {
+			return 0, err
+		}
+		activity, err := p.GetActivity(ctx, l.ActivityID)
+		if err != nil {
+			return 0, err
+		}
+		responses = append(responses, models.CheckInRespose{
+			ID:           l.ID,
+			FullName:     user.FullName,
+			UserID:       l.UserID,
+			ActivityName: activity.Name,
+			ActivityID:   l.ActivityID,
+			ScannedAt:    l.ScannedAt,
+			ScannedBy:    l.ScannedBy,
+			Status:       l.Status,
+		})
+	}
+	return len(responses), nil
+}
+
+func joinedCheckIns(ctx context.Context, p *PostgresDB, eventId uuid.UUID) (int, error) {
+	n := 0
+	err := p.EachCheckInOfEvent(ctx, eventId, models.CheckInFilter{}, func(c *models.CheckInRespose) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// checkInFixture connects through the counting driver and seeds an event
+// with attendees checked into every activity, removed when b finishes.
+func checkInFixture(b *testing.B, attendees, activities int) (*PostgresDB, uuid.UUID) {
+	b.Helper()
+	connStr := os.Getenv("POSTGRESS_URL")
+	if connStr == "" {
+		b.Skip("POSTGRESS_URL is not set")
+	}
+
+	ctx := context.Background()
+	registerCounting.Do(func() {
+		sql.Register("pgx-counting", countingDriver{stdlib.GetDefaultDriver()})
+	})
+	pool, err := sql.Open("pgx-counting", connStr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { pool.Close() })
+	store, err := NewPostgresDB(ctx, pool)
+	if err != nil {
+		b.Fatal(err)
+	}
+	p := store.(*PostgresDB)
+
+	var eventId uuid.UUID
+	err = pool.QueryRowContext(ctx, `
+		INSERT INTO events (name, description, start_time, end_time, location, staff_code, admin_code)
+		VALUES ('checkin benchmark', 'seeded by BenchmarkEventCheckIns', now(), now() + interval '1 day', 'bench', $1, $2)
+		RETURNING id`, uuid.NewString()[:6], uuid.NewString()[:7]).Scan(&eventId)
+	if err != nil {
+		b.Fatalf("seed event: %v", err)
+	}
+	b.Cleanup(func() {
+		// users don't cascade from events, check_in_logs cascade from users
+		if _, err := pool.ExecContext(ctx, `DELETE FROM users WHERE event_id = $1`, eventId); err != nil {
+			b.Errorf("cleanup users: %v", err)
+		}
+		if _, err := pool.ExecContext(ctx, `DELETE FROM events WHERE id = $1`, eventId); err != nil {
+			b.Errorf("cleanup event: %v", err)
+		}
+	})
+
+	stmts := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO activities (event_id, name, type, start_time, end_time)
+		  SELECT $1, 'activity ' || g, 'session', now(), now() + interval '1 hour'
+		  FROM generate_series(1, $2) g`, []any{eventId, activities}},
+		{`INSERT INTO users (auto_id, full_name, image_url, company, position, role, event_id)
+		  SELECT g, 'attendee ' || g, '', 'company', 'position', 'bench', $1
+		  FROM generate_series(1, $2) g`, []any{eventId, attendees}},
+		{`INSERT INTO check_in_logs (user_id, activity_id, scanned_at, status, scanned_by)
+		  SELECT u.id, a.id, now(), 'checked', 'bench@example.com'
+		  FROM users u CROSS JOIN activities a
+		  WHERE u.event_id = $1 AND a.event_id = $1`, []any{eventId}},
+	}
+	for _, stmt := range stmts {
+		if _, err := pool.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			b.Fatalf("seed %.40s...: %v", stmt.query, err)
+		}
+	}
+	return p, eventId
+}
@@ -0,0 +1,39 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+func (p *PostgresDB) GetCreditScans(ctx context.Context, eventID uuid.UUID, userID uuid.UUID) ([]models.CreditScan, error) {
+	query := `
+SELECT c.user_id, c.activity_id, c.scanned_at, c.checked_out_at
+FROM check_in_logs c
+JOIN activities a ON a.id = c.activity_id
+JOIN users u ON u.id = c.user_id
+WHERE a.event_id = $1 AND c.status = 'checked'
+  AND a.delete_at IS NULL AND u.delete_at IS NULL`
+	args := []any{eventID}
+	if userID != uuid.Nil {
+		query += ` AND c.user_id = $2`
+		args = append(args, userID)
+	}
+
+	rows, err := p.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scans []models.CreditScan
+	for rows.Next() {
+		var s models.CreditScan
+		if err := rows.Scan(&s.UserID, &s.ActivityID, &s.CheckedInAt, &s.CheckedOutAt); err != nil {
+			return nil, err
+		}
+		scans = append(scans, s)
+	}
+	return scans, rows.Err()
+}
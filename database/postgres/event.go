@@ -1,25 +1,26 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
-	"log"
 
 	"github.com/google/uuid"
 	"github.com/koiraladarwin/scanin/models"
 	"github.com/koiraladarwin/scanin/utils"
 )
 
-func (p *PostgresDB) CreateEvent(e *models.EventCreateRequest) error {
-	var id string
+func (p *PostgresDB) CreateEvent(ctx context.Context, e *models.EventCreateRequest) (uuid.UUID, error) {
+	var id uuid.UUID
 	var staff_code = utils.RandomString(6)
 	var admin_code = utils.RandomString(7)
 
 	query := `INSERT INTO events (name, description, start_time, end_time, location, staff_code, admin_code) 
 			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
-	return p.sql.QueryRow(query, e.Name, e.Description, e.StartTime, e.EndTime, e.Location, staff_code, admin_code).Scan(&id)
+	err := p.sql.QueryRowContext(ctx, query, e.Name, e.Description, e.StartTime, e.EndTime, e.Location, staff_code, admin_code).Scan(&id)
+	return id, err
 }
 
-func (p *PostgresDB) GetEventsByFirebaseUser(firebaseUser string) ([]models.Event, error) {
+func (p *PostgresDB) GetEventsByFirebaseUser(ctx context.Context, firebaseUser string) ([]models.Event, error) {
 	query := `
 SELECT 
     e.id,
@@ -36,19 +37,19 @@ SELECT
       END
     ) AS staff_code,
     CASE
-      WHEN MAX(CASE WHEN er.isCreator = true OR er.canSeeAttendee THEN 1 ELSE 0 END) = 1 THEN
+      WHEN MAX(CASE WHEN has_event_permission(er.isCreator, er.role, er.permissions, er.event_id, 'attendee.read') THEN 1 ELSE 0 END) = 1 THEN
         COUNT(DISTINCT u.id)
       ELSE
         -1
     END AS number_of_participants
 FROM events e
-JOIN eventRoles er ON e.id = er.event_id
+JOIN event_principals er ON e.id = er.event_id
 LEFT JOIN users u ON u.event_id = e.id
 WHERE er.fireBaseId = $1 AND e.delete_at IS NULL
 GROUP BY e.id, e.name, e.description, e.start_time, e.end_time, e.location;
 
 `
-	rows, err := p.sql.Query(query, firebaseUser)
+	rows, err := p.sql.QueryContext(ctx, query, firebaseUser)
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +72,6 @@ GROUP BY e.id, e.name, e.description, e.start_time, e.end_time, e.location;
 			return nil, err
 		}
 		events = append(events, e)
-		log.Printf("Event fetched: %+v\n", e)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -81,7 +81,7 @@ GROUP BY e.id, e.name, e.description, e.start_time, e.end_time, e.location;
 	return events, nil
 }
 
-func (p *PostgresDB) GetEventByFirebaseUser(firebaseId string, eventId uuid.UUID) (*models.Event, error) {
+func (p *PostgresDB) GetEventByFirebaseUser(ctx context.Context, firebaseId string, eventId uuid.UUID) (*models.Event, error) {
 	e := &models.Event{}
 
 	query := `
@@ -94,17 +94,17 @@ SELECT
   e.location,
   CASE WHEN er.isCreator THEN e.staff_code ELSE NULL END AS staff_code,
   CASE
-    WHEN er.isCreator OR er.canSeeAttendee THEN (
+    WHEN has_event_permission(er.isCreator, er.role, er.permissions, er.event_id, 'attendee.read') THEN (
       SELECT COUNT(*) FROM users WHERE event_id = e.id
     )
     ELSE -1
   END AS number_of_participant
 FROM events e
-JOIN eventRoles er ON e.id = er.event_id
+JOIN event_principals er ON e.id = er.event_id
 WHERE e.id = $1 AND er.fireBaseId = $2 AND e.delete_at IS NULL
 `
 
-	err := p.sql.QueryRow(query, eventId, firebaseId).Scan(
+	err := p.sql.QueryRowContext(ctx, query, eventId, firebaseId).Scan(
 		&e.ID,
 		&e.Name,
 		&e.Description,
@@ -117,47 +117,45 @@ WHERE e.id = $1 AND er.fireBaseId = $2 AND e.delete_at IS NULL
 	if err != nil {
 		return nil, err
 	}
-
-	fmt.Printf("number: %d \n", e.NumberOfParticipant)
 	return e, nil
 }
 
-func (p *PostgresDB) GetEventByStaffId(id string) (*models.Event, error) {
+func (p *PostgresDB) GetEventByStaffId(ctx context.Context, id string) (*models.Event, error) {
 	e := &models.Event{}
 	query := `SELECT id, name, description, start_time, end_time, location FROM events WHERE staff_code = $1 AND delete_at IS NULL`
-	err := p.sql.QueryRow(query, id).Scan(&e.ID, &e.Name, &e.Description, &e.StartTime, &e.EndTime, &e.Location)
+	err := p.sql.QueryRowContext(ctx, query, id).Scan(&e.ID, &e.Name, &e.Description, &e.StartTime, &e.EndTime, &e.Location)
 	return e, err
 }
 
-func (p *PostgresDB) GetEventByAdminId(id string) (*models.Event, error) {
+func (p *PostgresDB) GetEventByAdminId(ctx context.Context, id string) (*models.Event, error) {
 	e := &models.Event{}
 	query := `SELECT id, name, description, start_time, end_time, location FROM events WHERE admin_code = $1 And delete_at IS NULL`
-	err := p.sql.QueryRow(query, id).Scan(&e.ID, &e.Name, &e.Description, &e.StartTime, &e.EndTime, &e.Location)
+	err := p.sql.QueryRowContext(ctx, query, id).Scan(&e.ID, &e.Name, &e.Description, &e.StartTime, &e.EndTime, &e.Location)
 	return e, err
 }
 
-func (p *PostgresDB) UpdateEvent(e *models.EventModifyRequest) error {
+func (p *PostgresDB) UpdateEvent(ctx context.Context, e *models.EventModifyRequest) error {
 	query := `UPDATE events SET name=$1, description=$2,location=$3 WHERE id=$4`
-	_, err := p.sql.Exec(query, e.Name, e.Description, e.Location, e.ID)
+	_, err := p.sql.ExecContext(ctx, query, e.Name, e.Description, e.Location, e.ID)
 	return err
 }
 
-func (p *PostgresDB) DeleteEvent(id uuid.UUID) error {
-	_, err := p.sql.Exec(`DELETE FROM events WHERE id=$1`, id)
+func (p *PostgresDB) DeleteEvent(ctx context.Context, id uuid.UUID) error {
+	_, err := p.sql.ExecContext(ctx, `DELETE FROM events WHERE id=$1`, id)
 	return err
 }
 
-func (p *PostgresDB) EventExists(eventID uuid.UUID) (bool, error) {
+func (p *PostgresDB) EventExists(ctx context.Context, eventID uuid.UUID) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM events WHERE id = $1 AND delete_at IS NULL)`
-	err := p.sql.QueryRow(query, eventID).Scan(&exists)
+	err := p.sql.QueryRowContext(ctx, query, eventID).Scan(&exists)
 	return exists, err
 }
 
-func (p *PostgresDB) GetAllEvents() ([]models.Event, error) {
+func (p *PostgresDB) GetAllEvents(ctx context.Context) ([]models.Event, error) {
 	query := `SELECT id, name, description, start_time, end_time, location FROM events ORDER BY start_time WHERE delete_at IS NULL`
 
-	rows, err := p.sql.Query(query)
+	rows, err := p.sql.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -179,11 +177,11 @@ func (p *PostgresDB) GetAllEvents() ([]models.Event, error) {
 	return events, nil
 }
 
-func (p *PostgresDB) GetEventIdByActivity(acitvity uuid.UUID) (uuid.UUID, error) {
+func (p *PostgresDB) GetEventIdByActivity(ctx context.Context, acitvity uuid.UUID) (uuid.UUID, error) {
 	event := models.Event{}
 
 	query := `SELECT event_id FROM activities WHERE id = $1 limit 1`
-	err := p.sql.QueryRow(query, acitvity).Scan(&event.ID)
+	err := p.sql.QueryRowContext(ctx, query, acitvity).Scan(&event.ID)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("failed to get event id by activity: %w", err)
 	}
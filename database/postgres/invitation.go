@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+const invitationColumns = `id, event_id, code, email, COALESCE(role, ''), array_to_string(permissions, ','),
+	max_uses, uses, expires_at, created_by, created_at, revoked_at`
+
+func scanInvitation(row interface{ Scan(...any) error }, inv *models.Invitation) error {
+	var granted string
+	err := row.Scan(&inv.ID, &inv.EventId, &inv.Code, &inv.Email, &inv.Role, &granted,
+		&inv.MaxUses, &inv.Uses, &inv.ExpiresAt, &inv.CreatedBy, &inv.CreatedAt, &inv.RevokedAt)
+	inv.Permissions = permissions.FromList(granted)
+	return err
+}
+
+func (p *PostgresDB) CreateInvitation(ctx context.Context, inv *models.Invitation) error {
+	inv.Code = utils.RandomString(models.InvitationCodeLength)
+	query := `INSERT INTO invitations (event_id, code, email, role, permissions, max_uses, expires_at, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5::text[], $6, $7, $8)
+		RETURNING id, uses, created_at`
+	err := p.sql.QueryRowContext(ctx, query, inv.EventId, inv.Code, inv.Email, inv.Role, permissions.ToArray(inv.Permissions),
+		inv.MaxUses, inv.ExpiresAt, inv.CreatedBy).Scan(&inv.ID, &inv.Uses, &inv.CreatedAt)
+	if isUniqueViolationError(err) {
+		return db.ErrAlreadyExists
+	}
+	return err
+}
+
+func (p *PostgresDB) GetInvitation(ctx context.Context, id uuid.UUID) (*models.Invitation, error) {
+	inv := &models.Invitation{}
+	err := scanInvitation(p.sql.QueryRowContext(ctx, `SELECT `+invitationColumns+` FROM invitations WHERE id = $1`, id), inv)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, db.ErrNotFound
+	}
+	return inv, err
+}
+
+// GetInvitationByCode locks the invitation, so inside WithTx concurrent
+// redemptions of the same code can't both take the last use.
+func (p *PostgresDB) GetInvitationByCode(ctx context.Context, code string) (*models.Invitation, error) {
+	inv := &models.Invitation{}
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE code = $1 FOR UPDATE`
+	err := scanInvitation(p.sql.QueryRowContext(ctx, query, code), inv)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, db.ErrNotFound
+	}
+	return inv, err
+}
+
+func (p *PostgresDB) GetInvitationsByEvent(ctx context.Context, eventId uuid.UUID) ([]models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE event_id = $1 ORDER BY created_at DESC`
+	rows, err := p.sql.QueryContext(ctx, query, eventId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		var inv models.Invitation
+		if err := scanInvitation(rows, &inv); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+func (p *PostgresDB) RevokeInvitation(ctx context.Context, id uuid.UUID) error {
+	res, err := p.sql.ExecContext(ctx, `UPDATE invitations SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresDB) UseInvitation(ctx context.Context, id uuid.UUID) error {
+	_, err := p.sql.ExecContext(ctx, `UPDATE invitations SET uses = uses + 1 WHERE id = $1`, id)
+	return err
+}
+
+// RotateEventCodes replaces both join codes, so anyone holding an old one can
+// no longer use it. Existing staff keep their roles.
+func (p *PostgresDB) RotateEventCodes(ctx context.Context, eventId uuid.UUID) (*models.EventCodes, error) {
+	codes := &models.EventCodes{
+		StaffCode: utils.RandomString(6),
+		AdminCode: utils.RandomString(7),
+	}
+	res, err := p.sql.ExecContext(ctx, `UPDATE events SET staff_code = $1, admin_code = $2 WHERE id = $3 AND delete_at IS NULL`,
+		codes.StaffCode, codes.AdminCode, eventId)
+	if isUniqueViolationError(err) {
+		return nil, db.ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, db.ErrNotFound
+	}
+	return codes, nil
+}
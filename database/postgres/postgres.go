@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -9,31 +10,96 @@ import (
 	"github.com/koiraladarwin/scanin/database"
 )
 
+// querier is the subset of *sql.DB and *sql.Tx the queries need, so the same
+// methods run either directly on the pool or inside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type PostgresDB struct {
-	sql *sql.DB
+	pool *sql.DB
+	sql  querier
+	inTx bool
 }
 
-func ConnectPostgres(connStr string) (db.Database, error) {
+func ConnectPostgres(ctx context.Context, connStr string) (db.Database, error) {
 
 	db, err := sql.Open("pgx", connStr)
 	if err != nil {
 		return nil, err
 	}
-	if err := db.Ping(); err != nil {
+	return NewPostgresDB(ctx, db)
+}
+
+// NewPostgresDB wraps an already opened pool, for callers that need control
+// over the driver (e.g. the query-counting one in the check-in benchmark).
+func NewPostgresDB(ctx context.Context, pool *sql.DB) (db.Database, error) {
+	if err := pool.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	p := &PostgresDB{pool: pool, sql: pool}
+	if err := p.createTables(ctx); err != nil {
 		return nil, err
 	}
-	p := &PostgresDB{sql: db}
-	if err := p.createTables(); err != nil {
+	if err := p.seedRoleTemplates(ctx); err != nil {
 		return nil, err
 	}
 	return p, nil
 }
 
 func (p *PostgresDB) Close() error {
-	return p.sql.Close()
+	return p.pool.Close()
+}
+
+func (p *PostgresDB) Ping(ctx context.Context) error {
+	return p.pool.PingContext(ctx)
+}
+
+func (p *PostgresDB) Stats() sql.DBStats {
+	return p.pool.Stats()
+}
+
+func (p *PostgresDB) WithTx(ctx context.Context, fn func(tx db.Database) error) error {
+	return p.inTransaction(ctx, func(tx *PostgresDB) error {
+		return fn(tx)
+	})
+}
+
+// inTransaction is WithTx for code inside this package that needs the
+// concrete *PostgresDB (and its querier) rather than the interface.
+func (p *PostgresDB) inTransaction(ctx context.Context, fn func(tx *PostgresDB) error) (err error) {
+	if p.inTx {
+		return fn(p)
+	}
+
+	tx, err := p.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(&PostgresDB{pool: p.pool, sql: tx, inTx: true}); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
-func (p *PostgresDB) createTables() error {
+func (p *PostgresDB) createTables(ctx context.Context) error {
 	stmts := []string{
 		`CREATE EXTENSION IF NOT EXISTS "pgcrypto";`,
 
@@ -101,10 +167,266 @@ func (p *PostgresDB) createTables() error {
 			scanned_by TEXT NOT NULL ,
       UNIQUE (user_id, activity_id)
 		);`,
+
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS auto_id_start INT NOT NULL DEFAULT 1;`,
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS auto_id_padding INT NOT NULL DEFAULT 0;`,
+
+		`CREATE TABLE IF NOT EXISTS auto_id_sequences (
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			role TEXT NOT NULL,
+			last_value INT NOT NULL,
+			PRIMARY KEY (event_id, role)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS auto_id_prefixes (
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			role TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			PRIMARY KEY (event_id, role)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS invitations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			code TEXT NOT NULL UNIQUE,
+			email TEXT,
+			canCreateAttendee BOOLEAN NOT NULL DEFAULT false,
+			canSeeAttendee BOOLEAN NOT NULL DEFAULT false,
+			canSeeScanned BOOLEAN NOT NULL DEFAULT false,
+			max_uses INT NOT NULL DEFAULT 1,
+			uses INT NOT NULL DEFAULT 0,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			revoked_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS invitations_event_id_idx ON invitations (event_id);`,
+
+		// isCreator grants every permission on the event; isOwner marks the one
+		// creator who can hand the event over to someone else
+		`ALTER TABLE eventRoles ADD COLUMN IF NOT EXISTS isOwner BOOLEAN NOT NULL DEFAULT false;`,
+		`UPDATE eventRoles r SET isOwner = true
+			WHERE r.isCreator AND NOT EXISTS (
+				SELECT 1 FROM eventRoles o WHERE o.event_id = r.event_id AND o.isOwner
+			);`,
+
+		// permissions replace the canSeeScanned/canCreateActivity/
+		// canCreateAttendee/canSeeAttendee flags: a member holds a role
+		// template plus any directly granted actions. The flag columns are
+		// migrated once (while permissions is still NULL) and no longer read.
+		`CREATE TABLE IF NOT EXISTS role_templates (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			event_id UUID REFERENCES events(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			permissions TEXT[] NOT NULL DEFAULT '{}'
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS role_templates_builtin_name_idx ON role_templates (name) WHERE event_id IS NULL;`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS role_templates_event_name_idx ON role_templates (event_id, name) WHERE event_id IS NOT NULL;`,
+
+		`ALTER TABLE eventRoles ADD COLUMN IF NOT EXISTS role TEXT;`,
+		`ALTER TABLE eventRoles ADD COLUMN IF NOT EXISTS permissions TEXT[];`,
+		`UPDATE eventRoles SET permissions = array_remove(ARRAY[
+				CASE WHEN canSeeScanned THEN 'checkin.read' END,
+				CASE WHEN canCreateActivity THEN 'activity.write' END,
+				CASE WHEN canCreateAttendee THEN 'attendee.write' END,
+				CASE WHEN canSeeAttendee THEN 'attendee.read' END,
+				'checkin.create'
+			], NULL)
+			WHERE permissions IS NULL;`,
+		`ALTER TABLE eventRoles ALTER COLUMN permissions SET DEFAULT '{}';`,
+		`ALTER TABLE eventRoles ALTER COLUMN permissions SET NOT NULL;`,
+		// one role per member per event, for ON CONFLICT when adding staff
+		`CREATE UNIQUE INDEX IF NOT EXISTS eventroles_firebaseid_event_id_idx ON eventRoles (fireBaseId, event_id);`,
+
+		`ALTER TABLE invitations ADD COLUMN IF NOT EXISTS role TEXT;`,
+		`ALTER TABLE invitations ADD COLUMN IF NOT EXISTS permissions TEXT[];`,
+		`UPDATE invitations SET permissions = array_remove(ARRAY[
+				CASE WHEN canSeeScanned THEN 'checkin.read' END,
+				CASE WHEN canCreateAttendee THEN 'attendee.write' END,
+				CASE WHEN canSeeAttendee THEN 'attendee.read' END,
+				'checkin.create'
+			], NULL)
+			WHERE permissions IS NULL;`,
+		`ALTER TABLE invitations ALTER COLUMN permissions SET DEFAULT '{}';`,
+		`ALTER TABLE invitations ALTER COLUMN permissions SET NOT NULL;`,
+
+		`CREATE OR REPLACE FUNCTION has_event_permission(is_creator BOOLEAN, role_name TEXT, granted TEXT[], ev UUID, action TEXT)
+			RETURNS BOOLEAN LANGUAGE sql STABLE AS $$
+				SELECT is_creator OR action = ANY(granted) OR EXISTS (
+					SELECT 1 FROM role_templates t
+					WHERE t.name = role_name AND (t.event_id IS NULL OR t.event_id = ev) AND action = ANY(t.permissions)
+				)
+			$$;`,
+
+		// audit_log is append-only: the trigger rejects updates and deletes,
+		// and event_id has no foreign key so the history outlives the event.
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			event_id UUID NOT NULL,
+			actor_uid TEXT NOT NULL,
+			actor_email TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id TEXT NOT NULL DEFAULT '',
+			before JSONB,
+			after JSONB,
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_event_id_idx ON audit_log (event_id, id DESC);`,
+		`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_log is append-only';
+			END
+			$$;`,
+		`DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;`,
+		`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();`,
+
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS badge_columns INT NOT NULL DEFAULT 2;`,
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS badge_rows INT NOT NULL DEFAULT 4;`,
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS badge_color TEXT NOT NULL DEFAULT '#374151';`,
+
+		`CREATE TABLE IF NOT EXISTS badge_role_colors (
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			role TEXT NOT NULL,
+			color TEXT NOT NULL,
+			PRIMARY KEY (event_id, role)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS certificate_templates (
+			event_id UUID PRIMARY KEY REFERENCES events(id) ON DELETE CASCADE,
+			title TEXT NOT NULL,
+			signatory TEXT NOT NULL DEFAULT '',
+			signatory_title TEXT NOT NULL DEFAULT '',
+			logo BYTEA
+		);`,
+
+		`ALTER TABLE activities ADD COLUMN IF NOT EXISTS credits NUMERIC(6,2) NOT NULL DEFAULT 0;`,
+		`ALTER TABLE activities ADD COLUMN IF NOT EXISTS credit_rule TEXT NOT NULL DEFAULT 'check_in';`,
+		`ALTER TABLE activities ADD COLUMN IF NOT EXISTS min_dwell_percent INT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE check_in_logs ADD COLUMN IF NOT EXISTS checked_out_at TIMESTAMP;`,
+
+		// scanned_by keeps the scanner's email as it was at the time; the
+		// UID is what identifies them. Rows from before the column existed
+		// are filled in by cmd/backfillscanners.
+		`ALTER TABLE check_in_logs ADD COLUMN IF NOT EXISTS scanned_by_uid TEXT;`,
+		`CREATE INDEX IF NOT EXISTS check_in_logs_scanned_by_uid_idx ON check_in_logs (scanned_by_uid);`,
+
+		`CREATE TABLE IF NOT EXISTS rejected_scans (
+			id BIGSERIAL PRIMARY KEY,
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			activity_id UUID NOT NULL REFERENCES activities(id) ON DELETE CASCADE,
+			attendee_id UUID NOT NULL,
+			staff_uid TEXT NOT NULL,
+			staff_email TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL,
+			scanned_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS rejected_scans_event_id_idx ON rejected_scans (event_id, staff_uid);`,
+
+		`CREATE TABLE IF NOT EXISTS report_schedules (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			cron TEXT NOT NULL DEFAULT '',
+			time_zone TEXT NOT NULL DEFAULT 'UTC',
+			format TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			next_run_at TIMESTAMPTZ,
+			last_run_at TIMESTAMPTZ,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS report_schedules_next_run_at_idx ON report_schedules (next_run_at) WHERE enabled;`,
+
+		`CREATE TABLE IF NOT EXISTS report_subscriptions (
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			fireBaseId TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (event_id, fireBaseId)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS report_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			schedule_id UUID REFERENCES report_schedules(id) ON DELETE SET NULL,
+			kind TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS report_deliveries_event_id_idx ON report_deliveries (event_id, id);`,
+
+		`CREATE TABLE IF NOT EXISTS webhook_endpoints (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			event_types TEXT[] NOT NULL DEFAULT '{}',
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS webhook_endpoints_event_id_idx ON webhook_endpoints (event_id);`,
+
+		// the outbox: rows are written in the transaction of the change they
+		// announce and sent by the dispatcher afterwards
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			webhook_id UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			event_type TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INT NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ DEFAULT now(),
+			last_attempt_at TIMESTAMPTZ,
+			last_status_code INT NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			delivered_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_event_id_idx ON webhook_deliveries (event_id, id);`,
+
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			expires_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			revoked_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS api_keys_event_id_idx ON api_keys (event_id);`,
+
+		// sequence counts changes to an activity's times, for calendar feeds
+		`ALTER TABLE activities ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();`,
+		`ALTER TABLE activities ADD COLUMN IF NOT EXISTS sequence INT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS calendar_token TEXT UNIQUE;`,
+
+		// event_principals is everyone permissions are checked against: staff
+		// from eventRoles, and usable API keys acting as 'apikey:<id>' (see
+		// apikeys.UID) with their scopes as permissions, never as creators.
+		// Only permission reads go through it; staff management still works
+		// on eventRoles, so keys don't show up as staff.
+		`CREATE OR REPLACE VIEW event_principals AS
+			SELECT fireBaseId, event_id, isCreator, role, permissions FROM eventRoles
+			UNION ALL
+			SELECT 'apikey:' || id::text, event_id, false, NULL::text, scopes FROM api_keys
+			WHERE revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now());`,
 	}
 
 	for _, stmt := range stmts {
-		if _, err := p.sql.Exec(stmt); err != nil {
+		if _, err := p.sql.ExecContext(ctx, stmt); err != nil {
 			return fmt.Errorf("error running table creation: %w", err)
 		}
 	}
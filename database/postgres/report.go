@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	db "github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+const reportScheduleColumns = `id, event_id, kind, cron, time_zone, format, enabled, next_run_at, last_run_at, created_by, created_at`
+
+func scanReportSchedule(row interface{ Scan(...any) error }, s *models.ReportSchedule) error {
+	return row.Scan(&s.ID, &s.EventID, &s.Kind, &s.Cron, &s.TimeZone, &s.Format, &s.Enabled,
+		&s.NextRunAt, &s.LastRunAt, &s.CreatedBy, &s.CreatedAt)
+}
+
+func (p *PostgresDB) CreateReportSchedule(ctx context.Context, s *models.ReportSchedule) error {
+	query := `
+INSERT INTO report_schedules (event_id, kind, cron, time_zone, format, enabled, next_run_at, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, created_at`
+	return p.sql.QueryRowContext(ctx, query, s.EventID, s.Kind, s.Cron, s.TimeZone, s.Format, s.Enabled, s.NextRunAt, s.CreatedBy).
+		Scan(&s.ID, &s.CreatedAt)
+}
+
+func (p *PostgresDB) GetReportSchedule(ctx context.Context, id uuid.UUID) (*models.ReportSchedule, error) {
+	s := &models.ReportSchedule{}
+	query := `SELECT ` + reportScheduleColumns + ` FROM report_schedules WHERE id = $1`
+	err := scanReportSchedule(p.sql.QueryRowContext(ctx, query, id), s)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *PostgresDB) GetReportSchedules(ctx context.Context, eventID uuid.UUID) ([]models.ReportSchedule, error) {
+	schedules := []models.ReportSchedule{}
+	query := `SELECT ` + reportScheduleColumns + ` FROM report_schedules WHERE event_id = $1 ORDER BY created_at`
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var s models.ReportSchedule
+		if err := scanReportSchedule(rows, &s); err != nil {
+			return err
+		}
+		schedules = append(schedules, s)
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (p *PostgresDB) UpdateReportSchedule(ctx context.Context, s *models.ReportSchedule) error {
+	query := `
+UPDATE report_schedules
+SET cron = $2, time_zone = $3, format = $4, enabled = $5, next_run_at = $6
+WHERE id = $1`
+	res, err := p.sql.ExecContext(ctx, query, s.ID, s.Cron, s.TimeZone, s.Format, s.Enabled, s.NextRunAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresDB) DeleteReportSchedule(ctx context.Context, id uuid.UUID) error {
+	res, err := p.sql.ExecContext(ctx, `DELETE FROM report_schedules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+// ClaimDueReports locks up to limit enabled schedules due at now, skipping
+// those another instance already holds. It only makes sense inside WithTx,
+// and the caller has to move each one on with MarkReportRun before the
+// transaction ends. Final reports go by the event's current end time, which
+// may have moved since the schedule was saved.
+func (p *PostgresDB) ClaimDueReports(ctx context.Context, now time.Time, limit int) ([]models.DueReport, error) {
+	query := `
+SELECT s.id, s.event_id, s.kind, s.cron, s.time_zone, s.format, s.enabled, s.next_run_at, s.last_run_at, s.created_by, s.created_at,
+  e.id, e.name, e.description, e.start_time, e.end_time, e.location
+FROM report_schedules s
+JOIN events e ON e.id = s.event_id
+WHERE s.enabled AND s.next_run_at IS NOT NULL AND e.delete_at IS NULL
+  AND CASE WHEN s.kind = 'final' THEN e.end_time ELSE s.next_run_at END <= $1
+ORDER BY s.next_run_at
+LIMIT $2
+FOR UPDATE OF s SKIP LOCKED`
+	var due []models.DueReport
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var d models.DueReport
+		s, e := &d.Schedule, &d.Event
+		err := rows.Scan(&s.ID, &s.EventID, &s.Kind, &s.Cron, &s.TimeZone, &s.Format, &s.Enabled,
+			&s.NextRunAt, &s.LastRunAt, &s.CreatedBy, &s.CreatedAt,
+			&e.ID, &e.Name, &e.Description, &e.StartTime, &e.EndTime, &e.Location)
+		if err != nil {
+			return err
+		}
+		due = append(due, d)
+		return nil
+	}, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// MarkReportRun records that a schedule ran at ranAt and when it runs next;
+// a nil next stops it.
+func (p *PostgresDB) MarkReportRun(ctx context.Context, id uuid.UUID, ranAt time.Time, next *time.Time) error {
+	_, err := p.sql.ExecContext(ctx, `UPDATE report_schedules SET last_run_at = $2, next_run_at = $3 WHERE id = $1`, id, ranAt, next)
+	return err
+}
+
+func (p *PostgresDB) SetReportSubscription(ctx context.Context, eventID uuid.UUID, fbId string, subscribed bool) error {
+	query := `INSERT INTO report_subscriptions (event_id, fireBaseId) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	if !subscribed {
+		query = `DELETE FROM report_subscriptions WHERE event_id = $1 AND fireBaseId = $2`
+	}
+	_, err := p.sql.ExecContext(ctx, query, eventID, fbId)
+	return err
+}
+
+// GetReportSubscribers returns the Firebase ids subscribed to an event's
+// reports that are still among its creators.
+func (p *PostgresDB) GetReportSubscribers(ctx context.Context, eventID uuid.UUID) ([]string, error) {
+	query := `
+SELECT rs.fireBaseId
+FROM report_subscriptions rs
+JOIN eventRoles er ON er.event_id = rs.event_id AND er.fireBaseId = rs.fireBaseId
+WHERE rs.event_id = $1 AND er.isCreator
+ORDER BY rs.created_at`
+	subscribers := []string{}
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var fbId string
+		if err := rows.Scan(&fbId); err != nil {
+			return err
+		}
+		subscribers = append(subscribers, fbId)
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, err
+	}
+	return subscribers, nil
+}
+
+func (p *PostgresDB) RecordReportDelivery(ctx context.Context, d *models.ReportDelivery) error {
+	query := `
+INSERT INTO report_deliveries (event_id, schedule_id, kind, recipient, status, error)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at`
+	return p.sql.QueryRowContext(ctx, query, d.EventID, d.ScheduleID, d.Kind, d.Recipient, d.Status, d.Error).
+		Scan(&d.ID, &d.CreatedAt)
+}
+
+// GetReportDeliveries returns an event's latest deliveries, newest first.
+func (p *PostgresDB) GetReportDeliveries(ctx context.Context, eventID uuid.UUID, limit int) ([]models.ReportDelivery, error) {
+	query := `
+SELECT id, event_id, schedule_id, kind, recipient, status, error, created_at
+FROM report_deliveries
+WHERE event_id = $1
+ORDER BY id DESC
+LIMIT $2`
+	deliveries := []models.ReportDelivery{}
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var d models.ReportDelivery
+		if err := rows.Scan(&d.ID, &d.EventID, &d.ScheduleID, &d.Kind, &d.Recipient, &d.Status, &d.Error, &d.CreatedAt); err != nil {
+			return err
+		}
+		deliveries = append(deliveries, d)
+		return nil
+	}, eventID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
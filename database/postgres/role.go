@@ -1,122 +1,189 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/permissions"
 	"github.com/koiraladarwin/scanin/models"
 )
 
-func (postgres *PostgresDB) AddEventRole(role models.RoleRequest) error {
-	query := `INSERT INTO eventRoles (fireBaseId, event_id, canSeeScanned, canCreateAttendee, canSeeAttendee) 
-        VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := postgres.sql.Exec(query, role.FireBaseId, role.EventId, role.CanSeeScanned, role.CanAddAttendee, role.CanSeeAttendee)
+// effectivePermissions is the SQL for a member's effective permissions as a
+// comma separated list; er is their eventRoles or event_principals row.
+const effectivePermissions = `array_to_string(ARRAY(
+	SELECT DISTINCT p FROM unnest(er.permissions || COALESCE((
+		SELECT t.permissions FROM role_templates t
+		WHERE t.name = er.role AND (t.event_id IS NULL OR t.event_id = er.event_id)
+	), '{}')) p ORDER BY p
+), ',')`
+
+func (postgres *PostgresDB) AddEventRole(ctx context.Context, role models.RoleRequest) error {
+	query := `INSERT INTO eventRoles (fireBaseId, event_id, role, permissions)
+        VALUES ($1, $2, NULLIF($3, ''), $4::text[])`
+	_, err := postgres.sql.ExecContext(ctx, query, role.FireBaseId, role.EventId, role.Role, permissions.ToArray(role.Permissions))
+	if isUniqueViolationError(err) {
+		return db.ErrAlreadyExists
+	}
 	return err
 }
-func (postgres *PostgresDB) ModifyEventRole(role models.EditRoleRequest) error {
-	query := `UPDATE eventRoles SET canSeeScanned = $1, canCreateAttendee = $2, canSeeAttendee = $3 
-        WHERE fireBaseId = $4 AND event_id = $5`
-	_, err := postgres.sql.Exec(query, role.CanSeeScanned, role.CanAddAttendee, role.CanSeeAttendee, role.FireBaseId, role.EventId)
-	return err
+
+func (postgres *PostgresDB) ModifyEventRole(ctx context.Context, role models.RoleRequest) error {
+	query := `UPDATE eventRoles SET role = NULLIF($1, ''), permissions = $2::text[]
+        WHERE fireBaseId = $3 AND event_id = $4`
+	res, err := postgres.sql.ExecContext(ctx, query, role.Role, permissions.ToArray(role.Permissions), role.FireBaseId, role.EventId)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
 }
 
-func (postgres *PostgresDB) AddStaffToEvent(fbId, eventId string) error {
-	query := `INSERT INTO eventRoles (fireBaseId, event_id) VALUES ($1, $2)`
-	_, err := postgres.sql.Exec(query, fbId, eventId)
+// AddStaffToEvent gives fbId the default role on the event. It does nothing
+// if they already have a role there.
+func (postgres *PostgresDB) AddStaffToEvent(ctx context.Context, fbId, eventId string) error {
+	// ON CONFLICT rather than a unique violation, which would abort the
+	// caller's transaction when a concurrent request got there first
+	query := `INSERT INTO eventRoles (fireBaseId, event_id, role) VALUES ($1, $2, $3)
+        ON CONFLICT (fireBaseId, event_id) DO NOTHING`
+	_, err := postgres.sql.ExecContext(ctx, query, fbId, eventId, permissions.DefaultRole)
 	return err
 }
 
-func (postgres *PostgresDB) GetStaffByEvent(eventId string) ([]models.Staff, error) {
-	var fireBaseIds []models.Staff
+func (postgres *PostgresDB) GetStaffByEvent(ctx context.Context, eventId string) ([]models.Staff, error) {
+	var staffs []models.Staff
 
-	query := `SELECT fireBaseId,canSeeScanned, canCreateAttendee, canSeeAttendee FROM eventRoles WHERE event_id = $1`
-	rows, err := postgres.sql.Query(query, eventId)
+	query := `SELECT er.fireBaseId, COALESCE(er.role, ''), ` + effectivePermissions + `, er.isCreator, er.isOwner
+        FROM eventRoles er WHERE er.event_id = $1`
+	rows, err := postgres.sql.QueryContext(ctx, query, eventId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var fireBaseId models.Staff
-		err := rows.Scan(&fireBaseId.FireBaseId, &fireBaseId.CanSeeScanned, &fireBaseId.CanCreateAttendee, &fireBaseId.CanSeeAttendee)
-		if err != nil {
-			continue
+		var staff models.Staff
+		var granted string
+		if err := rows.Scan(&staff.FireBaseId, &staff.Role, &granted, &staff.IsCreator, &staff.IsOwner); err != nil {
+			return nil, err
 		}
-		fireBaseIds = append(fireBaseIds, fireBaseId)
+		staff.Permissions = permissions.FromList(granted)
+		if staff.IsCreator {
+			staff.Permissions = permissions.All
+		}
+		staffs = append(staffs, staff)
 	}
 
-	return fireBaseIds, nil
+	return staffs, rows.Err()
+}
+
+// AddAdminToEvent makes fbId a co-organizer: a creator of the event, with
+// every permission, but not its owner. Existing staff are promoted in place.
+func (postgres *PostgresDB) AddAdminToEvent(ctx context.Context, fbId, eventId string) error {
+	return postgres.inTransaction(ctx, func(tx *PostgresDB) error {
+		if err := tx.AddStaffToEvent(ctx, fbId, eventId); err != nil {
+			return err
+		}
+		_, err := tx.sql.ExecContext(ctx, `UPDATE eventRoles SET isCreator = true WHERE fireBaseId = $1 AND event_id = $2`, fbId, eventId)
+		return err
+	})
 }
 
-func (postgres *PostgresDB) AddAdminToEvent(fbId, eventId string) error {
-	query := `INSERT INTO eventRoles (fireBaseId, event_id ,isCreator) VALUES ($1, $2, true)`
-	_, err := postgres.sql.Exec(query, fbId, eventId)
+// AddOwnerToEvent records fbId as the owner of a newly created event.
+func (postgres *PostgresDB) AddOwnerToEvent(ctx context.Context, fbId, eventId string) error {
+	query := `INSERT INTO eventRoles (fireBaseId, event_id, isCreator, isOwner) VALUES ($1, $2, true, true)`
+	_, err := postgres.sql.ExecContext(ctx, query, fbId, eventId)
 	return err
 }
 
-func (postgres *PostgresDB) IsCreator(fbId, eventId string) (bool, error) {
+func (postgres *PostgresDB) IsOwner(ctx context.Context, fbId, eventId string) (bool, error) {
+	var isOwner bool
+	query := `SELECT isOwner FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2`
+	err := postgres.sql.QueryRowContext(ctx, query, fbId, eventId).Scan(&isOwner)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isOwner, err
+}
+
+// TransferEventOwnership makes toFbId, who must already have a role on the
+// event, its owner. The previous owner stays on as a co-organizer.
+func (postgres *PostgresDB) TransferEventOwnership(ctx context.Context, eventId, fromFbId, toFbId string) error {
+	return postgres.inTransaction(ctx, func(tx *PostgresDB) error {
+		res, err := tx.sql.ExecContext(ctx, `UPDATE eventRoles SET isCreator = true, isOwner = true WHERE fireBaseId = $1 AND event_id = $2`, toFbId, eventId)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return db.ErrNotFound
+		}
+		_, err = tx.sql.ExecContext(ctx, `UPDATE eventRoles SET isOwner = false WHERE fireBaseId = $1 AND event_id = $2`, fromFbId, eventId)
+		return err
+	})
+}
+
+func (postgres *PostgresDB) IsCreator(ctx context.Context, fbId, eventId string) (bool, error) {
 	var isCreator bool
 	query := `SELECT isCreator FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2`
-	err := postgres.sql.QueryRow(query, fbId, eventId).Scan(&isCreator)
+	err := postgres.sql.QueryRowContext(ctx, query, fbId, eventId).Scan(&isCreator)
 	return isCreator, err
 }
 
-func (postgres *PostgresDB) CanSeeScanned(fbId, eventId string) (bool, error) {
-	var isCreator, canSee bool
-	query := `SELECT isCreator, canSeeScanned FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2`
-	err := postgres.sql.QueryRow(query, fbId, eventId).Scan(&isCreator, &canSee)
-	if err != nil {
-		return false, err
-	}
-	if isCreator {
-		return true, nil
+// HasPermission reports whether fbId may perform action on the event. Users
+// without a role there have no permissions.
+func (postgres *PostgresDB) HasPermission(ctx context.Context, fbId, eventId string, action permissions.Action) (bool, error) {
+	var allowed bool
+	query := `SELECT has_event_permission(isCreator, role, permissions, event_id, $3)
+        FROM event_principals WHERE fireBaseId = $1 AND event_id = $2`
+	err := postgres.sql.QueryRowContext(ctx, query, fbId, eventId, string(action)).Scan(&allowed)
+	if err == sql.ErrNoRows {
+		return false, nil
 	}
-
-	return canSee, nil
+	return allowed, err
 }
 
-func (postgres *PostgresDB) CanCreateActivity(fbId, eventId string) (bool, error) {
-	var isCreator, canCreate bool
-	query := `SELECT isCreator, canCreateActivity FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2`
-	err := postgres.sql.QueryRow(query, fbId, eventId).Scan(&isCreator, &canCreate)
-	if err != nil {
-		return false, err
-	}
-	if isCreator {
-		return true, nil
+// HasActivityPermission is HasPermission for the event an activity belongs
+// to, in a single round trip.
+func (postgres *PostgresDB) HasActivityPermission(ctx context.Context, fbId string, activityId uuid.UUID, action permissions.Action) (bool, error) {
+	var allowed bool
+	query := `SELECT has_event_permission(er.isCreator, er.role, er.permissions, er.event_id, $3)
+        FROM activities a
+        JOIN event_principals er ON er.event_id = a.event_id AND er.fireBaseId = $1
+        WHERE a.id = $2`
+	err := postgres.sql.QueryRowContext(ctx, query, fbId, activityId, string(action)).Scan(&allowed)
+	if err == sql.ErrNoRows {
+		return false, nil
 	}
-	return canCreate, nil
+	return allowed, err
 }
 
-func (postgres *PostgresDB) CanCreateAttendee(fbId, eventId string) (bool, error) {
-	var isCreator, canCreate bool
-	query := `SELECT isCreator, canCreateAttendee FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2`
-	err := postgres.sql.QueryRow(query, fbId, eventId).Scan(&isCreator, &canCreate)
-	if err != nil {
-		return false, err
-	}
-	if isCreator {
-		return true, nil
+// GetPermissions returns fbId's effective permissions on the event, or
+// db.ErrNotFound if they have no role there.
+func (postgres *PostgresDB) GetPermissions(ctx context.Context, fbId, eventId string) ([]permissions.Action, error) {
+	var isCreator bool
+	var granted string
+	query := `SELECT er.isCreator, ` + effectivePermissions + `
+        FROM event_principals er WHERE er.fireBaseId = $1 AND er.event_id = $2`
+	err := postgres.sql.QueryRowContext(ctx, query, fbId, eventId).Scan(&isCreator, &granted)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
 	}
-	return canCreate, nil
-}
-
-func (postgres *PostgresDB) CanSeeAttendee(fbId, eventId string) (bool, error) {
-	var isCreator, canSee bool
-	query := `SELECT isCreator, canSeeAttendee FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2`
-	err := postgres.sql.QueryRow(query, fbId, eventId).Scan(&isCreator, &canSee)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if isCreator {
-		return true, nil
+		return permissions.All, nil
 	}
-	return canSee, nil
+	return permissions.FromList(granted), nil
 }
 
-func (postgres *PostgresDB) CanSeeEventInfo(fbId, eventId string) (bool, error) {
-	query := `SELECT 1 FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2 LIMIT 1`
+func (postgres *PostgresDB) CanSeeEventInfo(ctx context.Context, fbId, eventId string) (bool, error) {
+	query := `SELECT 1 FROM event_principals WHERE fireBaseId = $1 AND event_id = $2 LIMIT 1`
 	var exists int
-	err := postgres.sql.QueryRow(query, fbId, eventId).Scan(&exists)
+	err := postgres.sql.QueryRowContext(ctx, query, fbId, eventId).Scan(&exists)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
@@ -125,3 +192,70 @@ func (postgres *PostgresDB) CanSeeEventInfo(fbId, eventId string) (bool, error)
 	}
 	return true, nil
 }
+
+// AddStaffWithRole gives fbId the role and permissions on the event unless
+// they already have a role there, and reports whether a role was added.
+func (postgres *PostgresDB) AddStaffWithRole(ctx context.Context, role models.RoleRequest) (bool, error) {
+	query := `INSERT INTO eventRoles (fireBaseId, event_id, role, permissions)
+        VALUES ($1, $2, NULLIF($3, ''), $4)
+        ON CONFLICT (fireBaseId, event_id) DO NOTHING`
+	res, err := postgres.sql.ExecContext(ctx, query, role.FireBaseId, role.EventId, role.Role, permissions.ToArray(role.Permissions))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveStaffFromEvent deletes fbId's role on the event along with their
+// per-activity scan access. Permissions are read from eventRoles on every
+// request, so the removed user loses access as soon as this commits.
+func (postgres *PostgresDB) RemoveStaffFromEvent(ctx context.Context, eventId, fbId string) error {
+	return postgres.inTransaction(ctx, func(tx *PostgresDB) error {
+		// lock every role of the event so two creators can't remove each other
+		// concurrently and leave the event with none
+		rows, err := tx.sql.QueryContext(ctx, `SELECT fireBaseId, isCreator, isOwner
+			FROM eventRoles WHERE event_id = $1 FOR UPDATE`, eventId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var found, isCreator, isOwner bool
+		creators := 0
+		for rows.Next() {
+			var id string
+			var creator, owner bool
+			if err := rows.Scan(&id, &creator, &owner); err != nil {
+				return err
+			}
+			if creator {
+				creators++
+			}
+			if id == fbId {
+				found = true
+				isCreator, isOwner = creator, owner
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		switch {
+		case !found:
+			return db.ErrNotFound
+		case isOwner:
+			return db.ErrIsOwner
+		case isCreator && creators <= 1:
+			return db.ErrLastCreator
+		}
+
+		if _, err := tx.sql.ExecContext(ctx, `DELETE FROM eventRoles WHERE fireBaseId = $1 AND event_id = $2`, fbId, eventId); err != nil {
+			return err
+		}
+		_, err = tx.sql.ExecContext(ctx, `DELETE FROM scanRoles WHERE fireBaseId = $1
+			AND activityId IN (SELECT id FROM activities WHERE event_id = $2)`, fbId, eventId)
+		return err
+	})
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/permissions"
+)
+
+// seedRoleTemplates writes the built-in roles from the permissions registry,
+// overwriting what a previous version of the server stored.
+func (p *PostgresDB) seedRoleTemplates(ctx context.Context) error {
+	query := `INSERT INTO role_templates (name, description, permissions) VALUES ($1, $2, $3::text[])
+		ON CONFLICT (name) WHERE event_id IS NULL
+		DO UPDATE SET description = EXCLUDED.description, permissions = EXCLUDED.permissions`
+	for _, role := range permissions.BuiltIn {
+		if _, err := p.sql.ExecContext(ctx, query, role.Name, role.Description, permissions.ToArray(role.Permissions)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRoleTemplates returns the built-in roles followed by the event's custom
+// ones.
+func (p *PostgresDB) GetRoleTemplates(ctx context.Context, eventId string) ([]permissions.Role, error) {
+	query := `SELECT name, description, array_to_string(permissions, ','), event_id IS NULL
+		FROM role_templates
+		WHERE event_id IS NULL OR event_id = $1
+		ORDER BY event_id IS NOT NULL, name`
+	rows, err := p.sql.QueryContext(ctx, query, eventId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []permissions.Role{}
+	for rows.Next() {
+		var role permissions.Role
+		var granted string
+		if err := rows.Scan(&role.Name, &role.Description, &granted, &role.BuiltIn); err != nil {
+			return nil, err
+		}
+		role.Permissions = permissions.FromList(granted)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// GetRoleTemplate looks a role up by name among the built-in roles and the
+// event's custom ones.
+func (p *PostgresDB) GetRoleTemplate(ctx context.Context, eventId, name string) (*permissions.Role, error) {
+	role := &permissions.Role{}
+	var granted string
+	query := `SELECT name, description, array_to_string(permissions, ','), event_id IS NULL
+		FROM role_templates
+		WHERE name = $2 AND (event_id IS NULL OR event_id = $1)`
+	err := p.sql.QueryRowContext(ctx, query, eventId, name).Scan(&role.Name, &role.Description, &granted, &role.BuiltIn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	role.Permissions = permissions.FromList(granted)
+	return role, nil
+}
+
+// SaveRoleTemplate creates or replaces a custom role. Members holding it pick
+// up the change on their next request.
+func (p *PostgresDB) SaveRoleTemplate(ctx context.Context, eventId string, role permissions.Role) error {
+	query := `INSERT INTO role_templates (event_id, name, description, permissions) VALUES ($1, $2, $3, $4::text[])
+		ON CONFLICT (event_id, name) WHERE event_id IS NOT NULL
+		DO UPDATE SET description = EXCLUDED.description, permissions = EXCLUDED.permissions`
+	_, err := p.sql.ExecContext(ctx, query, eventId, role.Name, role.Description, permissions.ToArray(role.Permissions))
+	return err
+}
+
+// DeleteRoleTemplate removes a custom role, or fails with db.ErrAlreadyExists
+// while a member or an open invitation still uses it.
+func (p *PostgresDB) DeleteRoleTemplate(ctx context.Context, eventId, name string) error {
+	return p.inTransaction(ctx, func(tx *PostgresDB) error {
+		var inUse bool
+		err := tx.sql.QueryRowContext(ctx, `SELECT
+			EXISTS (SELECT 1 FROM eventRoles WHERE event_id = $1 AND role = $2)
+			OR EXISTS (SELECT 1 FROM invitations WHERE event_id = $1 AND role = $2 AND revoked_at IS NULL AND expires_at > now())`,
+			eventId, name).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return db.ErrAlreadyExists
+		}
+
+		res, err := tx.sql.ExecContext(ctx, `DELETE FROM role_templates WHERE event_id = $1 AND name = $2`, eventId, name)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return db.ErrNotFound
+		}
+		return nil
+	})
+}
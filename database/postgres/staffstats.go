@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+func (p *PostgresDB) RecordRejectedScan(ctx context.Context, scan *models.RejectedScan) error {
+	query := `INSERT INTO rejected_scans (event_id, activity_id, attendee_id, staff_uid, staff_email, reason)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := p.sql.ExecContext(ctx, query, scan.EventID, scan.ActivityID, scan.AttendeeID, scan.StaffUID, scan.StaffEmail, scan.Reason)
+	return err
+}
+
+// staffScansCTE is every standing check-in of the event ($1) keyed by who
+// scanned it: the UID, or the email for rows older than scanned_by_uid.
+// Undone check-ins are counted as undos instead.
+const staffScansCTE = `
+WITH scans AS (
+  SELECT COALESCE(c.scanned_by_uid, c.scanned_by) AS staff, c.scanned_by_uid, c.scanned_by, c.activity_id, c.scanned_at
+  FROM check_in_logs c
+  JOIN activities a ON a.id = c.activity_id
+  WHERE a.event_id = $1 AND c.status = 'checked' AND a.delete_at IS NULL
+)`
+
+func (p *PostgresDB) GetStaffStats(ctx context.Context, eventID uuid.UUID) ([]models.StaffStats, error) {
+	// undos come from the audit log: a check-in update that took the status
+	// from checked to unchecked, by whoever made it
+	query := staffScansCTE + `,
+scan_totals AS (
+  SELECT staff, MAX(scanned_by_uid) AS uid,
+    (array_agg(scanned_by ORDER BY scanned_at DESC))[1] AS email,
+    COUNT(*) AS scans, MIN(scanned_at) AS first_scan, MAX(scanned_at) AS last_scan
+  FROM scans
+  GROUP BY staff
+),
+minutes AS (
+  SELECT staff, COUNT(*) AS active_minutes, MAX(n) AS peak
+  FROM (SELECT staff, COUNT(*) AS n FROM scans GROUP BY staff, date_trunc('minute', scanned_at)) per_minute
+  GROUP BY staff
+),
+rejections AS (
+  SELECT staff_uid AS staff, (array_agg(staff_email ORDER BY id DESC))[1] AS email,
+    COUNT(*) FILTER (WHERE reason = 'duplicate') AS duplicates,
+    COUNT(*) FILTER (WHERE reason <> 'duplicate') AS rejected
+  FROM rejected_scans
+  WHERE event_id = $1
+  GROUP BY staff_uid
+),
+undos AS (
+  SELECT actor_uid AS staff, (array_agg(actor_email ORDER BY id DESC))[1] AS email, COUNT(*) AS undos
+  FROM audit_log
+  WHERE event_id = $1 AND action = 'checkin.update'
+    AND before->>'status' = 'checked' AND after->>'status' = 'unchecked'
+  GROUP BY actor_uid
+),
+staff AS (
+  SELECT staff FROM scan_totals
+  UNION SELECT staff FROM rejections
+  UNION SELECT staff FROM undos
+)
+SELECT
+  st.staff,
+  COALESCE(t.uid, r.staff, u.staff, ''),
+  COALESCE(t.email, r.email, u.email, ''),
+  COALESCE(t.scans, 0),
+  COALESCE(r.duplicates, 0),
+  COALESCE(r.rejected, 0),
+  COALESCE(u.undos, 0),
+  t.first_scan,
+  t.last_scan,
+  COALESCE(m.active_minutes, 0),
+  COALESCE(round(t.scans::numeric / NULLIF(m.active_minutes, 0), 2), 0)::float8,
+  COALESCE(m.peak, 0)
+FROM staff st
+LEFT JOIN scan_totals t USING (staff)
+LEFT JOIN minutes m USING (staff)
+LEFT JOIN rejections r USING (staff)
+LEFT JOIN undos u USING (staff)
+ORDER BY COALESCE(t.scans, 0) DESC, st.staff`
+
+	stats := []models.StaffStats{}
+	index := map[string]int{}
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var key string
+		var s models.StaffStats
+		err := rows.Scan(&key, &s.StaffUID, &s.Email, &s.Scans, &s.Duplicates, &s.Rejected, &s.Undos,
+			&s.FirstScan, &s.LastScan, &s.ActiveMinutes, &s.ScansPerMin, &s.PeakPerMin)
+		if err != nil {
+			return err
+		}
+		s.Activities = []models.ActivityScans{}
+		index[key] = len(stats)
+		stats = append(stats, s)
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("staff stats: %w", err)
+	}
+
+	perActivity := staffScansCTE + `
+SELECT s.staff, a.id, a.name, COUNT(*)
+FROM scans s
+JOIN activities a ON a.id = s.activity_id
+GROUP BY s.staff, a.id, a.name, a.start_time
+ORDER BY s.staff, a.start_time, a.name`
+	err = p.collect(ctx, perActivity, func(rows *sql.Rows) error {
+		var key string
+		var a models.ActivityScans
+		if err := rows.Scan(&key, &a.ActivityID, &a.Name, &a.Scans); err != nil {
+			return err
+		}
+		if i, ok := index[key]; ok {
+			stats[i].Activities = append(stats[i].Activities, a)
+		}
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("staff stats per activity: %w", err)
+	}
+
+	return stats, nil
+}
@@ -1,6 +1,9 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -8,65 +11,127 @@ import (
 	"github.com/koiraladarwin/scanin/models"
 )
 
-func (p *PostgresDB) CreateUser(reqUser *models.UserRequest) (*models.User, error) {
-	var lastAutoID int
+func (p *PostgresDB) CreateUser(ctx context.Context, reqUser *models.UserRequest) (*models.User, error) {
 	var user models.User
 
-	err := p.sql.QueryRow(`SELECT COALESCE(MAX(auto_id), 0) FROM users WHERE role = $1`, reqUser.Role).Scan(&lastAutoID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch latest auto_id: %w", err)
-	}
+	err := p.inTransaction(ctx, func(tx *PostgresDB) error {
+		q := tx.sql
 
-	autoId := lastAutoID + 1
+		autoId, err := nextAutoId(ctx, q, reqUser.EventId, reqUser.Role)
+		if err != nil {
+			return err
+		}
+		user.AutoId = autoId
 
-	query := `
+		var prefix string
+		var padding int
+		err = q.QueryRowContext(ctx, `
+			SELECT COALESCE(bp.prefix, ''), e.auto_id_padding
+			FROM events e
+			LEFT JOIN auto_id_prefixes bp ON bp.event_id = e.id AND bp.role = $2
+			WHERE e.id = $1`, reqUser.EventId, reqUser.Role).Scan(&prefix, &padding)
+		if err != nil {
+			return fmt.Errorf("failed to fetch badge format: %w", err)
+		}
+		user.BadgeNumber = formatBadgeNumber(prefix, padding, autoId)
+
+		query := `
 		INSERT INTO users (auto_id, full_name, image_url, position, company, role,event_id)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id
 	`
-	err = p.sql.QueryRow(
-		query,
-		autoId,
-		reqUser.FullName,
-		reqUser.Image_url,
-		reqUser.Position,
-		reqUser.Company,
-		reqUser.Role,
-		reqUser.EventId,
-	).Scan(&user.ID)
-
-	if isUniqueViolationError(err) {
-		return nil, db.ErrAlreadyExists
+		err = q.QueryRowContext(ctx,
+			query,
+			user.AutoId,
+			reqUser.FullName,
+			reqUser.Image_url,
+			reqUser.Position,
+			reqUser.Company,
+			reqUser.Role,
+			reqUser.EventId,
+		).Scan(&user.ID)
+
+		if isUniqueViolationError(err) {
+			return db.ErrAlreadyExists
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+
 	user.FullName = reqUser.FullName
 	user.Company = reqUser.Company
 	user.Position = reqUser.Position
 	user.Image_url = reqUser.Image_url
-	user.AutoId = autoId
 	user.Role = reqUser.Role
 	user.EventId = reqUser.EventId
 
-	return &user, err
+	return &user, nil
 }
 
-func (p *PostgresDB)UpdateUser(u *models.UserModifyRequest) error {
-  query := `UPDATE users SET full_name=$1, image_url=$2, position=$3, company=$4, role=$5 WHERE id=$6`
-  _, err := p.sql.Exec(query, u.FullName, u.Image_url, u.Position, u.Company, u.Role, u.ID)
-  return err
+// UpdateUser saves u's details. auto_id is numbered per event and role, so a
+// user moving to another role gets the next number of that role; u.AutoId is
+// set to the number the user ends up with.
+func (p *PostgresDB) UpdateUser(ctx context.Context, u *models.UserModifyRequest) error {
+	return p.inTransaction(ctx, func(tx *PostgresDB) error {
+		q := tx.sql
+
+		var role, eventId string
+		var autoId int
+		err := q.QueryRowContext(ctx, `SELECT role, event_id, auto_id FROM users WHERE id = $1 FOR UPDATE`, u.ID).
+			Scan(&role, &eventId, &autoId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if u.Role != role {
+			autoId, err = nextAutoId(ctx, q, eventId, u.Role)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := `UPDATE users SET full_name=$1, image_url=$2, position=$3, company=$4, role=$5, auto_id=$6 WHERE id=$7`
+		_, err = q.ExecContext(ctx, query, u.FullName, u.Image_url, u.Position, u.Company, u.Role, autoId, u.ID)
+		if isUniqueViolationError(err) {
+			return db.ErrAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		u.AutoId = autoId
+		return nil
+	})
 }
 
-func (p *PostgresDB) GetUser(id uuid.UUID) (*models.User, error) {
+func (p *PostgresDB) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	u := &models.User{}
-	query := `SELECT id, full_name, auto_id, image_url, position, company ,role,event_id FROM users WHERE id=$1 AND delete_at IS NULL`
-	err := p.sql.QueryRow(query, id).Scan(&u.ID, &u.FullName, &u.AutoId, &u.Image_url, &u.Position, &u.Company, &u.Role, &u.EventId)
+	var prefix string
+	var padding int
+	query := `
+		SELECT u.id, u.full_name, u.auto_id, u.image_url, u.position, u.company, u.role, u.event_id, COALESCE(bp.prefix, ''), e.auto_id_padding
+		FROM users u
+		JOIN events e ON e.id = u.event_id
+		LEFT JOIN auto_id_prefixes bp ON bp.event_id = u.event_id AND bp.role = u.role
+		WHERE u.id=$1 AND u.delete_at IS NULL`
+	err := p.sql.QueryRowContext(ctx, query, id).Scan(&u.ID, &u.FullName, &u.AutoId, &u.Image_url, &u.Position, &u.Company, &u.Role, &u.EventId, &prefix, &padding)
+	u.BadgeNumber = formatBadgeNumber(prefix, padding, u.AutoId)
 	return u, err
 }
 
-func (p *PostgresDB) GetUsersByEvent(eventID uuid.UUID) ([]models.User, error) {
+func (p *PostgresDB) GetUsersByEvent(ctx context.Context, eventID uuid.UUID) ([]models.User, error) {
 	var users []models.User
 
-	rows, err := p.sql.Query(`
-			SELECT id, full_name, auto_id, image_url, position, company ,role,event_id FROM users WHERE event_id = $1 AND delete_at IS NULL
+	rows, err := p.sql.QueryContext(ctx, `
+		SELECT u.id, u.full_name, u.auto_id, u.image_url, u.position, u.company, u.role, u.event_id, COALESCE(bp.prefix, ''), e.auto_id_padding
+		FROM users u
+		JOIN events e ON e.id = u.event_id
+		LEFT JOIN auto_id_prefixes bp ON bp.event_id = u.event_id AND bp.role = u.role
+		WHERE u.event_id = $1 AND u.delete_at IS NULL
 	`, eventID)
 	if err != nil {
 		return nil, err
@@ -75,19 +140,22 @@ func (p *PostgresDB) GetUsersByEvent(eventID uuid.UUID) ([]models.User, error) {
 
 	for rows.Next() {
 		var u models.User
-		if err := rows.Scan(&u.ID, &u.FullName, &u.AutoId, &u.Image_url, &u.Position, &u.Company, &u.Role, &u.EventId); err != nil {
+		var prefix string
+		var padding int
+		if err := rows.Scan(&u.ID, &u.FullName, &u.AutoId, &u.Image_url, &u.Position, &u.Company, &u.Role, &u.EventId, &prefix, &padding); err != nil {
 			return nil, err
 		}
+		u.BadgeNumber = formatBadgeNumber(prefix, padding, u.AutoId)
 		users = append(users, u)
 	}
 
 	return users, nil
 }
 
-func (p *PostgresDB) GetNumberOfUsersByEvent(eventID uuid.UUID) (int, error) {
+func (p *PostgresDB) GetNumberOfUsersByEvent(ctx context.Context, eventID uuid.UUID) (int, error) {
 	var count int
 	query := `SELECT COUNT(*) FROM users WHERE event_id = $1 AND delete_at IS NULL`
-	err := p.sql.QueryRow(query, eventID).Scan(&count)
+	err := p.sql.QueryRowContext(ctx, query, eventID).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,260 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	db "github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// event types are plain tokens (checkin.created), so they are written as an
+// array literal and read back comma separated, like permissions
+func typesArray(types []string) string {
+	return "{" + strings.Join(types, ",") + "}"
+}
+
+func typesList(list string) []string {
+	types := []string{}
+	for _, t := range strings.Split(list, ",") {
+		if t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func (p *PostgresDB) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	query := `
+INSERT INTO webhook_endpoints (event_id, url, secret, event_types, enabled, created_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at`
+	return p.sql.QueryRowContext(ctx, query, w.EventID, w.URL, w.Secret, typesArray(w.EventTypes), w.Enabled, w.CreatedBy).
+		Scan(&w.ID, &w.CreatedAt)
+}
+
+// GetWebhook returns the endpoint with its secret.
+func (p *PostgresDB) GetWebhook(ctx context.Context, id uuid.UUID) (*models.Webhook, error) {
+	w := &models.Webhook{}
+	var types string
+	query := `
+SELECT id, event_id, url, secret, array_to_string(event_types, ','), enabled, created_by, created_at
+FROM webhook_endpoints WHERE id = $1`
+	err := p.sql.QueryRowContext(ctx, query, id).
+		Scan(&w.ID, &w.EventID, &w.URL, &w.Secret, &types, &w.Enabled, &w.CreatedBy, &w.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.EventTypes = typesList(types)
+	return w, nil
+}
+
+// GetWebhooks returns an event's endpoints without their secrets.
+func (p *PostgresDB) GetWebhooks(ctx context.Context, eventID uuid.UUID) ([]models.Webhook, error) {
+	query := `
+SELECT id, event_id, url, array_to_string(event_types, ','), enabled, created_by, created_at
+FROM webhook_endpoints WHERE event_id = $1
+ORDER BY created_at`
+	webhooks := []models.Webhook{}
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var w models.Webhook
+		var types string
+		if err := rows.Scan(&w.ID, &w.EventID, &w.URL, &types, &w.Enabled, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return err
+		}
+		w.EventTypes = typesList(types)
+		webhooks = append(webhooks, w)
+		return nil
+	}, eventID)
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook saves the url, event types and enabled flag; the secret
+// only changes through RotateWebhookSecret.
+func (p *PostgresDB) UpdateWebhook(ctx context.Context, w *models.Webhook) error {
+	query := `UPDATE webhook_endpoints SET url = $2, event_types = $3, enabled = $4 WHERE id = $1`
+	res, err := p.sql.ExecContext(ctx, query, w.ID, w.URL, typesArray(w.EventTypes), w.Enabled)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresDB) RotateWebhookSecret(ctx context.Context, id uuid.UUID, secret string) error {
+	res, err := p.sql.ExecContext(ctx, `UPDATE webhook_endpoints SET secret = $2 WHERE id = $1`, id, secret)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook removes the endpoint along with its deliveries.
+func (p *PostgresDB) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	res, err := p.sql.ExecContext(ctx, `DELETE FROM webhook_endpoints WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+// EnqueueWebhook queues payload for every enabled endpoint of the event
+// subscribed to eventType. Call it in the transaction that made the change
+// so the deliveries commit or roll back with it.
+func (p *PostgresDB) EnqueueWebhook(ctx context.Context, eventId string, eventType string, payload []byte) error {
+	query := `
+INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+SELECT id, event_id, $2, $3
+FROM webhook_endpoints
+WHERE event_id = $1 AND enabled AND $2 = ANY(event_types)`
+	_, err := p.sql.ExecContext(ctx, query, eventId, eventType, string(payload))
+	return err
+}
+
+const webhookTargetColumns = `d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+  d.next_attempt_at, d.last_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at,
+  w.url, w.secret`
+
+func scanWebhookTarget(row interface{ Scan(...any) error }, t *models.WebhookTarget) error {
+	d := &t.Delivery
+	var payload string
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt,
+		&t.URL, &t.Secret)
+	d.Payload = []byte(payload)
+	return err
+}
+
+// ClaimWebhookDeliveries takes up to limit pending deliveries due at now and
+// pushes their next attempt out to lease, so no other dispatcher picks them
+// up while they are being sent. A dispatcher that dies mid-send leaves them
+// to be retried once the lease runs out.
+func (p *PostgresDB) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Time, limit int) ([]models.WebhookTarget, error) {
+	query := `
+WITH due AS (
+  SELECT d.id
+  FROM webhook_deliveries d
+  JOIN webhook_endpoints w ON w.id = d.webhook_id
+  WHERE d.status = 'pending' AND d.next_attempt_at <= $1 AND w.enabled
+  ORDER BY d.next_attempt_at
+  LIMIT $3
+  FOR UPDATE OF d SKIP LOCKED
+)
+UPDATE webhook_deliveries d
+SET next_attempt_at = $2
+FROM due, webhook_endpoints w
+WHERE d.id = due.id AND w.id = d.webhook_id
+RETURNING ` + webhookTargetColumns
+	var targets []models.WebhookTarget
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var t models.WebhookTarget
+		if err := scanWebhookTarget(rows, &t); err != nil {
+			return err
+		}
+		targets = append(targets, t)
+		return nil
+	}, now, lease, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim webhook deliveries: %w", err)
+	}
+	return targets, nil
+}
+
+// RequeueWebhookDelivery starts a delivery over, whatever its status, and
+// claims it until lease for the caller to send right away.
+func (p *PostgresDB) RequeueWebhookDelivery(ctx context.Context, id int64, lease time.Time) (*models.WebhookTarget, error) {
+	query := `
+UPDATE webhook_deliveries d
+SET status = 'pending', attempts = 0, next_attempt_at = $2, delivered_at = NULL
+FROM webhook_endpoints w
+WHERE d.id = $1 AND w.id = d.webhook_id
+RETURNING ` + webhookTargetColumns
+	t := &models.WebhookTarget{}
+	err := scanWebhookTarget(p.sql.QueryRowContext(ctx, query, id, lease), t)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RecordWebhookAttempt stores the outcome of one more attempt at a delivery.
+func (p *PostgresDB) RecordWebhookAttempt(ctx context.Context, id int64, attempt models.WebhookAttempt) error {
+	query := `
+UPDATE webhook_deliveries
+SET status = $2, attempts = attempts + 1, next_attempt_at = $3, last_attempt_at = now(),
+    last_status_code = $4, last_error = $5,
+    delivered_at = CASE WHEN $2 = 'delivered' THEN now() END
+WHERE id = $1`
+	_, err := p.sql.ExecContext(ctx, query, id, attempt.Status, attempt.NextAttemptAt, attempt.StatusCode, attempt.Error)
+	return err
+}
+
+func (p *PostgresDB) GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	query := `SELECT ` + webhookTargetColumns + `
+FROM webhook_deliveries d
+JOIN webhook_endpoints w ON w.id = d.webhook_id
+WHERE d.id = $1`
+	t := &models.WebhookTarget{}
+	err := scanWebhookTarget(p.sql.QueryRowContext(ctx, query, id), t)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t.Delivery, nil
+}
+
+// GetWebhookDeliveries returns an event's delivery log, newest first.
+func (p *PostgresDB) GetWebhookDeliveries(ctx context.Context, eventID uuid.UUID, filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + webhookTargetColumns + `
+FROM webhook_deliveries d
+JOIN webhook_endpoints w ON w.id = d.webhook_id
+WHERE d.event_id = $1`
+	args := []any{eventID}
+	if filter.WebhookID != nil {
+		args = append(args, *filter.WebhookID)
+		query += fmt.Sprintf(" AND d.webhook_id = $%d", len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND d.status = $%d", len(args))
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY d.id DESC LIMIT $%d", len(args))
+
+	deliveries := []models.WebhookDelivery{}
+	err := p.collect(ctx, query, func(rows *sql.Rows) error {
+		var t models.WebhookTarget
+		if err := scanWebhookTarget(rows, &t); err != nil {
+			return err
+		}
+		deliveries = append(deliveries, t.Delivery)
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,120 @@
+// Package apikeys issues and checks the event scoped keys integrations use
+// instead of a Firebase ID token, sent as "Authorization: Bearer sk_...".
+//
+// A key acts as its own principal, UID(id), holding its scopes as
+// permissions on its event only; the database resolves it through the same
+// permission checks as staff, and it is never a creator.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/database"
+)
+
+const (
+	// Prefix starts every key, telling it apart from a Firebase ID token.
+	Prefix = "sk_"
+	// UIDPrefix starts the UID a key acts as; the event_principals view
+	// builds the same string.
+	UIDPrefix = "apikey:"
+
+	// shownLength is how much of a key is kept in the clear to recognise it.
+	shownLength = len(Prefix) + 8
+	// touchEvery limits how often last_used_at is written for a busy key.
+	touchEvery = time.Minute
+)
+
+var (
+	ErrInvalidKey = errors.New("unknown API key")
+	ErrRevoked    = errors.New("API key has been revoked")
+	ErrExpired    = errors.New("API key has expired")
+)
+
+// IsKey reports whether a bearer token looks like an API key.
+func IsKey(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// UID is the user id a key acts as.
+func UID(id uuid.UUID) string {
+	return UIDPrefix + id.String()
+}
+
+// IsUID reports whether uid is one a key acts as rather than a person.
+func IsUID(uid string) bool {
+	return strings.HasPrefix(uid, UIDPrefix)
+}
+
+// New returns a random key, the part of it that may be shown later, and the
+// hash to store.
+func New() (token, shown, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token = Prefix + hex.EncodeToString(b)
+	return token, token[:shownLength], Hash(token), nil
+}
+
+// Hash is what a key is stored and looked up by. Keys are long and random,
+// so a plain SHA-256 is enough; there is nothing to guess.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verifier checks keys against the database; it satisfies
+// firebaseauth.APIKeyVerifier.
+type Verifier struct {
+	db db.Database
+}
+
+func NewVerifier(store db.Database) *Verifier {
+	return &Verifier{db: store}
+}
+
+// VerifyAPIKey returns the user a usable key acts as, named after the key,
+// and notes that it was used.
+func (v *Verifier) VerifyAPIKey(ctx context.Context, token string) (*auth.UserRecord, error) {
+	key, err := v.db.GetAPIKeyByHash(ctx, Hash(token))
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+
+	now := time.Now()
+	switch {
+	case key.RevokedAt != nil:
+		return nil, ErrRevoked
+	case key.ExpiresAt != nil && !now.Before(*key.ExpiresAt):
+		return nil, ErrExpired
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= touchEvery {
+		// the request goes ahead either way
+		if err := v.db.TouchAPIKey(ctx, key.ID, now); err != nil {
+			slog.WarnContext(ctx, "failed to record API key use", "key_id", key.ID, "err", err)
+		}
+	}
+
+	return &auth.UserRecord{
+		UserInfo: &auth.UserInfo{
+			UID:         UID(key.ID),
+			DisplayName: key.Name,
+			ProviderID:  "api_key",
+		},
+	}, nil
+}
@@ -0,0 +1,174 @@
+// Package badges renders printable attendee badges: a grid of cut-out cards
+// per A4 page, each with a role color band, the attendee's details and a QR
+// code for the scanner apps.
+package badges
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/koiraladarwin/scanin/features/export"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	MaxColumns = 4
+	MaxRows    = 6
+
+	pageMargin = 8.0
+	cardGap    = 4.0
+	qrPixels   = 256
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ValidColor reports whether c is a #rrggbb color.
+func ValidColor(c string) bool {
+	return colorPattern.MatchString(c)
+}
+
+// Layout is the page grid and the band colors.
+type Layout struct {
+	Columns      int
+	Rows         int
+	DefaultColor string
+	RoleColors   map[string]string
+}
+
+func (l Layout) colorFor(role string) string {
+	if c, ok := l.RoleColors[role]; ok {
+		return c
+	}
+	return l.DefaultColor
+}
+
+// Badge is what gets printed on one card. QR is the payload the scanner
+// reads back.
+type Badge struct {
+	Name     string
+	Company  string
+	Position string
+	Role     string
+	Number   string
+	QR       string
+}
+
+// Render writes a PDF with every badge to w.
+func Render(w io.Writer, layout Layout, badges []Badge) error {
+	if layout.Columns < 1 || layout.Columns > MaxColumns || layout.Rows < 1 || layout.Rows > MaxRows {
+		return fmt.Errorf("badge layout must be 1-%d columns by 1-%d rows", MaxColumns, MaxRows)
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	cardWidth := (pageWidth - 2*pageMargin - float64(layout.Columns-1)*cardGap) / float64(layout.Columns)
+	cardHeight := (pageHeight - 2*pageMargin - float64(layout.Rows-1)*cardGap) / float64(layout.Rows)
+	perPage := layout.Columns * layout.Rows
+
+	if len(badges) == 0 {
+		pdf.AddPage()
+	}
+	for i, b := range badges {
+		slot := i % perPage
+		if slot == 0 {
+			pdf.AddPage()
+		}
+		x := pageMargin + float64(slot%layout.Columns)*(cardWidth+cardGap)
+		y := pageMargin + float64(slot/layout.Columns)*(cardHeight+cardGap)
+		if err := card(pdf, tr, x, y, cardWidth, cardHeight, layout.colorFor(b.Role), b, i); err != nil {
+			return err
+		}
+	}
+
+	return pdf.Output(w)
+}
+
+func card(pdf *fpdf.Fpdf, tr func(string) string, x, y, w, h float64, color string, b Badge, n int) error {
+	const padding = 3.0
+	inner := w - 2*padding
+
+	// dashed outline to cut along
+	pdf.SetDrawColor(160, 160, 160)
+	pdf.SetLineWidth(0.2)
+	pdf.SetDashPattern([]float64{1.5, 1.5}, 0)
+	pdf.Rect(x, y, w, h, "D")
+	pdf.SetDashPattern([]float64{}, 0)
+
+	band := min(h*0.14, 12)
+	r, g, bl := hexColor(color)
+	pdf.SetFillColor(r, g, bl)
+	pdf.Rect(x, y, w, band, "F")
+	if luminance(r, g, bl) > 0.6 {
+		pdf.SetTextColor(0, 0, 0)
+	} else {
+		pdf.SetTextColor(255, 255, 255)
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetXY(x+padding, y)
+	pdf.CellFormat(inner, band, export.Fit(pdf, tr(strings.ToUpper(b.Role)), inner), "", 0, "C", false, 0, "")
+
+	pdf.SetTextColor(0, 0, 0)
+	top := y + band + 3
+
+	// the name is the one thing that has to be readable across a room, so
+	// it gets the largest font that fits
+	name := tr(b.Name)
+	size := 20.0
+	for ; size > 9; size-- {
+		pdf.SetFont("Helvetica", "B", size)
+		if pdf.GetStringWidth(name) <= inner {
+			break
+		}
+	}
+	lineHeight := size * 0.45
+	pdf.SetXY(x+padding, top)
+	pdf.CellFormat(inner, lineHeight, export.Fit(pdf, name, inner), "", 2, "C", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetX(x + padding)
+	pdf.CellFormat(inner, 5, export.Fit(pdf, tr(b.Company), inner), "", 2, "C", false, 0, "")
+	pdf.SetFont("Helvetica", "I", 9)
+	pdf.SetX(x + padding)
+	pdf.CellFormat(inner, 4.5, export.Fit(pdf, tr(b.Position), inner), "", 2, "C", false, 0, "")
+
+	numberHeight := 6.0
+	textBottom := pdf.GetY() + 2
+	qrSize := min(inner*0.6, y+h-padding-numberHeight-textBottom)
+	if qrSize > 8 && b.QR != "" {
+		png, err := qrcode.Encode(b.QR, qrcode.Medium, qrPixels)
+		if err != nil {
+			return err
+		}
+		imageName := "qr" + strconv.Itoa(n)
+		pdf.RegisterImageOptionsReader(imageName, fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+		pdf.ImageOptions(imageName, x+(w-qrSize)/2, textBottom, qrSize, qrSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	}
+
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetXY(x+padding, y+h-padding-numberHeight)
+	pdf.CellFormat(inner, numberHeight, tr(b.Number), "", 0, "C", false, 0, "")
+
+	return pdf.Error()
+}
+
+func hexColor(c string) (int, int, int) {
+	if !ValidColor(c) {
+		return 55, 65, 81
+	}
+	v, _ := strconv.ParseUint(c[1:], 16, 32)
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
+
+// luminance is the perceived brightness of a color, from 0 to 1.
+func luminance(r, g, b int) float64 {
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 255
+}
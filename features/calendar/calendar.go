@@ -0,0 +1,134 @@
+// Package calendar renders an event's agenda as an iCalendar (RFC 5545)
+// feed that calendar apps subscribe to.
+//
+// Calendar clients can't send a Firebase token, so the feed is reached with
+// a per-event token in its URL, which the feed's database reads match on.
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koiraladarwin/scanin/models"
+)
+
+const (
+	ContentType = "text/calendar; charset=utf-8"
+
+	prodID = "-//scanin//agenda//EN"
+	// uidDomain makes activity UIDs globally unique, as RFC 5545 asks.
+	uidDomain = "scanin"
+	// refresh is how often clients are asked to poll for changes.
+	refresh = "PT1H"
+
+	stampFormat = "20060102T150405Z"
+	// lines are folded at 75 octets, not counting the CRLF
+	maxLine = 75
+)
+
+// Render writes the feed of event with one VEVENT per activity. Each
+// activity keeps its UID for good; SEQUENCE goes up whenever its times
+// change, so clients replace the copy they have. DTSTAMP is the activity's
+// last change rather than the time of rendering, so the same agenda always
+// renders the same bytes.
+func Render(w io.Writer, event models.Event, activities []models.Activity) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", prodID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("X-WR-CALNAME", escape(event.Name))
+	if event.Description != "" {
+		line("X-WR-CALDESC", escape(event.Description))
+	}
+	line("REFRESH-INTERVAL;VALUE=DURATION", refresh)
+	line("X-PUBLISHED-TTL", refresh)
+
+	for _, a := range activities {
+		line("BEGIN", "VEVENT")
+		line("UID", a.ID.String()+"@"+uidDomain)
+		if a.UpdatedAt.IsZero() {
+			line("DTSTAMP", stamp(a.StartTime))
+		} else {
+			line("DTSTAMP", stamp(a.UpdatedAt))
+			line("LAST-MODIFIED", stamp(a.UpdatedAt))
+		}
+		line("SEQUENCE", strconv.Itoa(a.Sequence))
+		line("DTSTART", stamp(a.StartTime))
+		line("DTEND", stamp(a.EndTime))
+		line("SUMMARY", escape(a.Name))
+		if event.Location != "" {
+			line("LOCATION", escape(event.Location))
+		}
+		line("DESCRIPTION", escape(description(event, a)))
+		if a.Type != "" {
+			line("CATEGORIES", escape(a.Type))
+		}
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+func description(event models.Event, a models.Activity) string {
+	parts := []string{event.Name}
+	if a.Type != "" {
+		parts[0] = a.Type + " at " + event.Name
+	}
+	if a.Credits > 0 {
+		parts = append(parts, fmt.Sprintf("%s credits", strconv.FormatFloat(a.Credits, 'f', -1, 64)))
+	}
+	if event.Description != "" {
+		parts = append(parts, "", event.Description)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func stamp(t time.Time) string {
+	return t.UTC().Format(stampFormat)
+}
+
+// escape quotes a TEXT value.
+func escape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(s)
+}
+
+// writeFolded writes a content line, continuing it on lines that start with
+// a space once it passes maxLine octets. UTF-8 sequences are never split.
+func writeFolded(w *bufio.Writer, s string) {
+	limit := maxLine
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !startsRune(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// the leading space counts towards the next line
+		limit = maxLine - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func startsRune(b byte) bool {
+	return b&0xC0 != 0x80
+}
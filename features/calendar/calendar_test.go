@@ -0,0 +1,67 @@
+package calendar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Keynote", "Keynote"},
+		{`a\b`, `a\\b`},
+		{"Q&A; panel, part 2", `Q&A\; panel\, part 2`},
+		{"one\ntwo\r\nthree\rfour", `one\ntwo\nthree\nfour`},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderFoldsLongLines(t *testing.T) {
+	// three-byte runes put sequence boundaries off the 75 octet limit
+	name := strings.Repeat("日本語のセッション, ", 12) + "Ünïcödé; end"
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	event := models.Event{ID: uuid.New(), Name: "Dev Summit"}
+	activity := models.Activity{ID: uuid.New(), Name: name, StartTime: start, EndTime: start.Add(time.Hour)}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, event, []models.Activity{activity}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasSuffix(out, "\r\n") {
+		t.Fatal("feed doesn't end with CRLF")
+	}
+
+	folded := 0
+	for i, l := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(l) > maxLine {
+			t.Errorf("line %d is %d octets: %q", i, len(l), l)
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("line %d splits a UTF-8 sequence: %q", i, l)
+		}
+		if strings.HasPrefix(l, " ") {
+			folded++
+		}
+	}
+	if folded < 3 {
+		t.Errorf("got %d continuation lines, want the summary folded", folded)
+	}
+
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	if want := "\r\nSUMMARY:" + escape(name) + "\r\n"; !strings.Contains(unfolded, want) {
+		t.Errorf("unfolded feed doesn't contain %q", want)
+	}
+	if !strings.Contains(unfolded, `セッション\, 日本語`) || !strings.Contains(unfolded, `Ünïcödé\; end`) {
+		t.Error("summary isn't escaped")
+	}
+}
@@ -0,0 +1,226 @@
+// Package certificates renders certificates of attendance: one landscape A4
+// page per attendee with the event's logo, title and signatory, the
+// activities the attendee checked into and the hours they add up to.
+package certificates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/koiraladarwin/scanin/features/export"
+)
+
+const (
+	// MaxLogoSize is the largest logo image accepted, in bytes.
+	MaxLogoSize = 1 << 20
+
+	margin      = 15.0
+	logoHeight  = 22.0
+	rowHeight   = 6.5
+	signatureAt = 28.0 // distance of the signature line from the page bottom
+)
+
+var ErrUnsupportedLogo = errors.New("logo must be a PNG or JPEG image")
+
+// Template is what every certificate of an event shares.
+type Template struct {
+	Title          string
+	Signatory      string
+	SignatoryTitle string
+	Logo           []byte
+}
+
+// Activity is one activity the attendee checked into.
+type Activity struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Certificate is one attendee's certificate. Times are printed in Location,
+// or UTC when it is nil.
+type Certificate struct {
+	Name       string
+	Event      string
+	Activities []Activity
+	Location   *time.Location
+}
+
+// LogoType returns the fpdf image type of a logo, or ErrUnsupportedLogo.
+func LogoType(logo []byte) (string, error) {
+	switch http.DetectContentType(logo) {
+	case "image/png":
+		return "PNG", nil
+	case "image/jpeg":
+		return "JPG", nil
+	}
+	return "", ErrUnsupportedLogo
+}
+
+// TotalHours adds up the scheduled length of every activity. Activities
+// whose end is not after their start count as zero.
+func TotalHours(activities []Activity) float64 {
+	var total time.Duration
+	for _, a := range activities {
+		if d := a.End.Sub(a.Start); d > 0 {
+			total += d
+		}
+	}
+	return total.Hours()
+}
+
+// Render writes c as a PDF to w.
+func Render(w io.Writer, t Template, c Certificate) error {
+	pdf := fpdf.New("L", "mm", "A4", "")
+	if err := page(pdf, t, c); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+func page(pdf *fpdf.Fpdf, t Template, c Certificate) error {
+	loc := c.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	usable := pageWidth - 2*(margin+10)
+	pdf.SetMargins(margin+10, margin+8, margin+10)
+	pdf.SetAutoPageBreak(true, signatureAt+10)
+	// a long activity list spills onto more pages; each gets the frame
+	pdf.SetHeaderFunc(func() {
+		pdf.SetDrawColor(55, 65, 81)
+		pdf.SetLineWidth(1.2)
+		pdf.Rect(margin, margin, pageWidth-2*margin, pageHeight-2*margin, "D")
+		pdf.SetLineWidth(0.3)
+		pdf.Rect(margin+2.5, margin+2.5, pageWidth-2*margin-5, pageHeight-2*margin-5, "D")
+	})
+	pdf.AddPage()
+
+	y := margin + 8
+	if len(t.Logo) > 0 {
+		imageType, err := LogoType(t.Logo)
+		if err != nil {
+			return err
+		}
+		info := pdf.RegisterImageOptionsReader("logo", fpdf.ImageOptions{ImageType: imageType}, bytes.NewReader(t.Logo))
+		if info != nil && info.Height() > 0 {
+			width := logoHeight * info.Width() / info.Height()
+			pdf.ImageOptions("logo", (pageWidth-width)/2, y, width, logoHeight, false, fpdf.ImageOptions{ImageType: imageType}, 0, "")
+			y += logoHeight + 4
+		}
+	}
+
+	pdf.SetTextColor(17, 24, 39)
+	pdf.SetXY(margin+10, y)
+	pdf.SetFont("Times", "B", 30)
+	pdf.CellFormat(usable, 14, tr(t.Title), "", 2, "C", false, 0, "")
+
+	pdf.SetFont("Times", "I", 13)
+	pdf.CellFormat(usable, 10, "This is to certify that", "", 2, "C", false, 0, "")
+
+	name := tr(c.Name)
+	size := 28.0
+	for ; size > 14; size-- {
+		pdf.SetFont("Times", "B", size)
+		if pdf.GetStringWidth(name) <= usable {
+			break
+		}
+	}
+	pdf.CellFormat(usable, size*0.5, name, "", 2, "C", false, 0, "")
+
+	hours := TotalHours(c.Activities)
+	pdf.SetFont("Times", "", 13)
+	pdf.MultiCell(usable, 6, tr(fmt.Sprintf("attended %s, taking part in %d %s for a total of %s.",
+		c.Event, len(c.Activities), plural(len(c.Activities), "activity", "activities"), formatHours(hours))), "", "C", false)
+	pdf.Ln(4)
+
+	activities(pdf, tr, usable, c.Activities, loc)
+
+	// the signature block always sits at the bottom of the last page
+	lineY := pageHeight - signatureAt
+	if pdf.GetY() > lineY-4 {
+		pdf.AddPage()
+	}
+	lineWidth := 70.0
+	lineX := pageWidth - margin - 10 - lineWidth
+	pdf.SetDrawColor(17, 24, 39)
+	pdf.SetLineWidth(0.3)
+	pdf.Line(lineX, lineY, lineX+lineWidth, lineY)
+	pdf.SetXY(lineX, lineY+1)
+	pdf.SetFont("Times", "B", 12)
+	pdf.CellFormat(lineWidth, 5.5, tr(t.Signatory), "", 2, "C", false, 0, "")
+	pdf.SetFont("Times", "I", 11)
+	pdf.CellFormat(lineWidth, 5, tr(t.SignatoryTitle), "", 0, "C", false, 0, "")
+
+	pdf.SetXY(margin+10, lineY+1)
+	pdf.SetFont("Times", "", 11)
+	pdf.CellFormat(usable-lineWidth, 5.5, "Issued "+time.Now().In(loc).Format("2 January 2006"), "", 0, "L", false, 0, "")
+
+	return pdf.Error()
+}
+
+// activities prints the table of attended activities.
+func activities(pdf *fpdf.Fpdf, tr func(string) string, usable float64, list []Activity, loc *time.Location) {
+	if len(list) == 0 {
+		return
+	}
+	widths := []float64{usable * 0.5, usable * 0.2, usable * 0.2, usable * 0.1}
+	header := []string{"Activity", "Date", "Time", "Hours"}
+	tableX := pdf.GetX()
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(229, 231, 235)
+	for i, h := range header {
+		pdf.CellFormat(widths[i], rowHeight, h, "B", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, a := range list {
+		start, end := a.Start.In(loc), a.End.In(loc)
+		hours := 0.0
+		if d := end.Sub(start); d > 0 {
+			hours = d.Hours()
+		}
+		cells := []string{
+			export.Fit(pdf, tr(a.Name), widths[0]-2),
+			start.Format("2 Jan 2006"),
+			start.Format("15:04") + " - " + end.Format("15:04"),
+			fmt.Sprintf("%.1f", hours),
+		}
+		pdf.SetX(tableX)
+		for i, s := range cells {
+			pdf.CellFormat(widths[i], rowHeight, s, "B", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	if loc != time.UTC {
+		return
+	}
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.CellFormat(usable, 5, "Times in UTC", "", 1, "R", false, 0, "")
+}
+
+func formatHours(h float64) string {
+	s := strings.TrimSuffix(fmt.Sprintf("%.1f", h), ".0")
+	if s == "1" {
+		return "1 hour"
+	}
+	return s + " hours"
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,190 @@
+// Package cors answers browser preflight requests and decorates responses
+// with CORS headers. It wraps the whole router so preflights are handled
+// before AuthMiddleware ever sees them; browsers never send a token on an
+// OPTIONS request.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// candidateMethods are tried against the router to work out which methods a
+// path actually serves.
+var candidateMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+type Options struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), origins
+	// with a leading wildcard label ("https://*.vercel.app", for preview
+	// deploys), or "*" for any origin.
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight result.
+	MaxAge time.Duration
+}
+
+type originPattern struct {
+	scheme string
+	host   string
+	// wildcard means host is a suffix: "*.vercel.app" is stored as ".vercel.app"
+	wildcard bool
+}
+
+type Policy struct {
+	opts      Options
+	anyOrigin bool
+	origins   []originPattern
+	router    *mux.Router
+}
+
+// New builds the policy. router is used to answer preflights with the
+// methods the requested path is registered for.
+func New(opts Options, router *mux.Router) (*Policy, error) {
+	p := &Policy{opts: opts, router: router}
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		pattern, err := parseOrigin(o)
+		if err != nil {
+			return nil, err
+		}
+		p.origins = append(p.origins, pattern)
+	}
+	if p.anyOrigin && opts.AllowCredentials {
+		return nil, fmt.Errorf("cors: credentials can't be allowed for every origin (\"*\")")
+	}
+	return p, nil
+}
+
+// ValidateOrigin reports whether pattern is usable in Options.AllowedOrigins.
+func ValidateOrigin(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	_, err := parseOrigin(pattern)
+	return err
+}
+
+func parseOrigin(pattern string) (originPattern, error) {
+	scheme, host, ok := strings.Cut(strings.ToLower(pattern), "://")
+	if !ok || scheme == "" || host == "" {
+		return originPattern{}, fmt.Errorf("cors: %q is not an origin (scheme://host[:port])", pattern)
+	}
+	wildcard := false
+	if rest, found := strings.CutPrefix(host, "*."); found {
+		wildcard = true
+		host = rest
+	}
+	u, err := url.Parse(scheme + "://" + host)
+	if err != nil || u.Host != host || u.Path != "" || strings.Contains(host, "*") {
+		return originPattern{}, fmt.Errorf("cors: %q is not an origin (scheme://host[:port])", pattern)
+	}
+	if wildcard {
+		host = "." + host
+	}
+	return originPattern{scheme: scheme, host: host, wildcard: wildcard}, nil
+}
+
+func (p *Policy) allowed(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	scheme, host, ok := strings.Cut(strings.ToLower(origin), "://")
+	if !ok {
+		return false
+	}
+	for _, o := range p.origins {
+		if o.scheme != scheme {
+			continue
+		}
+		if o.wildcard {
+			if strings.HasSuffix(host, o.host) && len(host) > len(o.host) {
+				return true
+			}
+		} else if o.host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMethods returns the methods r's path is registered for.
+func (p *Policy) routeMethods(r *http.Request) []string {
+	var methods []string
+	for _, m := range candidateMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		var match mux.RouteMatch
+		if p.router.Match(probe, &match) {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			p.preflight(w, r, origin)
+			return
+		}
+
+		if origin != "" && p.allowed(origin) {
+			p.setOrigin(w, origin)
+			if len(p.opts.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.opts.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Policy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	methods := p.routeMethods(r)
+	if len(methods) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	// without the CORS headers the browser blocks the actual request
+	if origin == "" || !p.allowed(origin) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	p.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(append(methods, http.MethodOptions), ", "))
+	if len(p.opts.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.opts.AllowedHeaders, ", "))
+	}
+	if p.opts.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.opts.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Policy) setOrigin(w http.ResponseWriter, origin string) {
+	if p.anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if p.opts.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
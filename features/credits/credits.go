@@ -0,0 +1,131 @@
+// Package credits works out the continuing-education credits attendees earn
+// from their scans. Every activity carries a credit value and a rule for how
+// much presence earns it; an attendee either meets the rule and earns the
+// activity's full credits or earns nothing for it.
+package credits
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+const (
+	// RuleCheckIn awards the credits for any standing check-in.
+	RuleCheckIn = "check_in"
+	// RuleDwell also wants a check-out, with the time between the two scans
+	// at least MinDwellPercent of the activity's scheduled length.
+	RuleDwell = "dwell"
+
+	// MaxCredits caps what one activity can be worth; it matches the
+	// NUMERIC(6,2) column the value is stored in.
+	MaxCredits = 9999.99
+)
+
+// Normalize fills in the default rule and checks an activity's credit
+// settings, returning the first problem found.
+func Normalize(credits *float64, rule *string, minDwellPercent *int) error {
+	if *rule == "" {
+		*rule = RuleCheckIn
+	}
+	if math.IsNaN(*credits) || *credits < 0 || *credits > MaxCredits {
+		return fmt.Errorf("credits must be between 0 and %.2f", MaxCredits)
+	}
+	*credits = math.Round(*credits*100) / 100
+
+	switch *rule {
+	case RuleCheckIn:
+		*minDwellPercent = 0
+	case RuleDwell:
+		if *minDwellPercent < 1 || *minDwellPercent > 100 {
+			return fmt.Errorf("min_dwell_percent must be between 1 and 100 for the %s rule", RuleDwell)
+		}
+	default:
+		return fmt.Errorf("credit_rule must be %s or %s", RuleCheckIn, RuleDwell)
+	}
+	return nil
+}
+
+// Evaluate decides what one attendee earns for activity a; scan is nil when
+// they have no standing check-in for it.
+func Evaluate(a models.Activity, scan *models.CreditScan) models.CreditLine {
+	line := models.CreditLine{
+		ActivityID: a.ID,
+		Name:       a.Name,
+		StartTime:  a.StartTime,
+		EndTime:    a.EndTime,
+		Credits:    a.Credits,
+		CreditRule: a.CreditRule,
+	}
+	if scan == nil {
+		line.Reason = "not checked in"
+		return line
+	}
+	checkedIn := scan.CheckedInAt
+	line.CheckedInAt = &checkedIn
+	line.CheckedOutAt = scan.CheckedOutAt
+
+	if a.CreditRule != RuleDwell {
+		line.Earned = a.Credits
+		return line
+	}
+
+	if scan.CheckedOutAt == nil {
+		line.Reason = "not checked out"
+		return line
+	}
+	scheduled := a.EndTime.Sub(a.StartTime)
+	if scheduled <= 0 {
+		// nothing to measure against, so being there at all counts
+		line.Earned = a.Credits
+		return line
+	}
+	// both scans are stamped by the same clock, so their difference holds
+	// up even where the activity times were entered in another zone
+	dwell := scan.CheckedOutAt.Sub(scan.CheckedInAt)
+	percent := math.Max(0, math.Min(100, float64(dwell)/float64(scheduled)*100))
+	percent = math.Round(percent*10) / 10
+	line.DwellPercent = &percent
+
+	if percent < float64(a.MinDwellPercent) {
+		line.Reason = fmt.Sprintf("stayed %.1f%% of the activity, %d%% needed", percent, a.MinDwellPercent)
+		return line
+	}
+	line.Earned = a.Credits
+	return line
+}
+
+// Transcript evaluates every credit-bearing activity for one attendee, whose
+// scans are keyed by activity.
+func Transcript(activities []models.Activity, scans map[uuid.UUID]models.CreditScan) (lines []models.CreditLine, earned, available float64) {
+	lines = []models.CreditLine{}
+	for _, a := range activities {
+		if a.Credits <= 0 {
+			continue
+		}
+		var scan *models.CreditScan
+		if s, ok := scans[a.ID]; ok {
+			scan = &s
+		}
+		line := Evaluate(a, scan)
+		lines = append(lines, line)
+		earned += line.Earned
+		available += a.Credits
+	}
+	// sums of two-decimal values drift in floating point
+	return lines, math.Round(earned*100) / 100, math.Round(available*100) / 100
+}
+
+// ByAttendee groups scans by attendee and then activity.
+func ByAttendee(scans []models.CreditScan) map[uuid.UUID]map[uuid.UUID]models.CreditScan {
+	grouped := map[uuid.UUID]map[uuid.UUID]models.CreditScan{}
+	for _, s := range scans {
+		if grouped[s.UserID] == nil {
+			grouped[s.UserID] = map[uuid.UUID]models.CreditScan{}
+		}
+		grouped[s.UserID][s.ActivityID] = s
+	}
+	return grouped
+}
@@ -0,0 +1,205 @@
+package credits
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+var start = time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+// activity runs for an hour from start.
+func activity(credits float64, rule string, minDwell int) models.Activity {
+	return models.Activity{
+		ID:              uuid.New(),
+		Name:            "Keynote",
+		StartTime:       start,
+		EndTime:         start.Add(time.Hour),
+		Credits:         credits,
+		CreditRule:      rule,
+		MinDwellPercent: minDwell,
+	}
+}
+
+// checkedIn scans in at start and never out.
+func checkedIn() *models.CreditScan {
+	return &models.CreditScan{CheckedInAt: start}
+}
+
+// scan checks in at start and out stay later.
+func scan(stay time.Duration) *models.CreditScan {
+	out := start.Add(stay)
+	return &models.CreditScan{CheckedInAt: start, CheckedOutAt: &out}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name         string
+		credits      float64
+		rule         string
+		minDwell     int
+		wantCredits  float64
+		wantRule     string
+		wantMinDwell int
+		wantErr      bool
+	}{
+		{name: "default rule", credits: 1.5, wantCredits: 1.5, wantRule: RuleCheckIn},
+		{name: "check_in drops the dwell", credits: 1, rule: RuleCheckIn, minDwell: 80, wantCredits: 1, wantRule: RuleCheckIn},
+		{name: "rounds to two decimals", credits: 1.005001, rule: RuleCheckIn, wantCredits: 1.01, wantRule: RuleCheckIn},
+		{name: "dwell", credits: 2, rule: RuleDwell, minDwell: 100, wantCredits: 2, wantRule: RuleDwell, wantMinDwell: 100},
+		{name: "dwell without a percent", credits: 2, rule: RuleDwell, wantErr: true},
+		{name: "dwell over 100", credits: 2, rule: RuleDwell, minDwell: 101, wantErr: true},
+		{name: "negative credits", credits: -1, wantErr: true},
+		{name: "too many credits", credits: MaxCredits + 0.01, wantErr: true},
+		{name: "NaN", credits: math.NaN(), wantErr: true},
+		{name: "unknown rule", credits: 1, rule: "attendance", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credits, rule, minDwell := tt.credits, tt.rule, tt.minDwell
+			err := Normalize(&credits, &rule, &minDwell)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Normalize succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if credits != tt.wantCredits || rule != tt.wantRule || minDwell != tt.wantMinDwell {
+				t.Errorf("Normalize = %v %q %d, want %v %q %d", credits, rule, minDwell, tt.wantCredits, tt.wantRule, tt.wantMinDwell)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	pct := func(p float64) *float64 { return &p }
+	tests := []struct {
+		name       string
+		activity   models.Activity
+		scan       *models.CreditScan
+		wantEarned float64
+		wantDwell  *float64
+		wantReason bool
+	}{
+		{
+			name:       "not checked in",
+			activity:   activity(1, RuleCheckIn, 0),
+			wantReason: true,
+		},
+		{
+			name:       "check_in needs no checkout",
+			activity:   activity(1.5, RuleCheckIn, 0),
+			scan:       checkedIn(),
+			wantEarned: 1.5,
+		},
+		{
+			name:       "dwell without a checkout",
+			activity:   activity(2, RuleDwell, 80),
+			scan:       checkedIn(),
+			wantReason: true,
+		},
+		{
+			name:       "dwell exactly at the threshold",
+			activity:   activity(2, RuleDwell, 80),
+			scan:       scan(48 * time.Minute),
+			wantEarned: 2,
+			wantDwell:  pct(80),
+		},
+		{
+			name:       "dwell just under the threshold",
+			activity:   activity(2, RuleDwell, 80),
+			scan:       scan(47*time.Minute + 54*time.Second),
+			wantDwell:  pct(79.8),
+			wantReason: true,
+		},
+		{
+			name:       "dwell is capped at 100",
+			activity:   activity(2, RuleDwell, 100),
+			scan:       scan(2 * time.Hour),
+			wantEarned: 2,
+			wantDwell:  pct(100),
+		},
+		{
+			name:       "checkout before check-in counts as 0",
+			activity:   activity(2, RuleDwell, 1),
+			scan:       scan(-time.Minute),
+			wantDwell:  pct(0),
+			wantReason: true,
+		},
+		{
+			name: "no scheduled length",
+			activity: func() models.Activity {
+				a := activity(3, RuleDwell, 80)
+				a.EndTime = a.StartTime
+				return a
+			}(),
+			scan:       scan(time.Minute),
+			wantEarned: 3,
+		},
+		{
+			name: "negative scheduled length",
+			activity: func() models.Activity {
+				a := activity(3, RuleDwell, 80)
+				a.EndTime = a.StartTime.Add(-time.Hour)
+				return a
+			}(),
+			scan:       scan(time.Minute),
+			wantEarned: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := Evaluate(tt.activity, tt.scan)
+			if line.Earned != tt.wantEarned {
+				t.Errorf("Earned = %v, want %v", line.Earned, tt.wantEarned)
+			}
+			if (line.Reason != "") != tt.wantReason {
+				t.Errorf("Reason = %q, want one: %v", line.Reason, tt.wantReason)
+			}
+			switch {
+			case tt.wantDwell == nil && line.DwellPercent != nil:
+				t.Errorf("DwellPercent = %v, want none", *line.DwellPercent)
+			case tt.wantDwell != nil && (line.DwellPercent == nil || *line.DwellPercent != *tt.wantDwell):
+				t.Errorf("DwellPercent = %v, want %v", line.DwellPercent, *tt.wantDwell)
+			}
+			if line.ActivityID != tt.activity.ID || line.Credits != tt.activity.Credits {
+				t.Errorf("line doesn't describe the activity: %+v", line)
+			}
+		})
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	// ten activities worth 0.1 each sum to 0.9999999999999999 in floating point
+	var activities []models.Activity
+	scans := map[uuid.UUID]models.CreditScan{}
+	for i := 0; i < 10; i++ {
+		a := activity(0.1, RuleCheckIn, 0)
+		activities = append(activities, a)
+		if i < 7 {
+			scans[a.ID] = *checkedIn()
+		}
+	}
+	free := activity(0, RuleCheckIn, 0)
+	activities = append(activities, free)
+	scans[free.ID] = *checkedIn()
+
+	lines, earned, available := Transcript(activities, scans)
+	if len(lines) != 10 {
+		t.Errorf("got %d lines, want 10 without the activity worth nothing", len(lines))
+	}
+	if earned != 0.7 || available != 1 {
+		t.Errorf("earned %v of %v, want 0.7 of 1", earned, available)
+	}
+
+	lines, earned, available = Transcript(nil, nil)
+	if lines == nil || len(lines) != 0 || earned != 0 || available != 0 {
+		t.Errorf("empty transcript = %v, %v, %v", lines, earned, available)
+	}
+}
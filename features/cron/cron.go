@@ -0,0 +1,209 @@
+// Package cron parses standard five-field cron expressions and computes when
+// they next fire:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields take *, numbers, ranges (1-5), steps (*/15, 8-18/2) and lists of
+// those (1,15,30). Day of week runs 0-6 from Sunday, with 7 also Sunday.
+// As in Vixie cron, when both day fields are restricted a day matching
+// either one fires. The descriptors @hourly, @daily (or @midnight), @weekly,
+// @monthly and @yearly (or @annually) stand for their usual expressions.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed expression. Each field is a bit set of the values it
+// matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when the field was *, which matters for
+	// how the two day fields combine.
+	domStar, dowStar bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fields = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse reads expr, returning an error that names the offending field.
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@") {
+		full, ok := descriptors[strings.ToLower(expr)]
+		if !ok {
+			return Schedule{}, fmt.Errorf("unknown descriptor %q", expr)
+		}
+		expr = full
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(parts))
+	}
+
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return Schedule{}, err
+		}
+		sets[i] = set
+	}
+	// 7 is another name for Sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	return Schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s: invalid step %q", b.name, stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := b.min, b.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = value(from, b); err != nil {
+				return 0, err
+			}
+			if hi, err = value(to, b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s: range %q runs backwards", b.name, rangePart)
+			}
+		default:
+			n, err := value(rangePart, b)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			// a single value with a step, e.g. 5/15, runs to the end
+			hi = n
+			if hasStep {
+				hi = b.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func value(s string, b bounds) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", b.name, s)
+	}
+	if n < b.min || n > b.max {
+		return 0, fmt.Errorf("%s: %d is outside %d-%d", b.name, n, b.min, b.max)
+	}
+	return n, nil
+}
+
+// everyHour is the hour set of *.
+const everyHour = 1<<24 - 1
+
+// Next returns the first time after t the schedule fires, in t's location,
+// or the zero time if it never does (e.g. 0 0 30 2 *). Wall clock times
+// skipped by a daylight saving change don't fire, and those it repeats fire
+// once, unless the schedule runs every hour.
+func (s Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = s.nextMinute(t.Truncate(time.Minute))
+	// every expression that fires at all does so within a leap year cycle
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				// the end of daylight saving repeats an hour
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = s.nextMinute(t)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// nextMinute is the minute after t, past the repeat of t's hour if daylight
+// saving ends there and the schedule is limited to some hours.
+func (s Schedule) nextMinute(t time.Time) time.Time {
+	next := t.Add(time.Minute)
+	if next.Hour() == t.Hour() && next.Minute() < t.Minute() && s.hour != everyHour {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
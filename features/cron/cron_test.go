@@ -0,0 +1,231 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, expr string) Schedule {
+	t.Helper()
+	s, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", expr, err)
+	}
+	return s
+}
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@fortnightly",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			name: "every 15 minutes",
+			expr: "*/15 * * * *",
+			from: time.Date(2026, 10, 1, 9, 7, 30, 0, utc),
+			want: []time.Time{
+				time.Date(2026, 10, 1, 9, 15, 0, 0, utc),
+				time.Date(2026, 10, 1, 9, 30, 0, 0, utc),
+			},
+		},
+		{
+			name: "never fires at from itself",
+			expr: "0 9 * * *",
+			from: time.Date(2026, 10, 1, 9, 0, 0, 0, utc),
+			want: []time.Time{time.Date(2026, 10, 2, 9, 0, 0, 0, utc)},
+		},
+		{
+			// 2026-10-01 is a Thursday: the next Friday is the 2nd and the
+			// next 13th a Tuesday, and both fire
+			name: "day of month or day of week",
+			expr: "0 9 13 * 5",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, utc),
+			want: []time.Time{
+				time.Date(2026, 10, 2, 9, 0, 0, 0, utc),
+				time.Date(2026, 10, 9, 9, 0, 0, 0, utc),
+				time.Date(2026, 10, 13, 9, 0, 0, 0, utc),
+				time.Date(2026, 10, 16, 9, 0, 0, 0, utc),
+			},
+		},
+		{
+			name: "day of month alone",
+			expr: "0 9 13 * *",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, utc),
+			want: []time.Time{
+				time.Date(2026, 10, 13, 9, 0, 0, 0, utc),
+				time.Date(2026, 11, 13, 9, 0, 0, 0, utc),
+			},
+		},
+		{
+			name: "day of week alone",
+			expr: "0 9 * * 1-5",
+			from: time.Date(2026, 10, 2, 10, 0, 0, 0, utc),
+			want: []time.Time{
+				time.Date(2026, 10, 5, 9, 0, 0, 0, utc),
+				time.Date(2026, 10, 6, 9, 0, 0, 0, utc),
+			},
+		},
+		{
+			name: "7 is Sunday",
+			expr: "0 0 * * 7",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, utc),
+			want: []time.Time{
+				time.Date(2026, 10, 4, 0, 0, 0, 0, utc),
+				time.Date(2026, 10, 11, 0, 0, 0, 0, utc),
+			},
+		},
+		{
+			name: "descriptor",
+			expr: "@yearly",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, utc),
+			want: []time.Time{time.Date(2027, 1, 1, 0, 0, 0, 0, utc)},
+		},
+		{
+			name: "29 February",
+			expr: "0 12 29 2 *",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, utc),
+			want: []time.Time{time.Date(2028, 2, 29, 12, 0, 0, 0, utc)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mustParse(t, tt.expr)
+			from := tt.from
+			for _, want := range tt.want {
+				got := s.Next(from)
+				if !got.Equal(want) {
+					t.Fatalf("Next(%s) = %s, want %s", from, got, want)
+				}
+				from = got
+			}
+		})
+	}
+}
+
+func TestSundayAliases(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	zero, seven := mustParse(t, "0 0 * * 0"), mustParse(t, "0 0 * * 7")
+	if zero != seven {
+		t.Errorf("0 and 7 parse differently: %+v, %+v", zero, seven)
+	}
+	if got := mustParse(t, "0 0 * * 5-7").Next(from.AddDate(0, 0, 2)); !got.Equal(time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("5-7 from Saturday = %s, want Sunday 4 October", got)
+	}
+}
+
+func TestNeverFires(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		if got := mustParse(t, expr).Next(from); !got.IsZero() {
+			t.Errorf("%q fires at %s, want never", expr, got)
+		}
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	ny := mustLocation(t, "America/New_York")
+	edt := time.FixedZone("EDT", -4*60*60)
+	est := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			// 2:00-3:00 doesn't exist on 8 March 2026
+			name: "gap skips the missing time",
+			expr: "30 2 * * *",
+			from: time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2026, 3, 9, 2, 30, 0, 0, edt),
+				time.Date(2026, 3, 10, 2, 30, 0, 0, edt),
+			},
+		},
+		{
+			name: "gap keeps times after it",
+			expr: "0 3 * * *",
+			from: time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			want: []time.Time{time.Date(2026, 3, 8, 3, 0, 0, 0, edt)},
+		},
+		{
+			// 1:00-2:00 happens twice on 1 November 2026
+			name: "overlap fires once",
+			expr: "30 1 * * *",
+			from: time.Date(2026, 10, 31, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2026, 11, 1, 1, 30, 0, 0, edt),
+				time.Date(2026, 11, 2, 1, 30, 0, 0, est),
+			},
+		},
+		{
+			name: "overlap at the end of the hour fires once",
+			expr: "59 1 * * *",
+			from: time.Date(2026, 10, 31, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2026, 11, 1, 1, 59, 0, 0, edt),
+				time.Date(2026, 11, 2, 1, 59, 0, 0, est),
+			},
+		},
+		{
+			name: "hourly runs through the overlap",
+			expr: "0 * * * *",
+			from: time.Date(2026, 11, 1, 0, 30, 0, 0, edt),
+			want: []time.Time{
+				time.Date(2026, 11, 1, 1, 0, 0, 0, edt),
+				time.Date(2026, 11, 1, 1, 0, 0, 0, est),
+				time.Date(2026, 11, 1, 2, 0, 0, 0, est),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mustParse(t, tt.expr)
+			from := tt.from.In(ny)
+			for _, want := range tt.want {
+				got := s.Next(from)
+				if !got.Equal(want) {
+					t.Fatalf("Next(%s) = %s, want %s", from, got, want)
+				}
+				if got.Location() != ny {
+					t.Errorf("Next returned %s, want it in %s", got.Location(), ny)
+				}
+				from = got
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"time"
+)
+
+// utf8BOM makes Excel open the file as UTF-8 instead of the system code page.
+const utf8BOM = "\xEF\xBB\xBF"
+
+var CSV = Format{
+	Name:        "csv",
+	MediaType:   "text/csv",
+	ContentType: "text/csv; charset=utf-8",
+	Extension:   "csv",
+	New:         newCSV,
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSV(w io.Writer, t Table) (Writer, error) {
+	// csv.Writer reuses a *bufio.Writer it's given, so the BOM and header
+	// stay buffered with the first rows
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(utf8BOM); err != nil {
+		return nil, err
+	}
+	cw := csv.NewWriter(bw)
+
+	header := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		header[i] = c.Title
+	}
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvWriter{w: cw}, nil
+}
+
+func (c *csvWriter) WriteRow(values []any) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = text(v, time.RFC3339)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
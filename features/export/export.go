@@ -0,0 +1,206 @@
+// Package export writes tabular data as downloadable documents. Each Format
+// turns the same rows into a different file type, so a handler builds its
+// rows once and lets the client pick the format.
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/munnerz/goautoneg"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown export format")
+	ErrNotAcceptable = errors.New("no export format matches the Accept header")
+)
+
+// Column is one field of the exported rows.
+type Column struct {
+	// Key names the field in formats with named fields (NDJSON).
+	Key string
+	// Title heads the column in tabular formats.
+	Title string
+	// Width is the column's share of the page in the PDF; 0 counts as 1.
+	Width float64
+}
+
+// Table describes the document being written.
+type Table struct {
+	// Title names the sheet and heads the printed page.
+	Title   string
+	Columns []Column
+}
+
+// Writer takes rows whose values line up with Table.Columns. Values are
+// strings, integers, floats, time.Time or nil.
+type Writer interface {
+	WriteRow(values []any) error
+	// Close completes the document. Formats that can't be streamed write
+	// everything here.
+	Close() error
+}
+
+type Format struct {
+	// Name is what clients pass as ?format=.
+	Name string
+	// MediaType is matched against the Accept header.
+	MediaType string
+	// ContentType is sent with the response; MediaType when empty.
+	ContentType string
+	Extension   string
+	New         func(w io.Writer, t Table) (Writer, error)
+}
+
+var registry []Format
+
+// Register makes f available to Lookup and Negotiate. A format registered
+// under an existing name replaces it.
+func Register(f Format) {
+	for i := range registry {
+		if registry[i].Name == f.Name {
+			registry[i] = f
+			return
+		}
+	}
+	registry = append(registry, f)
+}
+
+func init() {
+	Register(XLSX)
+	Register(CSV)
+	Register(NDJSON)
+	Register(PDF)
+}
+
+func Lookup(name string) (Format, bool) {
+	for _, f := range registry {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// Negotiate picks the format named by the ?format= query parameter, or
+// failing that the best match for the Accept header, among every registered
+// format. def is used when neither says anything more specific than */*.
+func Negotiate(name, accept string, def Format) (Format, error) {
+	formats := []Format{def}
+	for _, f := range registry {
+		if f.Name != def.Name {
+			formats = append(formats, f)
+		}
+	}
+	return NegotiateFrom(name, accept, formats)
+}
+
+// NegotiateFrom is Negotiate restricted to formats, the first of which is
+// the default.
+func NegotiateFrom(name, accept string, formats []Format) (Format, error) {
+	if name != "" {
+		for _, f := range formats {
+			if strings.EqualFold(f.Name, name) {
+				return f, nil
+			}
+		}
+		return Format{}, fmt.Errorf("%w %q", ErrUnknownFormat, name)
+	}
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], nil
+	}
+
+	// the default goes first so a wildcard Accept resolves to it
+	alternatives := make([]string, len(formats))
+	for i, f := range formats {
+		alternatives[i] = f.MediaType
+	}
+	match := goautoneg.Negotiate(accept, alternatives)
+	for _, f := range formats {
+		if match != "" && f.MediaType == match {
+			return f, nil
+		}
+	}
+	return Format{}, ErrNotAcceptable
+}
+
+// Filename builds "<base>-<timestamp>.<ext>" with base reduced by Slug so it
+// survives every browser and OS.
+func Filename(base string, at time.Time, f Format) string {
+	slug := Slug(base)
+	if slug == "" {
+		slug = "export"
+	}
+	return fmt.Sprintf("%s-%s.%s", slug, at.UTC().Format("20060102-150405"), f.Extension)
+}
+
+// Slug reduces s to lowercase ASCII letters, digits and single dashes.
+func Slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// Download is an io.Writer over an HTTP response that sends the download
+// headers with the first byte. Until then nothing is committed, so an error
+// can still be answered with a regular error response.
+type Download struct {
+	w        http.ResponseWriter
+	format   Format
+	filename string
+	started  bool
+}
+
+func NewDownload(w http.ResponseWriter, f Format, filename string) *Download {
+	return &Download{w: w, format: f, filename: filename}
+}
+
+func (d *Download) Write(p []byte) (int, error) {
+	if !d.started {
+		d.started = true
+		contentType := d.format.ContentType
+		if contentType == "" {
+			contentType = d.format.MediaType
+		}
+		d.w.Header().Set("Content-Type", contentType)
+		d.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, d.filename))
+		d.w.WriteHeader(http.StatusOK)
+	}
+	return d.w.Write(p)
+}
+
+// Started reports whether the response has been committed.
+func (d *Download) Started() bool {
+	return d.started
+}
+
+// text renders a value for formats that only hold strings.
+func text(v any, layout string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(layout)
+	default:
+		return fmt.Sprint(v)
+	}
+}
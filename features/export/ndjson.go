@@ -0,0 +1,54 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// NDJSON writes one JSON object per row, keyed by Column.Key in column order.
+var NDJSON = Format{
+	Name:      "ndjson",
+	MediaType: "application/x-ndjson",
+	Extension: "ndjson",
+	New:       newNDJSON,
+}
+
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func newNDJSON(w io.Writer, t Table) (Writer, error) {
+	keys := make([][]byte, len(t.Columns))
+	for i, c := range t.Columns {
+		key, err := json.Marshal(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return &ndjsonWriter{w: bufio.NewWriter(w), keys: keys}, nil
+}
+
+func (n *ndjsonWriter) WriteRow(values []any) error {
+	n.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n.w.Write(n.keys[i])
+		n.w.WriteByte(':')
+		n.w.Write(value)
+	}
+	_, err := n.w.WriteString("}\n")
+	return err
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
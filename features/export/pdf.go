@@ -0,0 +1,117 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// PDF is a printable sheet: landscape A4 with the title and column headers
+// repeated on every page. It uses the core fonts, so characters outside
+// Windows-1252 don't render.
+var PDF = Format{
+	Name:      "pdf",
+	MediaType: "application/pdf",
+	Extension: "pdf",
+	New:       newPDF,
+}
+
+const (
+	pdfRowHeight = 6.0
+	pdfFontSize  = 8.0
+	// pdfCellPadding keeps cut text clear of the cell borders
+	pdfCellPadding = 2.0
+)
+
+type pdfWriter struct {
+	out    io.Writer
+	pdf    *fpdf.Fpdf
+	tr     func(string) string
+	widths []float64
+	row    int
+}
+
+func newPDF(w io.Writer, t Table) (Writer, error) {
+	pdf := fpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(true, 12)
+	pdf.AliasNbPages("")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	usable := pageWidth - left - right
+
+	total := 0.0
+	for _, c := range t.Columns {
+		total += columnWeight(c)
+	}
+	widths := make([]float64, len(t.Columns))
+	for i, c := range t.Columns {
+		widths[i] = usable * columnWeight(c) / total
+	}
+
+	generated := time.Now().UTC().Format("2006-01-02 15:04 UTC")
+	pdf.SetHeaderFunc(func() {
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.CellFormat(usable, 8, tr(t.Title), "", 1, "L", false, 0, "")
+		pdf.Ln(2)
+
+		pdf.SetFont("Helvetica", "B", pdfFontSize)
+		pdf.SetFillColor(220, 220, 220)
+		for i, c := range t.Columns {
+			pdf.CellFormat(widths[i], pdfRowHeight, Fit(pdf, tr(c.Title), widths[i]-pdfCellPadding), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", pdfFontSize)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-10)
+		pdf.SetFont("Helvetica", "I", 7)
+		pdf.CellFormat(usable/2, 5, "Generated "+generated, "", 0, "L", false, 0, "")
+		pdf.CellFormat(usable/2, 5, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	return &pdfWriter{out: w, pdf: pdf, tr: tr, widths: widths}, pdf.Error()
+}
+
+func (p *pdfWriter) WriteRow(values []any) error {
+	// shade every other row so lines are easy to follow on paper
+	p.pdf.SetFillColor(245, 245, 245)
+	fill := p.row%2 == 1
+	p.row++
+
+	for i, v := range values {
+		s := Fit(p.pdf, p.tr(text(v, "2006-01-02 15:04")), p.widths[i]-pdfCellPadding)
+		p.pdf.CellFormat(p.widths[i], pdfRowHeight, s, "1", 0, "L", fill, 0, "")
+	}
+	p.pdf.Ln(-1)
+	return p.pdf.Error()
+}
+
+func (p *pdfWriter) Close() error {
+	return p.pdf.Output(p.out)
+}
+
+func columnWeight(c Column) float64 {
+	if c.Width <= 0 {
+		return 1
+	}
+	return c.Width
+}
+
+// Fit shortens s with an ellipsis until it is at most width wide in pdf's
+// current font. s is already translated to the single byte font encoding,
+// so it is cut by bytes. Badges and certificates use it too.
+func Fit(pdf *fpdf.Fpdf, s string, width float64) string {
+	if pdf.GetStringWidth(s) <= width {
+		return s
+	}
+	for len(s) > 0 && pdf.GetStringWidth(s+"...") > width {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
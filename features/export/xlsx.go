@@ -0,0 +1,84 @@
+package export
+
+import (
+	"io"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+var XLSX = Format{
+	Name:      "xlsx",
+	MediaType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	Extension: "xlsx",
+	New:       newXLSX,
+}
+
+// maxSheetName is Excel's limit on sheet names.
+const maxSheetName = 31
+
+type xlsxWriter struct {
+	out  io.Writer
+	file *excelize.File
+	sw   *excelize.StreamWriter
+	row  int
+}
+
+func newXLSX(w io.Writer, t Table) (Writer, error) {
+	f := excelize.NewFile()
+	sheet := sheetName(t.Title)
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		f.Close()
+		return nil, err
+	}
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	header := make([]any, len(t.Columns))
+	for i, c := range t.Columns {
+		header[i] = c.Title
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// excel rows start at 1 and row 1 is the header
+	return &xlsxWriter{out: w, file: f, sw: sw, row: 2}, nil
+}
+
+func (x *xlsxWriter) WriteRow(values []any) error {
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	x.row++
+	return x.sw.SetRow(cell, values)
+}
+
+func (x *xlsxWriter) Close() error {
+	defer x.file.Close()
+	if err := x.sw.Flush(); err != nil {
+		return err
+	}
+	return x.file.Write(x.out)
+}
+
+// sheetName trims title to something Excel accepts as a sheet name.
+func sheetName(title string) string {
+	name := []rune(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/?*:[]`, r) {
+			return '-'
+		}
+		return r
+	}, title))
+	if len(name) > maxSheetName {
+		name = name[:maxSheetName]
+	}
+	if len(name) == 0 {
+		return "Sheet1"
+	}
+	return string(name)
+}
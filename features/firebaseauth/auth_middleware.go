@@ -2,11 +2,14 @@ package firebaseauth
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"firebase.google.com/go/auth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/metrics"
 )
 
 type contextKey string
@@ -26,28 +29,55 @@ func (f *FirebaseAuth) AuthMiddleware(next http.Handler) http.Handler {
 
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			log.Print("Misssing Authorization header: " + authHeader)
+			slog.WarnContext(r.Context(), "missing Authorization header")
+			metrics.AuthFailures.WithLabelValues("missing_header").Inc()
 			http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
 			return
 		}
 
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 		if token == authHeader {
-			log.Print("Malformed Authorization header: " + authHeader)
+			slog.WarnContext(r.Context(), "malformed Authorization header")
+			metrics.AuthFailures.WithLabelValues("malformed_header").Inc()
 			http.Error(w, "Malformed Authorization header", http.StatusUnauthorized)
 			return
 		}
 
+		if f.APIKeys != nil && strings.HasPrefix(token, apiKeyPrefix) {
+			f.serveAPIKey(w, r, next, token)
+			return
+		}
+
+		start := time.Now()
 		user, err := f.VerifyUserByIdToken(r.Context(), token)
+		metrics.AuthDuration.Observe(time.Since(start).Seconds())
 
 		if err != nil {
-			log.Print("Firebase Authorization header: " + err.Error())
+			slog.WarnContext(r.Context(), "firebase token verification failed", "err", err)
+			metrics.AuthFailures.WithLabelValues("invalid_token").Inc()
 			http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
 			return
 		}
     
+		logging.AddAttrs(r.Context(), slog.String("uid", user.UID))
 		ctx := context.WithValue(r.Context(), FirebaseUserContextKey, user)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// serveAPIKey authenticates the request with an API key instead; handlers
+// see the user the key acts as, like any other.
+func (f *FirebaseAuth) serveAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	user, err := f.APIKeys.VerifyAPIKey(r.Context(), token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "API key rejected", "err", err)
+		metrics.AuthFailures.WithLabelValues("invalid_api_key").Inc()
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	logging.AddAttrs(r.Context(), slog.String("uid", user.UID))
+	ctx := context.WithValue(r.Context(), FirebaseUserContextKey, user)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,77 @@
+package firebaseauth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/auth"
+)
+
+// userCache is a size bounded LRU of user records with a per entry TTL, so
+// profile changes (name, photo) show up again after at most ttl.
+type userCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type cacheEntry struct {
+	uid     string
+	user    *auth.UserRecord
+	expires time.Time
+}
+
+func newUserCache(ttl time.Duration, capacity int) *userCache {
+	return &userCache{
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *userCache) get(uid string) (*auth.UserRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[uid]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.items, uid)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.user, true
+}
+
+func (c *userCache) put(user *auth.UserRecord) {
+	if c.capacity <= 0 || user == nil || user.UserInfo == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[user.UID]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.user = user
+		entry.expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[user.UID] = c.order.PushFront(&cacheEntry{uid: user.UID, user: user, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).uid)
+	}
+}
@@ -2,14 +2,12 @@ package firebaseauth
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
-	"log"
-	"os"
+	"time"
 
 	firebase "firebase.google.com/go"
 	"firebase.google.com/go/auth"
-	"github.com/joho/godotenv"
+	"github.com/koiraladarwin/scanin/features/metrics"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
@@ -17,34 +15,38 @@ import (
 type FirebaseAuth struct {
 	App        *firebase.App
 	AuthClient *auth.Client
+	ping       pingCache
+	users      *userCache
+
+	// APIKeys, when set, lets AuthMiddleware accept "Bearer sk_..." API
+	// keys as well as ID tokens.
+	APIKeys APIKeyVerifier
 }
 
+// APIKeyVerifier resolves an API key to the user it acts as; see package
+// apikeys.
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, token string) (*auth.UserRecord, error)
+}
 
-func NewFirebaseAuth(ctx context.Context) (*FirebaseAuth, error) {
-    // Load .env locally if not on Railway
-    if os.Getenv("RAILWAY_ENVIRONMENT_ID") == "" {
-        if err := godotenv.Load(); err != nil {
-            log.Println(".env file not found, using system env vars instead")
-        }
-    }
+// apiKeyPrefix is apikeys.Prefix, repeated so this package needn't depend on
+// the database.
+const apiKeyPrefix = "sk_"
 
-    var creds []byte
-    var err error
-
-    b64Creds := os.Getenv("FIREBASE_CONFIG_B64")
-    if b64Creds != "" {
-        creds, err = base64.StdEncoding.DecodeString(b64Creds)
-        if err != nil {
-            return nil, fmt.Errorf("failed to decode FIREBASE_CONFIG_B64: %w", err)
-        }
-    } else {
-        jsonCreds := os.Getenv("FIREBASE_CONFIG_JSON")
-        if jsonCreds == "" {
-            return nil, fmt.Errorf("no firebase credentials found in env vars")
-        }
-        creds = []byte(jsonCreds)
-    }
+type Options struct {
+	// UserCacheTTL and UserCacheSize bound the cache GetUser and GetUsers
+	// read through; a size of 0 disables it.
+	UserCacheTTL  time.Duration
+	UserCacheSize int
+}
+
+// maxGetUsersBatch is the most identifiers the Admin SDK accepts per GetUsers
+// call.
+const maxGetUsersBatch = 100
 
+// NewFirebaseAuth builds the Admin SDK client from the service account JSON
+// (see config.Config.FirebaseCredentials).
+func NewFirebaseAuth(ctx context.Context, creds []byte, opts Options) (*FirebaseAuth, error) {
     opt := option.WithCredentialsJSON(creds)
 
     app, err := firebase.NewApp(ctx, nil, opt)
@@ -60,6 +62,7 @@ func NewFirebaseAuth(ctx context.Context) (*FirebaseAuth, error) {
     return &FirebaseAuth{
         App:        app,
         AuthClient: authClient,
+        users:      newUserCache(opts.UserCacheTTL, opts.UserCacheSize),
     }, nil
 }
 
@@ -68,32 +71,84 @@ func (f *FirebaseAuth) verifyIDToken(ctx context.Context, idToken string) (*auth
 	return f.AuthClient.VerifyIDToken(ctx, idToken)
 }
 
+// VerifyUserByIdToken checks the token's signature and claims against the
+// cached Google signing keys and builds the user from its claims, so it
+// normally makes no network call. Only UID, email, name and picture are
+// filled in; use GetUser when the full record is needed.
 func (f *FirebaseAuth) VerifyUserByIdToken(ctx context.Context, idToken string) (*auth.UserRecord, error) {
 	token, err := f.verifyIDToken(ctx, idToken)
 	if err != nil {
 		return nil, err
 	}
+	return userFromToken(token), nil
+}
 
-	userRecord, err := f.AuthClient.GetUser(ctx, token.UID)
-	if err != nil {
-		return nil, err
+func userFromToken(token *auth.Token) *auth.UserRecord {
+	claim := func(name string) string {
+		v, _ := token.Claims[name].(string)
+		return v
+	}
+	verified, _ := token.Claims["email_verified"].(bool)
+
+	return &auth.UserRecord{
+		UserInfo: &auth.UserInfo{
+			UID:         token.UID,
+			Email:       claim("email"),
+			DisplayName: claim("name"),
+			PhotoURL:    claim("picture"),
+			ProviderID:  token.Firebase.SignInProvider,
+		},
+		EmailVerified: verified,
 	}
-	return userRecord, nil
 }
 
+// GetUser returns the full user record for uid, from the cache if possible.
+func (f *FirebaseAuth) GetUser(ctx context.Context, uid string) (*auth.UserRecord, error) {
+	if user, ok := f.users.get(uid); ok {
+		metrics.FirebaseUserCache.WithLabelValues("hit").Inc()
+		return user, nil
+	}
+	metrics.FirebaseUserCache.WithLabelValues("miss").Inc()
 
-func (f *FirebaseAuth) GetUserByIdToken(ctx context.Context, idToken string) (*auth.UserRecord, error) {
-    token, err := f.AuthClient.GetUser(ctx, idToken)
-    if err != nil {
-        return nil, fmt.Errorf("failed to verify ID token: %w", err)
-    }
+	user, err := f.AuthClient.GetUser(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user record: %w", err)
+	}
+	f.users.put(user)
+	return user, nil
+}
 
-    userRecord, err := f.AuthClient.GetUser(ctx, token.UID)
-    if err != nil {
-        return nil, fmt.Errorf("failed to get user record: %w", err)
-    }
+// GetUsers looks up many users at once, keyed by UID. Cache misses are
+// fetched in batches rather than one request per user; UIDs that don't exist
+// are left out of the result.
+func (f *FirebaseAuth) GetUsers(ctx context.Context, uids []string) (map[string]*auth.UserRecord, error) {
+	found := make(map[string]*auth.UserRecord, len(uids))
+	var missing []auth.UserIdentifier
+	for _, uid := range uids {
+		if _, dup := found[uid]; dup {
+			continue
+		}
+		if user, ok := f.users.get(uid); ok {
+			metrics.FirebaseUserCache.WithLabelValues("hit").Inc()
+			found[uid] = user
+			continue
+		}
+		metrics.FirebaseUserCache.WithLabelValues("miss").Inc()
+		missing = append(missing, auth.UIDIdentifier{UID: uid})
+	}
 
-    return userRecord, nil
+	for start := 0; start < len(missing); start += maxGetUsersBatch {
+		end := min(start+maxGetUsersBatch, len(missing))
+		result, err := f.AuthClient.GetUsers(ctx, missing[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user records: %w", err)
+		}
+		for _, user := range result.Users {
+			f.users.put(user)
+			found[user.UID] = user
+		}
+	}
+	return found, nil
 }
 
 
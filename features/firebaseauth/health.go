@@ -0,0 +1,48 @@
+package firebaseauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// publicKeysURL is where the Admin SDK downloads the certificates ID tokens
+// are signed with. If it can't be reached, no token can be verified.
+const publicKeysURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
+// pingCacheTTL keeps readiness probes from hitting Google on every poll.
+const pingCacheTTL = 30 * time.Second
+
+type pingCache struct {
+	mu     sync.Mutex
+	lastOK time.Time
+}
+
+// Ping reports whether the Firebase token signing keys are reachable.
+func (f *FirebaseAuth) Ping(ctx context.Context) error {
+	f.ping.mu.Lock()
+	defer f.ping.mu.Unlock()
+
+	if time.Since(f.ping.lastOK) < pingCacheTTL {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, publicKeysURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("firebase public keys unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("firebase public keys returned %s", resp.Status)
+	}
+
+	f.ping.lastOK = time.Now()
+	return nil
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+type contextKey string
+
+const fieldsContextKey contextKey = "logFields"
+
+// fields is shared by pointer between the request context and everything
+// derived from it, so attributes added deep inside a handler (the event id,
+// the authenticated uid) also show up on the access log line written by
+// Middleware once the handler returns.
+type fields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+func (f *fields) add(attrs ...slog.Attr) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range attrs {
+		replaced := false
+		for i := range f.attrs {
+			if f.attrs[i].Key == a.Key {
+				f.attrs[i] = a
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			f.attrs = append(f.attrs, a)
+		}
+	}
+}
+
+func (f *fields) snapshot() []slog.Attr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]slog.Attr(nil), f.attrs...)
+}
+
+func fieldsFromContext(ctx context.Context) *fields {
+	f, _ := ctx.Value(fieldsContextKey).(*fields)
+	return f
+}
+
+// AddAttrs attaches attributes to the current request's log records. It is a
+// no-op outside a request handled by Middleware.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if f := fieldsFromContext(ctx); f != nil {
+		f.add(attrs...)
+	}
+}
+
+// RequestID returns the id Middleware assigned to the request, or "".
+func RequestID(ctx context.Context) string {
+	f := fieldsFromContext(ctx)
+	if f == nil {
+		return ""
+	}
+	for _, a := range f.snapshot() {
+		if a.Key == "request_id" {
+			return a.Value.String()
+		}
+	}
+	return ""
+}
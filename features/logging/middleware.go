@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds what we accept from X-Request-ID so a client
+// can't stuff arbitrary payloads into every log line.
+const maxRequestIDLength = 128
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Middleware gives every request a request id (reusing a sane incoming
+// X-Request-ID), echoes it back in the response, attaches the id, route and
+// any event/activity/attendee path ids to the request's log records, and
+// writes one access log line when the request finishes.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		f := &fields{}
+		f.add(
+			slog.String("request_id", requestID),
+			slog.String("method", r.Method),
+			slog.String("route", route),
+		)
+		vars := mux.Vars(r)
+		for _, key := range []string{"event_id", "activity_id", "attendee_id"} {
+			if v, ok := vars[key]; ok {
+				f.add(slog.String(key, v))
+			}
+		}
+		// the /events/{id}/... routes name the event just id
+		if v, ok := vars["id"]; ok && (route == "/events/{id}" || strings.HasPrefix(route, "/events/{id}/")) {
+			f.add(slog.String("event_id", v))
+		}
+		if v := r.URL.Query().Get("event_id"); v != "" {
+			f.add(slog.String("event_id", v))
+		}
+
+		ctx := context.WithValue(r.Context(), fieldsContextKey, f)
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if rec.status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(ctx, level, "request completed",
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		)
+	})
+}
@@ -0,0 +1,251 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envelope is one message as a fakeSMTP server received it.
+type envelope struct {
+	from string
+	to   []string
+	auth string
+	data []byte
+}
+
+// fakeSMTP accepts one connection and speaks just enough SMTP for Send,
+// without STARTTLS.
+func fakeSMTP(t *testing.T) (host string, port int, got <-chan envelope) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan envelope, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		var env envelope
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				reply("250-fake")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				env.auth = arg
+				reply("235 ok")
+			case "MAIL":
+				env.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+				reply("250 ok")
+			case "RCPT":
+				env.to = append(env.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				var data bytes.Buffer
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				env.data = data.Bytes()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				ch <- env
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func TestSend(t *testing.T) {
+	host, port, got := fakeSMTP(t)
+	m := New(Options{
+		Host:     host,
+		Port:     port,
+		Username: "reports",
+		Password: "hunter2",
+		From:     "Scanin Reports <reports@example.com>",
+		TLS:      TLSNone,
+	})
+
+	csv := []byte("id,name\n1,Ada Lovelace\n")
+	xlsx := bytes.Repeat([]byte{0x50, 0x4b, 0x03, 0x04, 0xff}, 40)
+	msg := Message{
+		To:      []string{"organiser@example.com"},
+		Subject: "Café night: attendance summary",
+		Text:    "Registered  12\nAttended    9 (75%)\n",
+		Attachments: []Attachment{
+			{Filename: "check-ins.csv", ContentType: "text/csv", Data: csv},
+			{Filename: "check-ins.xlsx", Data: xlsx},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Send(ctx, msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var env envelope
+	select {
+	case env = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server received nothing")
+	}
+	if env.from != "reports@example.com" {
+		t.Errorf("MAIL FROM = %q", env.from)
+	}
+	if len(env.to) != 1 || env.to[0] != "organiser@example.com" {
+		t.Errorf("RCPT TO = %q", env.to)
+	}
+	if want := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00reports\x00hunter2")); env.auth != want {
+		t.Errorf("AUTH = %q, want %q", env.auth, want)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(env.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, msg.Subject)
+	}
+	if to := parsed.Header.Get("To"); to != "organiser@example.com" {
+		t.Errorf("To = %q", to)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/mixed", parsed.Header.Get("Content-Type"), err)
+	}
+
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	text, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := text.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("first part Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(text))
+	if want := strings.ReplaceAll(msg.Text, "\n", "\r\n"); string(body) != want {
+		t.Errorf("text = %q, want %q", body, want)
+	}
+
+	for _, want := range []struct {
+		filename, contentType string
+		data                  []byte
+	}{
+		{"check-ins.csv", "text/csv", csv},
+		{"check-ins.xlsx", "application/octet-stream", xlsx},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("attachment %s: %v", want.filename, err)
+		}
+		if part.FileName() != want.filename {
+			t.Errorf("filename = %q, want %q", part.FileName(), want.filename)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != want.contentType {
+			t.Errorf("%s Content-Type = %q, want %q", want.filename, ct, want.contentType)
+		}
+		encoded, _ := io.ReadAll(part)
+		for _, line := range strings.Split(strings.TrimSpace(string(encoded)), "\r\n") {
+			if len(line) > 76 {
+				t.Errorf("%s has a base64 line of %d characters", want.filename, len(line))
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+		if err != nil || !bytes.Equal(data, want.data) {
+			t.Errorf("%s decodes to %q (%v), want %q", want.filename, data, err, want.data)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("more parts than expected: %v", err)
+	}
+}
+
+func TestSendRequiresStartTLS(t *testing.T) {
+	host, port, _ := fakeSMTP(t)
+	m := New(Options{Host: host, Port: port, From: "reports@example.com"})
+	err := m.Send(context.Background(), Message{To: []string{"organiser@example.com"}, Text: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Send to a server without STARTTLS = %v, want an error", err)
+	}
+}
+
+func TestSendValidates(t *testing.T) {
+	m := New(Options{Host: "127.0.0.1", Port: 1, From: "reports@example.com", TLS: TLSNone})
+	if err := m.Send(context.Background(), Message{Text: "hi"}); err == nil {
+		t.Error("Send without recipients succeeded")
+	}
+	m = New(Options{Host: "127.0.0.1", Port: 1, From: "not an address", TLS: TLSNone})
+	if err := m.Send(context.Background(), Message{To: []string{"a@example.com"}}); err == nil {
+		t.Error("Send with an invalid from address succeeded")
+	}
+}
+
+func TestBuildPlain(t *testing.T) {
+	date := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	raw, err := Build("reports@example.com", Message{To: []string{"a@example.com", "b@example.com"}, Subject: "Hi", Text: "line one\nline two"}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := parsed.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if to := parsed.Header.Get("To"); to != "a@example.com, b@example.com" {
+		t.Errorf("To = %q", to)
+	}
+	if got, _ := parsed.Header.Date(); !got.Equal(date) {
+		t.Errorf("Date = %s, want %s", got, date)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if string(body) != "line one\r\nline two" {
+		t.Errorf("body = %q", body)
+	}
+	if n := bytes.Count(raw, []byte("MIME-Version")); n != 1 {
+		t.Errorf("MIME-Version appears %d times", n)
+	}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dbOpenDesc     = prometheus.NewDesc(namespace+"_db_open_connections", "Established connections, both in use and idle.", nil, nil)
+	dbInUseDesc    = prometheus.NewDesc(namespace+"_db_in_use_connections", "Connections currently in use.", nil, nil)
+	dbIdleDesc     = prometheus.NewDesc(namespace+"_db_idle_connections", "Idle connections.", nil, nil)
+	dbMaxOpenDesc  = prometheus.NewDesc(namespace+"_db_max_open_connections", "Maximum number of open connections (0 is unlimited).", nil, nil)
+	dbWaitDesc     = prometheus.NewDesc(namespace+"_db_wait_count_total", "Times a query had to wait for a free connection.", nil, nil)
+	dbWaitTimeDesc = prometheus.NewDesc(namespace+"_db_wait_duration_seconds_total", "Total time spent waiting for a free connection.", nil, nil)
+)
+
+type dbStatsCollector struct {
+	stats func() sql.DBStats
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbOpenDesc
+	ch <- dbInUseDesc
+	ch <- dbIdleDesc
+	ch <- dbMaxOpenDesc
+	ch <- dbWaitDesc
+	ch <- dbWaitTimeDesc
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(dbOpenDesc, prometheus.GaugeValue, float64(s.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbInUseDesc, prometheus.GaugeValue, float64(s.InUse))
+	ch <- prometheus.MustNewConstMetric(dbIdleDesc, prometheus.GaugeValue, float64(s.Idle))
+	ch <- prometheus.MustNewConstMetric(dbMaxOpenDesc, prometheus.GaugeValue, float64(s.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbWaitDesc, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitTimeDesc, prometheus.CounterValue, s.WaitDuration.Seconds())
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scanin"
+
+// Registry holds every scanin metric plus the Go runtime and process
+// collectors. A dedicated registry keeps /metrics free of anything imported
+// packages may have put on the global default one.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	httpRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	httpInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	AuthDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "auth_verification_duration_seconds",
+		Help:      "Time spent verifying a Firebase ID token.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	AuthFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Rejected requests by reason (missing_header, malformed_header, invalid_token, invalid_api_key).",
+	}, []string{"reason"})
+
+	FirebaseUserCache = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "firebase_user_cache_total",
+		Help:      "Firebase user record lookups by cache result (hit, miss).",
+	}, []string{"result"})
+
+	CheckInsCreated = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "checkins_created_total",
+		Help:      "Successful check-ins, including re-checking a previously unchecked attendee.",
+	})
+
+	DuplicateScansRejected = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_scans_rejected_total",
+		Help:      "Scans rejected because the attendee was already checked in to the activity.",
+	})
+
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected with 429 by rate limit policy.",
+	}, []string{"policy"})
+
+	ImportRows = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "import_rows_total",
+		Help:      "Attendee import rows by result (processed, failed).",
+	}, []string{"result"})
+
+	ReportEmails = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "report_emails_total",
+		Help:      "Scheduled and manually sent report emails by status (sent, failed).",
+	}, []string{"status"})
+
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_delivery_attempts_total",
+		Help:      "Webhook delivery attempts by resulting status (delivered, pending for a retry, failed for good).",
+	}, []string{"status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterDBStats exposes connection pool statistics. stats is called on
+// every scrape.
+func RegisterDBStats(stats func() sql.DBStats) {
+	Registry.MustRegister(&dbStatsCollector{stats: stats})
+}
+
+// Handler serves the registry. If token is not empty the scraper has to send
+// it as "Authorization: Bearer <token>".
+func Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, expected) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
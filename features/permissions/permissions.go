@@ -0,0 +1,137 @@
+// Package permissions is the registry of what staff can do on an event.
+// Access is granted per action; roles are named templates bundling actions.
+// Event creators implicitly hold every action.
+package permissions
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type Action string
+
+const (
+	AttendeeRead  Action = "attendee.read"
+	AttendeeWrite Action = "attendee.write"
+	CheckInRead   Action = "checkin.read"
+	CheckInCreate Action = "checkin.create"
+	CheckInUndo   Action = "checkin.undo"
+	ActivityWrite Action = "activity.write"
+	ExportRun     Action = "export.run"
+	StaffManage   Action = "staff.manage"
+	EventManage   Action = "event.manage"
+)
+
+// All lists every action, in the order they're presented to users.
+var All = []Action{
+	AttendeeRead,
+	AttendeeWrite,
+	CheckInRead,
+	CheckInCreate,
+	CheckInUndo,
+	ActivityWrite,
+	ExportRun,
+	StaffManage,
+	EventManage,
+}
+
+func Valid(a Action) bool {
+	return slices.Contains(All, a)
+}
+
+// Validate checks that every action is known, so a typo can't silently grant
+// nothing.
+func Validate(actions []Action) error {
+	for _, a := range actions {
+		if !Valid(a) {
+			return fmt.Errorf("unknown permission %q", a)
+		}
+	}
+	return nil
+}
+
+// Role is a named set of actions. Built-in roles have no event; custom ones
+// belong to the event they were created on.
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []Action `json:"permissions"`
+	BuiltIn     bool     `json:"built_in"`
+}
+
+const (
+	Scanner    = "scanner"
+	Registrar  = "registrar"
+	Supervisor = "supervisor"
+	Organizer  = "organizer"
+)
+
+// BuiltIn are the templates every event starts with. They're written to the
+// database on startup, so changing one here changes it for everyone holding
+// it.
+var BuiltIn = []Role{
+	{
+		Name:        Scanner,
+		Description: "Scans attendees in at the door.",
+		Permissions: []Action{CheckInCreate},
+		BuiltIn:     true,
+	},
+	{
+		Name:        Registrar,
+		Description: "Registers and edits attendees, and can scan.",
+		Permissions: []Action{AttendeeRead, AttendeeWrite, CheckInCreate},
+		BuiltIn:     true,
+	},
+	{
+		Name:        Supervisor,
+		Description: "Oversees scanning: sees and corrects check-ins and exports them.",
+		Permissions: []Action{AttendeeRead, CheckInRead, CheckInCreate, CheckInUndo, ExportRun},
+		BuiltIn:     true,
+	},
+	{
+		Name:        Organizer,
+		Description: "Runs the event alongside its creators.",
+		Permissions: All,
+		BuiltIn:     true,
+	},
+}
+
+// DefaultRole is given to staff who join with the plain staff code; before
+// roles existed every member could scan.
+const DefaultRole = Scanner
+
+func IsBuiltIn(name string) bool {
+	return slices.ContainsFunc(BuiltIn, func(r Role) bool { return r.Name == name })
+}
+
+// ToArray renders actions as a Postgres text[] literal. Actions are
+// validated identifiers, so no quoting is needed.
+func ToArray(actions []Action) string {
+	parts := make([]string, len(actions))
+	for i, a := range actions {
+		parts[i] = string(a)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// FromList parses the comma separated form queries return (array_to_string).
+func FromList(list string) []Action {
+	actions := []Action{}
+	for _, p := range strings.Split(list, ",") {
+		if p != "" {
+			actions = append(actions, Action(p))
+		}
+	}
+	return actions
+}
+
+// Includes reports whether every action in want is in have.
+func Includes(have, want []Action) bool {
+	for _, a := range want {
+		if !slices.Contains(have, a) {
+			return false
+		}
+	}
+	return true
+}
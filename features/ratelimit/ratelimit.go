@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/metrics"
+	"github.com/koiraladarwin/scanin/utils"
+	"golang.org/x/time/rate"
+)
+
+// DeviceIDHeader is sent by the scanner apps so a single misbehaving device
+// can be throttled without locking out everyone else on the same account.
+const DeviceIDHeader = "X-Device-ID"
+
+const maxDeviceIDLength = 128
+
+// idleTTL is how long an untouched bucket is kept. By then it has refilled
+// completely, so dropping it is indistinguishable from keeping it.
+const idleTTL = 10 * time.Minute
+
+// Policy is a token bucket: Burst requests at once, refilled at Rate per
+// second.
+type Policy struct {
+	Name  string
+	Rate  rate.Limit
+	Burst int
+}
+
+var (
+	// Default applies to every authenticated route.
+	Default = Policy{Name: "default", Rate: 10, Burst: 30}
+
+	// CodeRedemption guards /addeventwithcode/{code}; at 5 tries a minute
+	// enumerating 6-character codes is not practical.
+	CodeRedemption = Policy{Name: "code_redemption", Rate: rate.Every(12 * time.Second), Burst: 5}
+)
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter keeps one bucket per Firebase UID, device of a UID and client IP.
+// A request has to get a token from each of the buckets that applies to it.
+type Limiter struct {
+	policy Policy
+
+	// trustedProxies is how many proxies' X-Forwarded-For entries the
+	// client IP is read through; see utils.ClientIP.
+	trustedProxies int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(policy Policy, trustedProxies int) *Limiter {
+	return &Limiter{
+		policy:         policy,
+		trustedProxies: trustedProxies,
+		buckets:        make(map[string]*bucket),
+		lastSweep:      time.Now(),
+	}
+}
+
+// Middleware has to run after firebaseauth.AuthMiddleware so the UID is
+// known; without it only the IP bucket applies.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, remaining, wait := l.allow(l.keys(r), time.Now())
+
+		reset := wait
+		if allowed {
+			// time until the most drained bucket is full again
+			reset = time.Duration(float64(l.policy.Burst-remaining) / float64(l.policy.Rate) * float64(time.Second))
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(l.policy.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			metrics.RateLimited.WithLabelValues(l.policy.Name).Inc()
+			slog.WarnContext(r.Context(), "rate limit exceeded", "policy", l.policy.Name, "retry_after", wait)
+			utils.RespondWithError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) keys(r *http.Request) []string {
+	keys := make([]string, 0, 3)
+	if user, ok := firebaseauth.FbUserFromContext(r.Context()); ok {
+		keys = append(keys, "uid:"+user.UID)
+		// the device id is whatever the client sends, so its bucket is the
+		// user's own; otherwise anyone could drain another device's
+		if device := r.Header.Get(DeviceIDHeader); device != "" && len(device) <= maxDeviceIDLength {
+			keys = append(keys, "device:"+user.UID+"/"+device)
+		}
+	}
+	if ip := utils.ClientIP(r, l.trustedProxies); ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// allow takes a token from every bucket in keys, or from none of them. It
+// returns the tokens left in the most drained bucket and, when refused, how
+// long until the request would have been let through.
+func (l *Limiter) allow(keys []string, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	reservations := make([]*rate.Reservation, 0, len(keys))
+	limiters := make([]*rate.Limiter, 0, len(keys))
+	var wait time.Duration
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{limiter: rate.NewLimiter(l.policy.Rate, l.policy.Burst)}
+			l.buckets[key] = b
+		}
+		b.lastSeen = now
+
+		res := b.limiter.ReserveN(now, 1)
+		reservations = append(reservations, res)
+		limiters = append(limiters, b.limiter)
+		if d := res.DelayFrom(now); d > wait {
+			wait = d
+		}
+	}
+
+	allowed := wait == 0
+	if !allowed {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+
+	remaining := l.policy.Burst
+	for _, lim := range limiters {
+		tokens := int(math.Floor(lim.TokensAt(now)))
+		if tokens < remaining {
+			remaining = tokens
+		}
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return allowed, remaining, wait
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTTL {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
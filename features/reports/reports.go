@@ -0,0 +1,189 @@
+// Package reports renders an event's attendance report for email, a
+// plain-text summary with the check-in export attached, and sends it on
+// each event's schedules to the creators subscribed to it.
+package reports
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/cron"
+	"github.com/koiraladarwin/scanin/features/export"
+	"github.com/koiraladarwin/scanin/features/mailer"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// CheckInColumns and CheckInRow lay out the check-in export, the same for a
+// download and an emailed report.
+var CheckInColumns = []export.Column{
+	{Key: "id", Title: "Check-in ID", Width: 2.2},
+	{Key: "badge_number", Title: "Badge", Width: 0.9},
+	{Key: "full_name", Title: "Full Name", Width: 1.6},
+	{Key: "company", Title: "Company", Width: 1.3},
+	{Key: "role", Title: "Role", Width: 0.9},
+	{Key: "activity", Title: "Activity", Width: 1.3},
+	{Key: "activity_start", Title: "Activity Start", Width: 1.1},
+	{Key: "activity_end", Title: "Activity End", Width: 1.1},
+	{Key: "scanned_at", Title: "Scanned At", Width: 1.1},
+	{Key: "scanned_by", Title: "Scanned By", Width: 1.6},
+	{Key: "status", Title: "Status", Width: 0.8},
+}
+
+func CheckInRow(c *models.CheckInRespose) []any {
+	return []any{
+		c.ID.String(),
+		c.BadgeNumber,
+		c.FullName,
+		c.Company,
+		c.Role,
+		c.ActivityName,
+		c.ActivityStart,
+		c.ActivityEnd,
+		c.ScannedAt,
+		c.ScannedBy,
+		c.Status,
+	}
+}
+
+// Formats are the export formats a report can attach.
+var Formats = []string{"xlsx", "csv"}
+
+// NextRun returns when a schedule fires after t, or nil if it won't again.
+// A final report fires once at the end of the event, straight away if that
+// has already passed.
+func NextRun(s models.ReportSchedule, event models.Event, t time.Time) (*time.Time, error) {
+	if s.Kind == models.ReportFinal {
+		if s.LastRunAt != nil {
+			return nil, nil
+		}
+		end := event.EndTime
+		return &end, nil
+	}
+
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", s.TimeZone)
+	}
+	expr, err := cron.Parse(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	next := expr.Next(t.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+// Render builds the report for schedule as of now, without recipients.
+// Summaries count the check-ins since the schedule last ran.
+func Render(ctx context.Context, store db.Database, s models.ReportSchedule, event models.Event, now time.Time) (mailer.Message, error) {
+	format, ok := export.Lookup(s.Format)
+	if !ok {
+		return mailer.Message{}, fmt.Errorf("%w %q", export.ErrUnknownFormat, s.Format)
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	analytics, err := store.GetEventAnalytics(ctx, event.ID, time.Hour)
+	if err != nil {
+		return mailer.Message{}, fmt.Errorf("report analytics: %w", err)
+	}
+
+	var file bytes.Buffer
+	doc, err := format.New(&file, export.Table{
+		Title:   event.Name + " - Check-ins",
+		Columns: CheckInColumns,
+	})
+	if err != nil {
+		return mailer.Message{}, err
+	}
+	recent := 0
+	err = store.EachCheckInOfEvent(ctx, event.ID, models.CheckInFilter{}, func(c *models.CheckInRespose) error {
+		if s.LastRunAt != nil && c.Status == "checked" && c.ScannedAt.After(*s.LastRunAt) {
+			recent++
+		}
+		return doc.WriteRow(CheckInRow(c))
+	})
+	if err != nil {
+		return mailer.Message{}, fmt.Errorf("report check-ins: %w", err)
+	}
+	if err := doc.Close(); err != nil {
+		return mailer.Message{}, err
+	}
+
+	contentType := format.ContentType
+	if contentType == "" {
+		contentType = format.MediaType
+	}
+	attachment := mailer.Attachment{
+		Filename:    export.Filename(event.Name+" check-ins", now, format),
+		ContentType: contentType,
+		Data:        file.Bytes(),
+	}
+
+	title := "attendance summary"
+	if s.Kind == models.ReportFinal {
+		title = "final report"
+	}
+	var since *time.Time
+	if s.Kind == models.ReportSummary {
+		since = s.LastRunAt
+	}
+
+	return mailer.Message{
+		Subject:     event.Name + ": " + title,
+		Text:        summary(event, analytics, title, since, recent, attachment.Filename, now.In(loc)),
+		Attachments: []mailer.Attachment{attachment},
+	}, nil
+}
+
+const timeLayout = "Mon 2 Jan 2006 15:04 MST"
+
+func summary(event models.Event, an *models.EventAnalytics, title string, since *time.Time, recent int, filename string, now time.Time) string {
+	var b strings.Builder
+	loc := now.Location()
+	fmt.Fprintf(&b, "%s - %s\n", event.Name, title)
+	fmt.Fprintf(&b, "%s to %s\n", event.StartTime.In(loc).Format(timeLayout), event.EndTime.In(loc).Format(timeLayout))
+	fmt.Fprintf(&b, "As of %s\n\n", now.Format(timeLayout))
+
+	attended := 0.0
+	if an.Registered > 0 {
+		attended = float64(an.UniqueAttendees) / float64(an.Registered) * 100
+	}
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Registered\t%d\n", an.Registered)
+	fmt.Fprintf(tw, "Attended\t%d (%.0f%%)\n", an.UniqueAttendees, attended)
+	fmt.Fprintf(tw, "No-show rate\t%.0f%%\n", an.NoShowRate*100)
+	fmt.Fprintf(tw, "Check-ins\t%d\n", an.TotalCheckIns)
+	if since != nil {
+		fmt.Fprintf(tw, "Since %s\t%d\n", since.In(loc).Format(timeLayout), recent)
+	}
+	if an.PeakArrival != nil {
+		fmt.Fprintf(tw, "Peak arrival\t%s (%d arrivals)\n", an.PeakArrival.Minute.In(loc).Format(timeLayout), an.PeakArrival.Arrivals)
+	}
+	tw.Flush()
+
+	if len(an.Timeline) > 0 {
+		b.WriteString("\nCheck-ins by activity\n")
+		tw = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		for _, a := range an.Timeline {
+			total := 0
+			for _, bucket := range a.Buckets {
+				total += bucket.CheckIns
+			}
+			fmt.Fprintf(tw, "  %s\t%d\n", a.Name, total)
+		}
+		tw.Flush()
+	}
+
+	fmt.Fprintf(&b, "\nThe full check-in list is attached as %s.\n", filename)
+	return b.String()
+}
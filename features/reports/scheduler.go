@@ -0,0 +1,181 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/mailer"
+	"github.com/koiraladarwin/scanin/features/metrics"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// claimBatch is how many due schedules one transaction claims.
+const claimBatch = 20
+
+// Sender delivers one email; *mailer.Mailer is one.
+type Sender interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+// Directory resolves Firebase ids to user records; *firebaseauth.FirebaseAuth
+// is one.
+type Directory interface {
+	GetUsers(ctx context.Context, uids []string) (map[string]*auth.UserRecord, error)
+}
+
+type Scheduler struct {
+	db       db.Database
+	users    Directory
+	sender   Sender
+	interval time.Duration
+	// timeout bounds each email
+	timeout time.Duration
+}
+
+func NewScheduler(store db.Database, users Directory, sender Sender, interval, timeout time.Duration) *Scheduler {
+	return &Scheduler{db: store, users: users, sender: sender, interval: interval, timeout: timeout}
+}
+
+// Run sends due reports every interval until ctx is done. Several instances
+// can run against one database; each schedule is claimed by only one.
+func (s *Scheduler) Run(ctx context.Context) {
+	slog.Info("report scheduler running", "interval", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.RunDue(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to run due reports", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue sends every report due now. A schedule is moved on to its next
+// run before its emails go out, so a crash part way loses that report
+// rather than sending it twice; the failure shows in the deliveries.
+func (s *Scheduler) RunDue(ctx context.Context) error {
+	for {
+		now := time.Now()
+		var due []models.DueReport
+		err := s.db.WithTx(ctx, func(tx db.Database) error {
+			var err error
+			due, err = tx.ClaimDueReports(ctx, now, claimBatch)
+			if err != nil {
+				return err
+			}
+			for _, d := range due {
+				ran := d.Schedule
+				ran.LastRunAt = &now
+				next, err := NextRun(ran, d.Event, now)
+				if err != nil {
+					// the schedule was valid when saved; stop it rather
+					// than fail every poll
+					slog.ErrorContext(ctx, "report schedule can't run again", "schedule_id", d.Schedule.ID, "err", err)
+				}
+				if err := tx.MarkReportRun(ctx, d.Schedule.ID, now, next); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, d := range due {
+			if _, err := s.Send(ctx, d.Schedule, d.Event); err != nil {
+				slog.ErrorContext(ctx, "failed to send report", "schedule_id", d.Schedule.ID, "event_id", d.Event.ID, "err", err)
+			}
+		}
+		if len(due) < claimBatch {
+			return nil
+		}
+	}
+}
+
+// Send emails the report of schedule to each subscriber separately and
+// records how every delivery went. An error means no email was attempted;
+// it is still recorded as a failed delivery to every subscriber, or once
+// with no recipient when the subscribers couldn't be looked up.
+func (s *Scheduler) Send(ctx context.Context, schedule models.ReportSchedule, event models.Event) ([]models.ReportDelivery, error) {
+	deliveries := []models.ReportDelivery{}
+	uids, err := s.db.GetReportSubscribers(ctx, event.ID)
+	if err != nil {
+		s.recordFailed(ctx, schedule, event, []string{""}, err)
+		return nil, err
+	}
+	if len(uids) == 0 {
+		return deliveries, nil
+	}
+	users, err := s.users.GetUsers(ctx, uids)
+	if err != nil {
+		s.recordFailed(ctx, schedule, event, uids, err)
+		return nil, err
+	}
+
+	msg, err := Render(ctx, s.db, schedule, event, time.Now())
+	if err != nil {
+		s.recordFailed(ctx, schedule, event, uids, err)
+		return nil, err
+	}
+
+	for _, uid := range uids {
+		d := newDelivery(schedule, event, uid)
+		if user, ok := users[uid]; !ok || user.Email == "" {
+			err = errors.New("subscriber has no email address")
+		} else {
+			d.Recipient = user.Email
+			err = s.send(ctx, user.Email, msg)
+		}
+		if err != nil {
+			d.Status = models.DeliveryFailed
+			d.Error = err.Error()
+		}
+		s.record(ctx, &d)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// recordFailed records a failed delivery to each recipient for an error
+// that stopped the report before any email was sent.
+func (s *Scheduler) recordFailed(ctx context.Context, schedule models.ReportSchedule, event models.Event, recipients []string, err error) {
+	for _, to := range recipients {
+		d := newDelivery(schedule, event, to)
+		d.Status = models.DeliveryFailed
+		d.Error = err.Error()
+		s.record(ctx, &d)
+	}
+}
+
+func (s *Scheduler) record(ctx context.Context, d *models.ReportDelivery) {
+	metrics.ReportEmails.WithLabelValues(d.Status).Inc()
+	if err := s.db.RecordReportDelivery(ctx, d); err != nil {
+		slog.ErrorContext(ctx, "failed to record report delivery", "schedule_id", d.ScheduleID, "err", err)
+	}
+}
+
+func newDelivery(schedule models.ReportSchedule, event models.Event, recipient string) models.ReportDelivery {
+	return models.ReportDelivery{
+		EventID:    event.ID,
+		ScheduleID: &schedule.ID,
+		Kind:       schedule.Kind,
+		Recipient:  recipient,
+		Status:     models.DeliverySent,
+	}
+}
+
+func (s *Scheduler) send(ctx context.Context, to string, msg mailer.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	msg.To = []string{to}
+	return s.sender.Send(ctx, msg)
+}
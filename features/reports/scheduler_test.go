@@ -0,0 +1,226 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/mailer"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// fakeStore implements the part of db.Database Send and Render use.
+type fakeStore struct {
+	db.Database
+
+	subscribers    []string
+	subscribersErr error
+	checkIns       []models.CheckInRespose
+
+	mu         sync.Mutex
+	deliveries []models.ReportDelivery
+}
+
+func (f *fakeStore) GetReportSubscribers(ctx context.Context, eventID uuid.UUID) ([]string, error) {
+	return f.subscribers, f.subscribersErr
+}
+
+func (f *fakeStore) GetEventAnalytics(ctx context.Context, eventID uuid.UUID, interval time.Duration) (*models.EventAnalytics, error) {
+	return &models.EventAnalytics{EventID: eventID, Registered: 4, UniqueAttendees: len(f.checkIns), TotalCheckIns: len(f.checkIns)}, nil
+}
+
+func (f *fakeStore) EachCheckInOfEvent(ctx context.Context, eventID uuid.UUID, filter models.CheckInFilter, fn func(*models.CheckInRespose) error) error {
+	for i := range f.checkIns {
+		if err := fn(&f.checkIns[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) RecordReportDelivery(ctx context.Context, d *models.ReportDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d.ID = int64(len(f.deliveries) + 1)
+	f.deliveries = append(f.deliveries, *d)
+	return nil
+}
+
+type fakeDirectory struct {
+	users map[string]*auth.UserRecord
+	err   error
+}
+
+func (f fakeDirectory) GetUsers(ctx context.Context, uids []string) (map[string]*auth.UserRecord, error) {
+	return f.users, f.err
+}
+
+func user(uid, email string) *auth.UserRecord {
+	return &auth.UserRecord{UserInfo: &auth.UserInfo{UID: uid, Email: email}}
+}
+
+// fakeSender fails for the addresses in fail and keeps the rest.
+type fakeSender struct {
+	fail map[string]bool
+
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (f *fakeSender) Send(ctx context.Context, msg mailer.Message) error {
+	if f.fail[msg.To[0]] {
+		return errors.New("mailbox unavailable")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func testSchedule() (models.ReportSchedule, models.Event) {
+	event := models.Event{
+		ID:        uuid.New(),
+		Name:      "Dev Summit",
+		StartTime: time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2026, 10, 1, 18, 0, 0, 0, time.UTC),
+	}
+	schedule := models.ReportSchedule{
+		ID:       uuid.New(),
+		EventID:  event.ID,
+		Kind:     models.ReportSummary,
+		Cron:     "0 * * * *",
+		TimeZone: "UTC",
+		Format:   "csv",
+		Enabled:  true,
+	}
+	return schedule, event
+}
+
+func TestSend(t *testing.T) {
+	store := &fakeStore{
+		subscribers: []string{"ada", "grace", "linus"},
+		checkIns: []models.CheckInRespose{
+			{ID: uuid.New(), FullName: "Alan Turing", ActivityName: "Keynote", Status: "checked"},
+		},
+	}
+	users := fakeDirectory{users: map[string]*auth.UserRecord{
+		"ada":   user("ada", "ada@example.com"),
+		"grace": user("grace", "grace@example.com"),
+		"linus": user("linus", ""),
+	}}
+	sender := &fakeSender{fail: map[string]bool{"grace@example.com": true}}
+	s := NewScheduler(store, users, sender, time.Minute, time.Second)
+	schedule, event := testSchedule()
+
+	deliveries, err := s.Send(context.Background(), schedule, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ recipient, status, err string }{
+		{"ada@example.com", models.DeliverySent, ""},
+		{"grace@example.com", models.DeliveryFailed, "mailbox unavailable"},
+		{"linus", models.DeliveryFailed, "subscriber has no email address"},
+	}
+	if len(deliveries) != len(want) || len(store.deliveries) != len(want) {
+		t.Fatalf("got %d deliveries, %d recorded, want %d", len(deliveries), len(store.deliveries), len(want))
+	}
+	for i, w := range want {
+		d := store.deliveries[i]
+		if d.Recipient != w.recipient || d.Status != w.status || d.Error != w.err {
+			t.Errorf("delivery %d = %s %s %q, want %s %s %q", i, d.Recipient, d.Status, d.Error, w.recipient, w.status, w.err)
+		}
+		if d.EventID != event.ID || d.ScheduleID == nil || *d.ScheduleID != schedule.ID || d.Kind != models.ReportSummary {
+			t.Errorf("delivery %d is not tied to the schedule: %+v", i, d)
+		}
+		if deliveries[i].ID != d.ID {
+			t.Errorf("returned delivery %d has id %d, recorded %d", i, deliveries[i].ID, d.ID)
+		}
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sender.sent))
+	}
+	msg := sender.sent[0]
+	if len(msg.To) != 1 || msg.To[0] != "ada@example.com" {
+		t.Errorf("To = %v, want only ada", msg.To)
+	}
+	if msg.Subject != "Dev Summit: attendance summary" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if len(msg.Attachments) != 1 || !strings.HasSuffix(msg.Attachments[0].Filename, ".csv") ||
+		!strings.Contains(string(msg.Attachments[0].Data), "Alan Turing") {
+		t.Errorf("attachments = %+v, want the check-in csv", msg.Attachments)
+	}
+}
+
+func TestSendWithoutSubscribers(t *testing.T) {
+	store := &fakeStore{}
+	s := NewScheduler(store, fakeDirectory{}, &fakeSender{}, time.Minute, time.Second)
+	schedule, event := testSchedule()
+
+	deliveries, err := s.Send(context.Background(), schedule, event)
+	if err != nil || len(deliveries) != 0 || len(store.deliveries) != 0 {
+		t.Errorf("Send = %v, %v with %d recorded, want nothing", deliveries, err, len(store.deliveries))
+	}
+}
+
+func TestSendRecordsEarlyFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		store      *fakeStore
+		users      fakeDirectory
+		format     string
+		recipients []string
+	}{
+		{
+			name:       "subscriber lookup",
+			store:      &fakeStore{subscribersErr: errors.New("connection reset")},
+			format:     "csv",
+			recipients: []string{""},
+		},
+		{
+			name:       "user lookup",
+			store:      &fakeStore{subscribers: []string{"ada", "grace"}},
+			users:      fakeDirectory{err: errors.New("firebase unavailable")},
+			format:     "csv",
+			recipients: []string{"ada", "grace"},
+		},
+		{
+			name:       "render",
+			store:      &fakeStore{subscribers: []string{"ada"}},
+			users:      fakeDirectory{users: map[string]*auth.UserRecord{"ada": user("ada", "ada@example.com")}},
+			format:     "docx",
+			recipients: []string{"ada"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{}
+			s := NewScheduler(tt.store, tt.users, sender, time.Minute, time.Second)
+			schedule, event := testSchedule()
+			schedule.Format = tt.format
+
+			if _, err := s.Send(context.Background(), schedule, event); err == nil {
+				t.Fatal("Send succeeded")
+			}
+			if len(sender.sent) != 0 {
+				t.Errorf("sent %d emails", len(sender.sent))
+			}
+			if len(tt.store.deliveries) != len(tt.recipients) {
+				t.Fatalf("recorded %d deliveries, want %d", len(tt.store.deliveries), len(tt.recipients))
+			}
+			for i, d := range tt.store.deliveries {
+				if d.Recipient != tt.recipients[i] || d.Status != models.DeliveryFailed || d.Error == "" {
+					t.Errorf("delivery %d = %+v, want a failure to %q", i, d, tt.recipients[i])
+				}
+			}
+		})
+	}
+}
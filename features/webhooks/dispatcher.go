@@ -0,0 +1,150 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/metrics"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// claimBatch is how many deliveries one poll claims at a time.
+const claimBatch = 50
+
+type Options struct {
+	// PollInterval is how often the outbox is checked for due deliveries.
+	PollInterval time.Duration
+	// Timeout bounds each request to an endpoint.
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed.
+	MaxAttempts int
+}
+
+type Dispatcher struct {
+	db     db.Database
+	client *http.Client
+	opts   Options
+}
+
+func NewDispatcher(store db.Database, opts Options) *Dispatcher {
+	return &Dispatcher{
+		db:     store,
+		client: newClient(opts.Timeout),
+		opts:   opts,
+	}
+}
+
+// lease is how long n claimed deliveries are left alone by other
+// dispatchers. They are sent one after another, so it has to cover every
+// one of them timing out, with a request's worth to spare for recording.
+func (d *Dispatcher) lease(now time.Time, n int) time.Time {
+	return now.Add(time.Duration(n+1)*d.opts.Timeout + d.opts.PollInterval)
+}
+
+// Run sends due deliveries every poll interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	slog.Info("webhook dispatcher running", "interval", d.opts.PollInterval)
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.RunDue(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to dispatch webhooks", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue sends every delivery due now, in batches.
+func (d *Dispatcher) RunDue(ctx context.Context) error {
+	for {
+		now := time.Now()
+		targets, err := d.db.ClaimWebhookDeliveries(ctx, now, d.lease(now, claimBatch), claimBatch)
+		if err != nil {
+			return err
+		}
+		for _, t := range targets {
+			d.attempt(ctx, t)
+		}
+		if len(targets) < claimBatch {
+			return nil
+		}
+	}
+}
+
+// Redeliver starts a delivery over and tries it once right away; later
+// retries, if needed, follow the usual backoff.
+func (d *Dispatcher) Redeliver(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	t, err := d.db.RequeueWebhookDelivery(ctx, id, d.lease(time.Now(), 1))
+	if err != nil {
+		return nil, err
+	}
+	d.attempt(ctx, *t)
+	return d.db.GetWebhookDelivery(ctx, id)
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, t models.WebhookTarget) {
+	attempts := t.Delivery.Attempts + 1
+	code, err := d.post(ctx, t)
+
+	result := models.WebhookAttempt{Status: models.WebhookDelivered, StatusCode: code}
+	if err != nil {
+		result.Error = err.Error()
+		if attempts >= d.opts.MaxAttempts {
+			result.Status = models.WebhookFailed
+		} else {
+			result.Status = models.WebhookPending
+			next := time.Now().Add(Backoff(attempts))
+			result.NextAttemptAt = &next
+		}
+		slog.WarnContext(ctx, "webhook delivery failed", "delivery_id", t.Delivery.ID, "webhook_id", t.Delivery.WebhookID,
+			"attempt", attempts, "status", code, "err", err)
+	}
+	metrics.WebhookDeliveries.WithLabelValues(result.Status).Inc()
+
+	// recorded even if ctx is done, or the delivery would be sent again
+	// once the lease runs out
+	if err := d.db.RecordWebhookAttempt(context.WithoutCancel(ctx), t.Delivery.ID, result); err != nil {
+		slog.ErrorContext(ctx, "failed to record webhook attempt", "delivery_id", t.Delivery.ID, "err", err)
+	}
+}
+
+// post sends the delivery once; any response outside 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, t models.WebhookTarget) (int, error) {
+	body := []byte(t.Delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "scanin-webhooks/1")
+	req.Header.Set(HeaderEvent, t.Delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(t.Delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(t.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
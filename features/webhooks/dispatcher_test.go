@@ -0,0 +1,272 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/models"
+)
+
+// fakeOutbox keeps deliveries in memory, implementing the part of
+// db.Database the dispatcher uses.
+type fakeOutbox struct {
+	db.Database
+
+	mu       sync.Mutex
+	targets  map[int64]*models.WebhookTarget
+	attempts []models.WebhookAttempt
+}
+
+func newFakeOutbox(targets ...models.WebhookTarget) *fakeOutbox {
+	f := &fakeOutbox{targets: map[int64]*models.WebhookTarget{}}
+	for _, t := range targets {
+		f.targets[t.Delivery.ID] = &t
+	}
+	return f
+}
+
+func (f *fakeOutbox) ClaimWebhookDeliveries(ctx context.Context, now, lease time.Time, limit int) ([]models.WebhookTarget, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var due []models.WebhookTarget
+	for _, t := range f.targets {
+		if len(due) == limit {
+			break
+		}
+		d := &t.Delivery
+		if d.Status == models.WebhookPending && (d.NextAttemptAt == nil || !d.NextAttemptAt.After(now)) {
+			d.NextAttemptAt = &lease
+			due = append(due, *t)
+		}
+	}
+	return due, nil
+}
+
+func (f *fakeOutbox) RequeueWebhookDelivery(ctx context.Context, id int64, lease time.Time) (*models.WebhookTarget, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.targets[id]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	t.Delivery.Status = models.WebhookPending
+	t.Delivery.Attempts = 0
+	t.Delivery.NextAttemptAt = &lease
+	t.Delivery.DeliveredAt = nil
+	copied := *t
+	return &copied, nil
+}
+
+func (f *fakeOutbox) RecordWebhookAttempt(ctx context.Context, id int64, attempt models.WebhookAttempt) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts = append(f.attempts, attempt)
+	d := &f.targets[id].Delivery
+	d.Status = attempt.Status
+	d.Attempts++
+	d.NextAttemptAt = attempt.NextAttemptAt
+	d.LastStatusCode = attempt.StatusCode
+	d.LastError = attempt.Error
+	return nil
+}
+
+func (f *fakeOutbox) GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.targets[id]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	d := t.Delivery
+	return &d, nil
+}
+
+func (f *fakeOutbox) delivery(id int64) models.WebhookDelivery {
+	d, _ := f.GetWebhookDelivery(context.Background(), id)
+	return *d
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver answers every request with status and records what it got.
+func newReceiver(t *testing.T, status int) (*httptest.Server, <-chan received) {
+	t.Helper()
+	got := make(chan received, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+// newTestDispatcher sends through srv's client: httptest listens on
+// loopback, which the production client refuses.
+func newTestDispatcher(store db.Database, srv *httptest.Server, maxAttempts int) *Dispatcher {
+	d := NewDispatcher(store, Options{PollInterval: time.Second, Timeout: 5 * time.Second, MaxAttempts: maxAttempts})
+	d.client = srv.Client()
+	return d
+}
+
+func target(id int64, url string, attempts int) models.WebhookTarget {
+	return models.WebhookTarget{
+		Delivery: models.WebhookDelivery{
+			ID:        id,
+			EventType: CheckInCreated,
+			Payload:   []byte(`{"type":"checkin.created"}`),
+			Status:    models.WebhookPending,
+			Attempts:  attempts,
+		},
+		URL:    url,
+		Secret: "whsec_test",
+	}
+}
+
+func TestAttemptDelivered(t *testing.T) {
+	srv, got := newReceiver(t, http.StatusNoContent)
+	store := newFakeOutbox(target(1, srv.URL, 0))
+	d := newTestDispatcher(store, srv, 3)
+
+	d.attempt(context.Background(), target(1, srv.URL, 0))
+
+	req := <-got
+	if req.header.Get(HeaderEvent) != CheckInCreated || req.header.Get(HeaderDelivery) != "1" {
+		t.Errorf("headers = %v", req.header)
+	}
+	err := Verify("whsec_test", req.header.Get(HeaderTimestamp), req.header.Get(HeaderSignature), req.body, time.Minute, time.Now())
+	if err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+
+	del := store.delivery(1)
+	if del.Status != models.WebhookDelivered || del.Attempts != 1 || del.LastStatusCode != http.StatusNoContent {
+		t.Errorf("delivery = %+v, want delivered after 1 attempt with 204", del)
+	}
+	if del.NextAttemptAt != nil {
+		t.Errorf("delivered delivery has next_attempt_at %v", del.NextAttemptAt)
+	}
+}
+
+func TestAttemptRetries(t *testing.T) {
+	srv, _ := newReceiver(t, http.StatusInternalServerError)
+	store := newFakeOutbox(target(1, srv.URL, 1))
+	d := newTestDispatcher(store, srv, 3)
+
+	before := time.Now()
+	d.attempt(context.Background(), target(1, srv.URL, 1))
+
+	del := store.delivery(1)
+	if del.Status != models.WebhookPending || del.Attempts != 2 || del.LastStatusCode != http.StatusInternalServerError {
+		t.Errorf("delivery = %+v, want pending after 2 attempts with 500", del)
+	}
+	if del.LastError == "" {
+		t.Error("failed attempt recorded no error")
+	}
+	if del.NextAttemptAt == nil || del.NextAttemptAt.Before(before.Add(Backoff(2))) {
+		t.Errorf("next_attempt_at = %v, want at least %s from now", del.NextAttemptAt, Backoff(2))
+	}
+}
+
+func TestAttemptGivesUp(t *testing.T) {
+	srv, _ := newReceiver(t, http.StatusBadRequest)
+	store := newFakeOutbox(target(1, srv.URL, 2))
+	d := newTestDispatcher(store, srv, 3)
+
+	d.attempt(context.Background(), target(1, srv.URL, 2))
+
+	del := store.delivery(1)
+	if del.Status != models.WebhookFailed || del.Attempts != 3 {
+		t.Errorf("delivery = %+v, want failed after 3 attempts", del)
+	}
+	if del.NextAttemptAt != nil {
+		t.Errorf("failed delivery has next_attempt_at %v", del.NextAttemptAt)
+	}
+}
+
+func TestAttemptDoesNotFollowRedirects(t *testing.T) {
+	srv, _ := newReceiver(t, http.StatusFound)
+	store := newFakeOutbox(target(1, srv.URL, 0))
+	d := newTestDispatcher(store, srv, 3)
+	d.client.CheckRedirect = NewDispatcher(store, Options{}).client.CheckRedirect
+
+	d.attempt(context.Background(), target(1, srv.URL, 0))
+
+	if del := store.delivery(1); del.Status != models.WebhookPending || del.LastStatusCode != http.StatusFound {
+		t.Errorf("delivery = %+v, want a retry after the 302", del)
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	srv, got := newReceiver(t, http.StatusOK)
+	later := time.Now().Add(time.Hour)
+	notDue := target(3, srv.URL, 1)
+	notDue.Delivery.NextAttemptAt = &later
+	store := newFakeOutbox(target(1, srv.URL, 0), target(2, srv.URL, 0), notDue)
+	d := newTestDispatcher(store, srv, 3)
+
+	if err := d.RunDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(got); n != 2 {
+		t.Errorf("sent %d deliveries, want 2", n)
+	}
+	for _, id := range []int64{1, 2} {
+		if del := store.delivery(id); del.Status != models.WebhookDelivered {
+			t.Errorf("delivery %d = %s, want delivered", id, del.Status)
+		}
+	}
+	if del := store.delivery(3); del.Attempts != 1 || del.Status != models.WebhookPending {
+		t.Errorf("delivery 3 was sent before it was due: %+v", del)
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	srv, got := newReceiver(t, http.StatusOK)
+	failed := target(7, srv.URL, 3)
+	failed.Delivery.Status = models.WebhookFailed
+	store := newFakeOutbox(failed)
+	d := newTestDispatcher(store, srv, 3)
+
+	del, err := d.Redeliver(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if del.Status != models.WebhookDelivered || del.Attempts != 1 {
+		t.Errorf("delivery = %+v, want delivered on the first attempt of the new chain", del)
+	}
+	if req := <-got; req.header.Get(HeaderDelivery) != strconv.Itoa(7) {
+		t.Errorf("%s = %q, want 7", HeaderDelivery, req.header.Get(HeaderDelivery))
+	}
+
+	if _, err := d.Redeliver(context.Background(), 8); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Redeliver of an unknown delivery = %v, want db.ErrNotFound", err)
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	srv, got := newReceiver(t, http.StatusOK)
+	store := newFakeOutbox(target(1, srv.URL, 0))
+	d := NewDispatcher(store, Options{PollInterval: time.Second, Timeout: 5 * time.Second, MaxAttempts: 3})
+
+	d.attempt(context.Background(), target(1, srv.URL, 0))
+
+	if len(got) != 0 {
+		t.Fatal("the request reached a loopback endpoint")
+	}
+	if del := store.delivery(1); del.Status != models.WebhookPending || del.LastStatusCode != 0 {
+		t.Errorf("delivery = %+v, want a retry without a response", del)
+	}
+}
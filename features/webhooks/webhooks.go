@@ -0,0 +1,140 @@
+// Package webhooks posts an event's changes to the endpoints its creators
+// register. Changes are queued in an outbox table in the same transaction
+// that makes them, and a Dispatcher sends them afterwards, retrying failures
+// with exponential backoff.
+//
+// Every delivery is a JSON Envelope POSTed with these headers:
+//
+//	X-Scanin-Event      the event type, e.g. checkin.created
+//	X-Scanin-Delivery   the delivery id, unique per endpoint and attempt chain
+//	X-Scanin-Timestamp  unix seconds when the request was signed
+//	X-Scanin-Signature  sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret>
+//
+// Receivers should check the signature with Verify and drop requests with
+// an old timestamp. The envelope id stays the same across retries, so it
+// can be used to ignore repeats.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types.
+const (
+	CheckInCreated  = "checkin.created"
+	CheckInReverted = "checkin.reverted"
+	AttendeeCreated = "attendee.created"
+	AttendeeUpdated = "attendee.updated"
+	ActivityUpdated = "activity.updated"
+)
+
+// Types lists every event type an endpoint can subscribe to.
+var Types = []string{CheckInCreated, CheckInReverted, AttendeeCreated, AttendeeUpdated, ActivityUpdated}
+
+const (
+	HeaderEvent     = "X-Scanin-Event"
+	HeaderDelivery  = "X-Scanin-Delivery"
+	HeaderTimestamp = "X-Scanin-Timestamp"
+	HeaderSignature = "X-Scanin-Signature"
+
+	signaturePrefix = "sha256="
+	secretPrefix    = "whsec_"
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature does not match")
+	ErrStaleTimestamp   = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// ValidateTypes rejects an empty list and unknown event types.
+func ValidateTypes(types []string) error {
+	if len(types) == 0 {
+		return fmt.Errorf("event_types must name at least one of %v", Types)
+	}
+	for _, t := range types {
+		known := false
+		for _, k := range Types {
+			known = known || t == k
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %q, expected one of %v", t, Types)
+		}
+	}
+	return nil
+}
+
+// Envelope wraps the changed entity, the same shape the API returns for it.
+type Envelope struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	EventID   string    `json:"event_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Payload renders the envelope of a change to eventId.
+func Payload(eventId, eventType string, data any, at time.Time) ([]byte, error) {
+	return json.Marshal(Envelope{
+		ID:        uuid.New(),
+		Type:      eventType,
+		EventID:   eventId,
+		CreatedAt: at.UTC(),
+		Data:      data,
+	})
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Sign returns the X-Scanin-Signature value for body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that its timestamp header is
+// within tolerance of now.
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrStaleTimestamp
+	}
+	if !hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Backoff is how long to wait after the given failed attempt (1 for the
+// first): a minute, doubling each time, at most six hours.
+func Backoff(attempt int) time.Duration {
+	const base, ceiling = time.Minute, 6 * time.Hour
+	d := time.Duration(float64(base) * math.Pow(2, float64(attempt-1)))
+	if d > ceiling || d <= 0 {
+		return ceiling
+	}
+	return d
+}
@@ -0,0 +1,96 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"type":"checkin.created"}`)
+	now := time.Unix(1_700_000_000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := Sign(secret, now.Unix(), body)
+
+	if err := Verify(secret, ts, sig, body, 5*time.Minute, now); err != nil {
+		t.Fatalf("Verify of a fresh signature: %v", err)
+	}
+	if err := Verify(secret, ts, sig, body, 5*time.Minute, now.Add(4*time.Minute)); err != nil {
+		t.Fatalf("Verify within tolerance: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		now       time.Time
+		want      error
+	}{
+		{"wrong secret", "whsec_other", ts, body, now, ErrInvalidSignature},
+		{"changed body", secret, ts, []byte(`{}`), now, ErrInvalidSignature},
+		{"changed timestamp", secret, strconv.FormatInt(now.Unix()+1, 10), body, now, ErrInvalidSignature},
+		{"stale", secret, ts, body, now.Add(6 * time.Minute), ErrStaleTimestamp},
+		{"from the future", secret, ts, body, now.Add(-6 * time.Minute), ErrStaleTimestamp},
+		{"not a number", secret, "yesterday", body, now, ErrStaleTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.timestamp, sig, tt.body, 5*time.Minute, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{100, 6 * time.Hour},
+		{5000, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hook", false},
+		{"http://93.184.216.34:8080/hook", false},
+		{"ftp://93.184.216.34/hook", true},
+		{"/relative", true},
+		{"http://127.0.0.1/hook", true},
+		{"http://[::1]/hook", true},
+		{"http://10.0.0.5/hook", true},
+		{"http://172.16.3.4/hook", true},
+		{"http://192.168.1.1/hook", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://0.0.0.0/hook", true},
+		{"http://[fe80::1]/hook", true},
+		{"http://[::ffff:127.0.0.1]/hook", true},
+		{"http://localhost/hook", true},
+	}
+	for _, tt := range tests {
+		err := ValidateURL(context.Background(), tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateURL(%q) = %v, want error %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
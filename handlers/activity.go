@@ -4,10 +4,15 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"net/http"
-	"slices"
 
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/credits"
 	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/features/webhooks"
 	"github.com/koiraladarwin/scanin/models"
 	"github.com/koiraladarwin/scanin/utils"
 )
@@ -21,33 +26,59 @@ CreateActivity accepts JSON:
 	  "type": "string",
 	  "start_time": "2025-07-08T15:30:00Z",
 	  "end_time": "2025-07-09T15:30:00Z",
-	  "location": "string"
+	  "location": "string",
+	  "credits": 1.5,
+	  "credit_rule": "check_in" | "dwell",
+	  "min_dwell_percent": 80
 	}
 
+credits are what attending earns toward continuing education; credit_rule
+defaults to check_in, while dwell also needs a check-out after at least
+min_dwell_percent of the activity.
+
 Returns:
 - 201 Created with created check-in JSON on success
 - 400 Bad Request for invalid input
+- 403 Forbidden without the activity.write permission (super admins always pass)
 - 500 Internal Server Error on DB failure
 */
 func (h *Handler) CreateActivity(w http.ResponseWriter, r *http.Request) {
-	//todo
-	//change this route so only super admin and event creator can create activity
 	firebaseId, ok := firebaseauth.FbUserFromContext(r.Context())
 	if !ok {
 		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
-	if !slices.Contains(superAdminsEmails, firebaseId.Email) {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
 
 	var c models.ActivityCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
-	if err := h.DB.CreateActivity(&c); err != nil {
+	logging.AddAttrs(r.Context(), slog.String("event_id", c.EventID.String()))
+	if err := credits.Normalize(&c.Credits, &c.CreditRule, &c.MinDwellPercent); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.Config.IsSuperAdmin(firebaseId.Email) &&
+		!h.requirePermission(w, r, firebaseId.UID, c.EventID.String(), permissions.ActivityWrite, "You are not authorized to create activities") {
+		return
+	}
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		id, err := tx.CreateActivity(r.Context(), &c)
+		if err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    c.EventID.String(),
+			Action:     models.AuditActivityCreate,
+			EntityType: "activity",
+			EntityId:   id.String(),
+			After:      c,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create activity", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create Event")
 		return
 	}
@@ -64,9 +95,15 @@ UpdateActivity accepts JSON:
 	  "type": "string",
 	  "start_time": "2025-07-08T15:30:00Z",
 	  "end_time": "2025-07-09T15:30:00Z",
-	  "location": "string"
+	  "location": "string",
+	  "credits": 1.5,
+	  "credit_rule": "check_in" | "dwell",
+	  "min_dwell_percent": 80
 	}
 
+credits, credit_rule and min_dwell_percent are optional; left out, the
+activity keeps its current credit settings.
+
 Path Param:
 
 	activity_id (uuid-string)
@@ -74,20 +111,62 @@ Path Param:
 Returns:
 - 200 OK with updated activity JSON on success
 - 400 Bad Request for invalid input
+- 403 Forbidden without the activity.write permission
 - 404 Not Found if activity doesn’t exist
 - 500 Internal Server Error on DB failure
 */
 func (h *Handler) UpdateActivity(w http.ResponseWriter, r *http.Request) {
-	var activity models.Activity
-	err := json.NewDecoder(r.Body).Decode(&activity)
+	firebaseId, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.ActivityUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
 
-	err = h.DB.UpdateActivity(&activity)
+	if !h.Config.IsSuperAdmin(firebaseId.Email) &&
+		!h.requireActivityPermission(w, r, firebaseId.UID, req.ID, permissions.ActivityWrite, "You are not authorized to change activities") {
+		return
+	}
+
+	var activity models.Activity
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetActivity(r.Context(), req.ID)
+		if err != nil {
+			return err
+		}
+		// the permission check was on the activity's own event, which stays
+		activity = *before
+		if err := applyActivityRequest(&activity, req); err != nil {
+			return err
+		}
+		if err := tx.UpdateActivity(r.Context(), &activity); err != nil {
+			return err
+		}
+		if err := h.notify(r, tx, before.EventID.String(), webhooks.ActivityUpdated, activity); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    before.EventID.String(),
+			Action:     models.AuditActivityUpdate,
+			EntityType: "activity",
+			EntityId:   activity.ID.String(),
+			Before:     before,
+			After:      activity,
+		})
+	})
 
 	if err != nil {
+		var invalid activityRequestError
+		if errors.As(err, &invalid) {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if errors.Is(err, sql.ErrNoRows) {
 			utils.RespondWithError(w, http.StatusNotFound, "Activity not found")
 			return
@@ -99,3 +178,30 @@ func (h *Handler) UpdateActivity(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(activity)
 }
+
+// activityRequestError is invalid activity input, reported as a 400.
+type activityRequestError struct{ msg string }
+
+func (e activityRequestError) Error() string { return e.msg }
+
+// applyActivityRequest copies req onto a, keeping a's credit settings where
+// req leaves them out, and validates the result.
+func applyActivityRequest(a *models.Activity, req models.ActivityUpdateRequest) error {
+	a.Name = req.Name
+	a.Type = req.Type
+	a.StartTime = req.StartTime
+	a.EndTime = req.EndTime
+	if req.Credits != nil {
+		a.Credits = *req.Credits
+	}
+	if req.CreditRule != nil {
+		a.CreditRule = *req.CreditRule
+	}
+	if req.MinDwellPercent != nil {
+		a.MinDwellPercent = *req.MinDwellPercent
+	}
+	if err := credits.Normalize(&a.Credits, &a.CreditRule, &a.MinDwellPercent); err != nil {
+		return activityRequestError{err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+const (
+	defaultAnalyticsInterval = 15 * time.Minute
+	minAnalyticsInterval     = time.Minute
+	maxAnalyticsInterval     = 24 * time.Hour
+)
+
+/*
+GetEventAnalytics returns an event's check-in analytics, all computed by the
+database: check-ins per activity over time, unique attendees against
+registered ones and the no-show rate, the peak arrival minute, scans per
+staff member, attendance by role and by company, and how many attendees came
+to one, two or more activities.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	interval  bucket size of the timeline as a duration, 1m to 24h; 15m by default
+
+Returns:
+- 200 OK with the analytics JSON, see models.EventAnalytics
+- 400 Bad Request for an invalid id or interval
+- 403 Forbidden without the checkin.read permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetEventAnalytics(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	interval := defaultAnalyticsInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		interval, err = time.ParseDuration(v)
+		if err != nil || interval < minAnalyticsInterval || interval > maxAnalyticsInterval || interval%time.Second != 0 {
+			utils.RespondWithError(w, http.StatusBadRequest, "interval must be a whole number of seconds between 1m and 24h, e.g. 15m")
+			return
+		}
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.CheckInRead, "You are not authorized to see check-ins") {
+		return
+	}
+
+	exists, err := h.DB.EventExists(r.Context(), eventId)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if !exists {
+		utils.RespondWithError(w, http.StatusNotFound, "event not found")
+		return
+	}
+
+	analytics, err := h.DB.GetEventAnalytics(r.Context(), eventId, interval)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to compute analytics", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to compute analytics")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analytics)
+}
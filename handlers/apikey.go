@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/apikeys"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+const maxAPIKeyName = 100
+
+/*
+GetAPIKeys lists an event's API keys, revoked and expired ones included,
+newest first. The keys themselves are never shown again after creation.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 200 OK with a JSON array of models.APIKey
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage API keys") {
+		return
+	}
+
+	keys, err := h.DB.GetAPIKeys(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get API keys", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get API keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+/*
+CreateAPIKey accepts JSON:
+
+	{
+	  "name": "CRM sync",
+	  "scopes": ["attendee.read", "attendee.write"],
+	  "expires_at": "2026-12-31T00:00:00Z"
+	}
+
+scopes are permissions, as handed to staff, that the key holds on this event
+only; expires_at is optional. Integrations send the key as
+"Authorization: Bearer sk_..." in place of a Firebase ID token.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 201 Created with the key JSON, token included; it can't be retrieved again
+- 400 Bad Request for invalid input or an unknown scope
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	var req models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyName {
+		utils.RespondWithError(w, http.StatusBadRequest, "name is required and at most 100 characters")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "scopes must name at least one permission")
+		return
+	}
+	if err := permissions.Validate(req.Scopes); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.RespondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage API keys") {
+		return
+	}
+
+	token, shown, hash, err := apikeys.New()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate API key", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	key := &models.APIKey{
+		EventID:   eventId,
+		Name:      req.Name,
+		Prefix:    shown,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: fireBaseUser.UID,
+	}
+
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.CreateAPIKey(r.Context(), key, hash); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditAPIKeyCreate,
+			EntityType: "api_key",
+			EntityId:   key.ID.String(),
+			After:      key,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create API key", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	key.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+/*
+RevokeAPIKey stops a key from working right away. It stays in the list,
+with revoked_at set.
+
+Path Params:
+
+	id     (uuid-string of the event)
+	key_id (uuid-string)
+
+Returns:
+- 204 No Content on success, also if it was already revoked
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the key does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+	keyId, err := uuid.Parse(vars["key_id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid key id format")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage API keys") {
+		return
+	}
+
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetAPIKey(r.Context(), keyId)
+		if err != nil {
+			return err
+		}
+		if before.EventID != eventId {
+			return db.ErrNotFound
+		}
+		if before.RevokedAt != nil {
+			return nil
+		}
+		if err := tx.RevokeAPIKey(r.Context(), keyId); err != nil {
+			return err
+		}
+		after, err := tx.GetAPIKey(r.Context(), keyId)
+		if err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditAPIKeyRevoke,
+			EntityType: "api_key",
+			EntityId:   keyId.String(),
+			Before:     before,
+			After:      after,
+		})
+	})
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "API key not found")
+	default:
+		slog.ErrorContext(r.Context(), "failed to revoke API key", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+	}
+}
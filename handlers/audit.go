@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// auditChange describes a write for the audit log. Before and After are
+// marshalled to JSON; leave Before nil for creations.
+type auditChange struct {
+	EventId    string
+	Action     string
+	EntityType string
+	EntityId   string
+	Before     any
+	After      any
+}
+
+// audit records change as made by the request's user. Pass the transaction
+// the change was written in, so the entry commits or rolls back with it.
+func (h *Handler) audit(r *http.Request, q db.Database, change auditChange) error {
+	entry := &models.AuditEntry{
+		EventId:    change.EventId,
+		Action:     change.Action,
+		EntityType: change.EntityType,
+		EntityId:   change.EntityId,
+		IP:         utils.ClientIP(r, h.Config.RateLimit.TrustedProxies),
+		UserAgent:  r.UserAgent(),
+	}
+	if user, ok := firebaseauth.FbUserFromContext(r.Context()); ok {
+		entry.ActorUID, entry.ActorEmail = user.UID, user.Email
+	}
+
+	var err error
+	if change.Before != nil {
+		if entry.Before, err = json.Marshal(change.Before); err != nil {
+			return err
+		}
+	}
+	if change.After != nil {
+		if entry.After, err = json.Marshal(change.After); err != nil {
+			return err
+		}
+	}
+	return q.RecordAudit(r.Context(), entry)
+}
+
+/*
+GetAuditLog lists an event's audit log, newest first.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (all optional):
+
+	actor        firebase uid of whoever made the change
+	action       e.g. checkin.update
+	entity_type  e.g. attendee
+	entity_id
+	from, to     RFC 3339 timestamps; from is inclusive, to exclusive
+	before       only entries with a smaller id, for paging
+	limit        page size, default 100, at most 1000
+	format       "csv" or "ndjson" exports every matching entry instead of a page
+
+Returns:
+- 200 OK with a JSON array of entries, or the export as an attachment
+- 400 Bad Request for invalid filters
+- 403 Forbidden if the caller is not an event creator
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	isCreator, err := h.DB.IsCreator(r.Context(), fireBaseUser.UID, eventId.String())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(r.Context(), "failed to check creator status", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check creator status")
+		return
+	}
+	if !isCreator {
+		utils.RespondWithError(w, http.StatusForbidden, "Only event creators can see the audit log")
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.EventId = eventId.String()
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		entries := []models.AuditEntry{}
+		err = h.DB.EachAuditEntry(r.Context(), filter, func(e *models.AuditEntry) error {
+			entries = append(entries, *e)
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get audit log", "err", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get audit log")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	case "csv", "ndjson":
+		filter.Limit = 0
+		h.exportAuditLog(w, r, filter, format)
+	default:
+		utils.RespondWithError(w, http.StatusBadRequest, "format must be csv or ndjson")
+	}
+}
+
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		ActorUID:   q.Get("actor"),
+		Action:     q.Get("action"),
+		EntityType: q.Get("entity_type"),
+		EntityId:   q.Get("entity_id"),
+		Limit:      defaultAuditPageSize,
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := q.Get(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", bound.name)
+			}
+			*bound.dst = &t
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 1 {
+			return filter, errors.New("before must be a positive entry id")
+		}
+		filter.BeforeID = id
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxAuditPageSize)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// exportAuditLog streams every entry matching filter as CSV or NDJSON.
+func (h *Handler) exportAuditLog(w http.ResponseWriter, r *http.Request, filter models.AuditFilter, format string) {
+	filename := fmt.Sprintf("audit-%s-%s.%s", filter.EventId, time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	started := false
+	var write func(e *models.AuditEntry) error
+	var flush func() error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		// the header goes out with the first row so a failed query can still
+		// be answered with a 500
+		header := func() error {
+			started = true
+			return cw.Write([]string{"id", "created_at", "actor_uid", "actor_email", "action", "entity_type", "entity_id", "before", "after", "ip", "user_agent"})
+		}
+		write = func(e *models.AuditEntry) error {
+			if !started {
+				if err := header(); err != nil {
+					return err
+				}
+			}
+			return cw.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.CreatedAt.UTC().Format(time.RFC3339),
+				e.ActorUID,
+				e.ActorEmail,
+				e.Action,
+				e.EntityType,
+				e.EntityId,
+				string(e.Before),
+				string(e.After),
+				e.IP,
+				e.UserAgent,
+			})
+		}
+		flush = func() error {
+			if !started {
+				if err := header(); err != nil {
+					return err
+				}
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(e *models.AuditEntry) error {
+			started = true
+			return enc.Encode(e)
+		}
+		flush = func() error { return nil }
+	}
+
+	err := h.DB.EachAuditEntry(r.Context(), filter, write)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to export audit log", "err", err)
+		if !started {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to export audit log")
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/badges"
+	"github.com/koiraladarwin/scanin/features/export"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+/*
+GetBadgeSettings returns how attendee badge numbers are formatted for an event.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Returns:
+- 200 OK with { "event_id", "start_number", "padding", "prefixes": { role: prefix } }
+- 403 Forbidden without the event.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetBadgeSettings(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.EventManage, "You are not authorized to see badge settings") {
+		return
+	}
+
+	settings, err := h.DB.GetBadgeSettings(r.Context(), eventId)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get badge settings", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get badge settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+/*
+UpdateBadgeSettings accepts JSON:
+
+	{
+	  "start_number": 1,
+	  "padding": 3,
+	  "prefixes": { "speaker": "SPK-", "vip": "VIP-" }
+	}
+
+With the settings above the first speaker is badge "SPK-001". Changing the
+start number never reuses numbers already handed out; counters only move forward.
+
+Returns:
+- 200 OK with the saved settings
+- 400 Bad Request for invalid input
+- 403 Forbidden without the event.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) UpdateBadgeSettings(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	var settings models.BadgeSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	settings.EventId = eventId
+
+	if settings.StartNumber < 0 || settings.Padding < 0 || settings.Padding > 10 {
+		utils.RespondWithError(w, http.StatusBadRequest, "start_number must be >= 0 and padding between 0 and 10")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.EventManage, "You are not authorized to change badge settings") {
+		return
+	}
+
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetBadgeSettings(r.Context(), eventId)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateBadgeSettings(r.Context(), &settings); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId,
+			Action:     models.AuditBadgeSettingsUpdate,
+			EntityType: "badge_settings",
+			EntityId:   eventId,
+			Before:     before,
+			After:      settings,
+		})
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to update badge settings", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update badge settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+/*
+GetBadgeLayout returns how an event's printable badge sheet is laid out.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Returns:
+- 200 OK with { "event_id", "columns", "rows", "default_color", "role_colors": { role: "#rrggbb" } }
+- 403 Forbidden without the event.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetBadgeLayout(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.EventManage, "You are not authorized to see the badge layout") {
+		return
+	}
+
+	layout, err := h.DB.GetBadgeLayout(r.Context(), eventId)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get badge layout", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get badge layout")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(layout)
+}
+
+/*
+UpdateBadgeLayout accepts JSON:
+
+	{
+	  "columns": 2,
+	  "rows": 4,
+	  "default_color": "#374151",
+	  "role_colors": { "speaker": "#b91c1c", "vip": "#ca8a04" }
+	}
+
+columns x rows badges are printed per A4 page; roles without a color of
+their own use default_color.
+
+Returns:
+- 200 OK with the saved layout
+- 400 Bad Request for invalid input
+- 403 Forbidden without the event.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) UpdateBadgeLayout(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	var layout models.BadgeLayout
+	if err := json.NewDecoder(r.Body).Decode(&layout); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	layout.EventId = eventId
+
+	if layout.Columns < 1 || layout.Columns > badges.MaxColumns || layout.Rows < 1 || layout.Rows > badges.MaxRows {
+		utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("columns must be between 1 and %d and rows between 1 and %d", badges.MaxColumns, badges.MaxRows))
+		return
+	}
+	if !badges.ValidColor(layout.DefaultColor) {
+		utils.RespondWithError(w, http.StatusBadRequest, "default_color must be a #rrggbb color")
+		return
+	}
+	for role, color := range layout.RoleColors {
+		if !badges.ValidColor(color) {
+			utils.RespondWithError(w, http.StatusBadRequest, "role_colors."+role+" must be a #rrggbb color")
+			return
+		}
+	}
+	if layout.RoleColors == nil {
+		layout.RoleColors = map[string]string{}
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.EventManage, "You are not authorized to change the badge layout") {
+		return
+	}
+
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetBadgeLayout(r.Context(), eventId)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateBadgeLayout(r.Context(), &layout); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId,
+			Action:     models.AuditBadgeLayoutUpdate,
+			EntityType: "badge_layout",
+			EntityId:   eventId,
+			Before:     before,
+			After:      layout,
+		})
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to update badge layout", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update badge layout")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(layout)
+}
+
+/*
+GetBadgeSheet renders print-ready badges for an event's attendees as a PDF,
+laid out as configured with UpdateBadgeLayout. Each badge's QR code holds
+the attendee id the scanner apps check in with.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	role         only attendees with this role
+	attendee_id  only these attendees; may be repeated, to reprint single badges
+
+Returns:
+- 200 OK with the PDF as an attachment
+- 400 Bad Request for an invalid id
+- 403 Forbidden without the attendee.read permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetBadgeSheet(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	q := r.URL.Query()
+	only := map[uuid.UUID]bool{}
+	for _, v := range q["attendee_id"] {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "attendee_id must be a uuid")
+			return
+		}
+		only[id] = true
+	}
+	role := q.Get("role")
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.AttendeeRead, "You are not authorized to print badges") {
+		return
+	}
+
+	layout, err := h.DB.GetBadgeLayout(r.Context(), eventId.String())
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get badge layout", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get badge layout")
+		return
+	}
+
+	attendees, err := h.DB.GetUsersByEvent(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch attendees", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "failed to fetch attendees")
+		return
+	}
+
+	sheet := make([]badges.Badge, 0, len(attendees))
+	for _, a := range attendees {
+		if role != "" && a.Role != role {
+			continue
+		}
+		if len(only) > 0 && !only[a.ID] {
+			continue
+		}
+		sheet = append(sheet, badges.Badge{
+			Name:     a.FullName,
+			Company:  a.Company,
+			Position: a.Position,
+			Role:     a.Role,
+			Number:   a.BadgeNumber,
+			QR:       a.ID.String(),
+		})
+	}
+
+	var buf bytes.Buffer
+	err = badges.Render(&buf, badges.Layout{
+		Columns:      layout.Columns,
+		Rows:         layout.Rows,
+		DefaultColor: layout.DefaultColor,
+		RoleColors:   layout.RoleColors,
+	}, sheet)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to render badges", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to render badges")
+		return
+	}
+
+	w.Header().Set("Content-Type", export.PDF.MediaType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename("badges", time.Now(), export.PDF)))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/calendar"
+	"github.com/koiraladarwin/scanin/features/export"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+const calendarTokenLength = 32
+
+// CalendarLink is where an event's agenda feed can be subscribed to.
+type CalendarLink struct {
+	URL       string `json:"url"`
+	WebcalURL string `json:"webcal_url"`
+}
+
+/*
+GetCalendarFeed serves an event's activities as an iCalendar feed, one
+VEVENT per activity. It needs no Firebase token: calendar apps can't send
+one, so the token query param from GetCalendarLink grants access instead.
+Changed activity times show up the next time the client refreshes.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Param:
+
+	token (the event's calendar token)
+
+Returns:
+- 200 OK with a text/calendar body
+- 304 Not Modified if If-None-Match matches the feed's ETag
+- 404 Not Found for an unknown event or a wrong token
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Calendar not found")
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.RespondWithError(w, http.StatusNotFound, "Calendar not found")
+		return
+	}
+	event, activities, err := h.DB.GetCalendarFeed(r.Context(), eventId, token)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Calendar not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch calendar feed", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get calendar")
+		return
+	}
+
+	var body bytes.Buffer
+	if err := calendar.Render(&body, *event, activities); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render calendar", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get calendar")
+		return
+	}
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", calendar.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, calendarSlug(event.Name)))
+	w.Write(body.Bytes())
+}
+
+/*
+GetCalendarLink returns the URL of an event's calendar feed, creating its
+token on first use. Anyone with a role on the event can fetch it, to share
+with attendees.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 200 OK with { "url": "https://.../events/{id}/calendar.ics?token=...", "webcal_url": "webcal://..." }
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user has no role on the event
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetCalendarLink(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	canSee, err := h.DB.CanSeeEventInfo(r.Context(), fireBaseUser.UID, eventId.String())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check event access", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
+		return
+	}
+	if !canSee {
+		utils.RespondWithError(w, http.StatusForbidden, "You are not part of this event")
+		return
+	}
+
+	token, err := h.DB.EnsureCalendarToken(r.Context(), eventId, utils.RandomString(calendarTokenLength))
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create calendar token", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get calendar link")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.calendarLink(r, eventId, token))
+}
+
+/*
+RotateCalendarLink replaces an event's calendar token. Calendars subscribed
+with the old URL stop updating and have to subscribe again.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 200 OK with the new { "url": "...", "webcal_url": "..." }
+- 400 Bad Request for an invalid id
+- 403 Forbidden without the event.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) RotateCalendarLink(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.EventManage, "You are not authorized to rotate the calendar link") {
+		return
+	}
+
+	token := utils.RandomString(calendarTokenLength)
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.RotateCalendarToken(r.Context(), eventId, token); err != nil {
+			return err
+		}
+		// the token is a credential and stays out of the log
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditEventRotateCalendar,
+			EntityType: "event",
+			EntityId:   eventId.String(),
+		})
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to rotate calendar token", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to rotate calendar link")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.calendarLink(r, eventId, token))
+}
+
+// calendarLink builds the feed URL on the host the request came in on.
+func (h *Handler) calendarLink(r *http.Request, eventId uuid.UUID, token string) CalendarLink {
+	scheme := "http"
+	if r.TLS != nil || h.Config.RateLimit.TrustedProxies > 0 && r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     "/events/" + eventId.String() + "/calendar.ics",
+		RawQuery: url.Values{"token": {token}}.Encode(),
+	}
+	link := CalendarLink{URL: u.String()}
+	u.Scheme = "webcal"
+	link.WebcalURL = u.String()
+	return link
+}
+
+func calendarSlug(name string) string {
+	if slug := export.Slug(name); slug != "" {
+		return slug
+	}
+	return "calendar"
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	db "github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/certificates"
+	"github.com/koiraladarwin/scanin/features/export"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+/*
+GetCertificateTemplate returns the template an event's certificates of
+attendance are printed from. Events that never saved one get a default title
+and no signatory or logo.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Returns:
+- 200 OK with { "event_id", "title", "signatory", "signatory_title", "logo": base64 PNG/JPEG (omitted when unset) }
+- 403 Forbidden without the event.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetCertificateTemplate(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.EventManage, "You are not authorized to see the certificate template") {
+		return
+	}
+
+	tmpl, err := h.DB.GetCertificateTemplate(r.Context(), eventId)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get certificate template", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get certificate template")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+/*
+UpdateCertificateTemplate accepts JSON:
+
+	{
+	  "title": "Certificate of Attendance",
+	  "signatory": "Jane Doe",
+	  "signatory_title": "Head of Training",
+	  "logo": "<base64 PNG or JPEG, at most 1 MiB; omit for none>"
+	}
+
+Returns:
+- 200 OK with the saved template
+- 400 Bad Request for invalid input
+- 403 Forbidden without the event.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) UpdateCertificateTemplate(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	// base64 makes the logo a third larger, plus room for the other fields
+	r.Body = http.MaxBytesReader(w, r.Body, certificates.MaxLogoSize*4/3+4096)
+	var tmpl models.CertificateTemplate
+	if err := json.NewDecoder(r.Body).Decode(&tmpl); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	tmpl.EventId = eventId
+	tmpl.Title = strings.TrimSpace(tmpl.Title)
+
+	if tmpl.Title == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "title is required")
+		return
+	}
+	if len(tmpl.Logo) > certificates.MaxLogoSize {
+		utils.RespondWithError(w, http.StatusBadRequest, "logo must be at most 1 MiB")
+		return
+	}
+	if len(tmpl.Logo) > 0 {
+		if _, err := certificates.LogoType(tmpl.Logo); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.EventManage, "You are not authorized to change the certificate template") {
+		return
+	}
+
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetCertificateTemplate(r.Context(), eventId)
+		if err != nil {
+			return err
+		}
+		if err := tx.SaveCertificateTemplate(r.Context(), &tmpl); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId,
+			Action:     models.AuditCertificateUpdate,
+			EntityType: "certificate_template",
+			EntityId:   eventId,
+			Before:     certificateAuditState(before),
+			After:      certificateAuditState(&tmpl),
+		})
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to save certificate template", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save certificate template")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+// certificateAuditState keeps the logo itself out of the audit log.
+func certificateAuditState(t *models.CertificateTemplate) map[string]any {
+	return map[string]any{
+		"title":           t.Title,
+		"signatory":       t.Signatory,
+		"signatory_title": t.SignatoryTitle,
+		"logo_bytes":      len(t.Logo),
+	}
+}
+
+/*
+GetCertificate renders one attendee's certificate of attendance as a PDF,
+listing every activity they are checked into.
+
+Path Params:
+
+	id          (uuid-string of the event)
+	attendee_id (uuid-string)
+
+Query Params (optional):
+
+	tz  IANA time zone the activity times are printed in, e.g. Asia/Kathmandu; UTC by default
+
+Returns:
+- 200 OK with the PDF as an attachment
+- 400 Bad Request for an invalid id or time zone
+- 403 Forbidden without the export.run permission
+- 404 Not Found if the event or attendee does not exist, or the attendee has no check-ins
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetCertificate(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+	attendeeId, err := uuid.Parse(vars["attendee_id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid attendee id format")
+		return
+	}
+	loc, err := parseTimeZone(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.ExportRun, "You are not authorized to issue certificates") {
+		return
+	}
+
+	attendee, err := h.DB.GetUser(r.Context(), attendeeId)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && attendee.EventId != eventId.String() {
+		utils.RespondWithError(w, http.StatusNotFound, "Attendee not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get attendee", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get attendee")
+		return
+	}
+
+	event, tmpl, ok := h.certificateSource(w, r, fireBaseUser.UID, eventId)
+	if !ok {
+		return
+	}
+
+	attended, err := h.DB.GetAttendedActivities(r.Context(), eventId, attendeeId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get attended activities", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get check-ins")
+		return
+	}
+	if len(attended) == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "Attendee has not checked into any activity")
+		return
+	}
+
+	var buf bytes.Buffer
+	err = certificates.Render(&buf, certificateTemplate(tmpl), certificates.Certificate{
+		Name:       attendee.FullName,
+		Event:      event.Name,
+		Activities: certificateActivities(attended),
+		Location:   loc,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to render certificate", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to render certificate")
+		return
+	}
+
+	w.Header().Set("Content-Type", export.PDF.MediaType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename("certificate "+attendee.FullName, time.Now(), export.PDF)))
+	w.Write(buf.Bytes())
+}
+
+/*
+GetCertificates renders a certificate of attendance for every attendee of an
+event with at least one check-in and returns them as a ZIP of PDFs.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	tz  IANA time zone the activity times are printed in; UTC by default
+
+Returns:
+- 200 OK with the ZIP as an attachment
+- 400 Bad Request for an invalid id or time zone
+- 403 Forbidden without the export.run permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetCertificates(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+	loc, err := parseTimeZone(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.ExportRun, "You are not authorized to issue certificates") {
+		return
+	}
+
+	event, tmpl, ok := h.certificateSource(w, r, fireBaseUser.UID, eventId)
+	if !ok {
+		return
+	}
+
+	attendees, err := h.DB.GetUsersByEvent(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch attendees", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "failed to fetch attendees")
+		return
+	}
+	attended, err := h.DB.GetAttendedActivities(r.Context(), eventId, uuid.Nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get attended activities", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get check-ins")
+		return
+	}
+	byAttendee := map[uuid.UUID][]models.AttendedActivity{}
+	for _, a := range attended {
+		byAttendee[a.UserId] = append(byAttendee[a.UserId], a)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`,
+		strings.TrimSuffix(export.Filename(event.Name+" certificates", time.Now(), export.PDF), "."+export.PDF.Extension)+".zip"))
+
+	// the archive is streamed, so once the first file is out a failure can
+	// only cut the download short
+	archive := zip.NewWriter(w)
+	template := certificateTemplate(tmpl)
+	used := map[string]int{}
+	for _, a := range attendees {
+		activities := byAttendee[a.ID]
+		if len(activities) == 0 {
+			continue
+		}
+		// badge numbers only differ between roles when the roles have prefixes
+		name := export.Slug(a.BadgeNumber + " " + a.FullName)
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+		f, err := archive.Create(name + ".pdf")
+		if err == nil {
+			err = certificates.Render(f, template, certificates.Certificate{
+				Name:       a.FullName,
+				Event:      event.Name,
+				Activities: certificateActivities(activities),
+				Location:   loc,
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to render certificates", "err", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		slog.ErrorContext(r.Context(), "failed to finish certificate archive", "err", err)
+	}
+}
+
+// certificateSource loads the event and its certificate template, answering
+// the request itself when either is missing.
+func (h *Handler) certificateSource(w http.ResponseWriter, r *http.Request, uid string, eventId uuid.UUID) (*models.Event, *models.CertificateTemplate, bool) {
+	event, err := h.DB.GetEventByFirebaseUser(r.Context(), uid, eventId)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return nil, nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get event", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get event")
+		return nil, nil, false
+	}
+
+	tmpl, err := h.DB.GetCertificateTemplate(r.Context(), eventId.String())
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return nil, nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get certificate template", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get certificate template")
+		return nil, nil, false
+	}
+	return event, tmpl, true
+}
+
+func certificateTemplate(t *models.CertificateTemplate) certificates.Template {
+	return certificates.Template{
+		Title:          t.Title,
+		Signatory:      t.Signatory,
+		SignatoryTitle: t.SignatoryTitle,
+		Logo:           t.Logo,
+	}
+}
+
+func certificateActivities(attended []models.AttendedActivity) []certificates.Activity {
+	activities := make([]certificates.Activity, len(attended))
+	for i, a := range attended {
+		activities[i] = certificates.Activity{Name: a.Name, Start: a.StartTime, End: a.EndTime}
+	}
+	return activities
+}
+
+// parseTimeZone reads the optional tz query parameter.
+func parseTimeZone(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+	return loc, nil
+}
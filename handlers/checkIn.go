@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -12,10 +13,15 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/export"
 	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/features/metrics"
+	"github.com/koiraladarwin/scanin/features/reports"
+	"github.com/koiraladarwin/scanin/features/webhooks"
 	"github.com/koiraladarwin/scanin/models"
 	"github.com/koiraladarwin/scanin/utils"
-	"github.com/xuri/excelize/v2"
 )
 
 /*
@@ -32,6 +38,7 @@ CreateCheckIn accepts JSON:
 Returns:
 - 201 Created with created check-in JSON on success
 - 400 Bad Request for invalid input
+- 403 Forbidden without the checkin.create permission
 - 409 Conflict if already checked in for that attendee and activity
 - 500 Internal Server Error on DB failure
 */
@@ -53,53 +60,224 @@ func (h *Handler) CreateCheckIn(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.String("attendee_id", c.UserID.String()), slog.String("activity_id", c.ActivityID.String()))
 
-	id, err := h.DB.CheckInExists(c.UserID, c.ActivityID)
-	if errors.Is(err, db.ErrNotFound) {
-		scannedBy := fbuser.Email
-		scannedAt := time.Now()
-		err := h.DB.CreateCheckInLog(&models.CheckInLog{
-			UserID:     c.UserID,
-			ActivityID: c.ActivityID,
-			ScannedAt:  scannedAt,
-			Status:     "checked",
-			ScannedBy:  scannedBy,
-		})
+	allowed, err := h.DB.HasActivityPermission(r.Context(), fbuser.UID, c.ActivityID, permissions.CheckInCreate)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check permission", "permission", permissions.CheckInCreate, "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
+		return
+	}
+	if !allowed {
+		h.recordRejectedScan(r, fbuser, c, models.ScanForbidden)
+		utils.RespondWithError(w, http.StatusForbidden, "You are not authorized to check attendees in")
+		return
+	}
+
+	var checkIn *models.CheckInLog
+	created := false
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		eventId, err := tx.GetEventIdByActivity(r.Context(), c.ActivityID)
+		if err != nil {
+			return err
+		}
+
+		id, err := tx.CheckInExists(r.Context(), c.UserID, c.ActivityID)
+		if errors.Is(err, db.ErrNotFound) {
+			checkIn = &models.CheckInLog{
+				UserID:       c.UserID,
+				ActivityID:   c.ActivityID,
+				ScannedAt:    time.Now(),
+				Status:       "checked",
+				ScannedBy:    fbuser.Email,
+				ScannedByUID: fbuser.UID,
+			}
+			created = true
+			if err := tx.CreateCheckInLog(r.Context(), checkIn); err != nil {
+				return err
+			}
+			if err := h.notify(r, tx, eventId.String(), webhooks.CheckInCreated, checkIn); err != nil {
+				return err
+			}
+			return h.audit(r, tx, auditChange{
+				EventId:    eventId.String(),
+				Action:     models.AuditCheckInCreate,
+				EntityType: "checkin",
+				EntityId:   checkIn.ID.String(),
+				After:      checkIn,
+			})
+		}
 		if err != nil {
+			return err
+		}
 
-			log.Print(err.Error())
-			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check in")
-			return
+		checkIn, err = tx.GetCheckInLog(r.Context(), id)
+		if err != nil {
+			return err
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(c)
+		if checkIn.Status == "checked" {
+			return db.ErrAlreadyExists
+		}
+
+		// a re-check-in is a new scan: it starts a new visit, so the time and
+		// any checkout of the reverted one don't carry over
+		before := *checkIn
+		checkIn.Status = "checked"
+		checkIn.ScannedAt = time.Now()
+		checkIn.CheckedOutAt = nil
+		checkIn.ScannedBy = fbuser.Email
+		checkIn.ScannedByUID = fbuser.UID
+		if err := tx.UpdateCheckInLog(r.Context(), checkIn); err != nil {
+			return err
+		}
+		if err := h.notify(r, tx, eventId.String(), webhooks.CheckInCreated, checkIn); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditCheckInUpdate,
+			EntityType: "checkin",
+			EntityId:   checkIn.ID.String(),
+			Before:     before,
+			After:      checkIn,
+		})
+	})
+
+	if errors.Is(err, db.ErrAlreadyExists) {
+		metrics.DuplicateScansRejected.Inc()
+		h.recordRejectedScan(r, fbuser, c, models.ScanDuplicate)
+		utils.RespondWithError(w, http.StatusConflict, "Cannot Check in twice")
 		return
 	}
 
 	if err != nil {
-		log.Print(err.Error())
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check existing check-in")
+		slog.ErrorContext(r.Context(), "failed to check in", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check in")
+		return
+	}
+	metrics.CheckInsCreated.Inc()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if created {
+		json.NewEncoder(w).Encode(c)
 		return
 	}
+	json.NewEncoder(w).Encode(checkIn)
+}
 
-	user, err := h.DB.GetCheckInLog(id)
+// recordRejectedScan keeps a scan that checked no one in for the staff
+// statistics. It is best effort: the scanner gets their answer either way.
+func (h *Handler) recordRejectedScan(r *http.Request, fbUser *auth.UserRecord, c models.CheckInLogRequest, reason string) {
+	eventId, err := h.DB.GetEventIdByActivity(r.Context(), c.ActivityID)
 	if err != nil {
-		log.Print(err.Error())
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch existing check-in")
+		// no such activity, so there is no event to count it against
 		return
 	}
-	if user.Status == "checked" {
-		utils.RespondWithError(w, http.StatusConflict, "Cannot Check in twice")
+	err = h.DB.RecordRejectedScan(r.Context(), &models.RejectedScan{
+		EventID:    eventId,
+		ActivityID: c.ActivityID,
+		AttendeeID: c.UserID,
+		StaffUID:   fbUser.UID,
+		StaffEmail: fbUser.Email,
+		Reason:     reason,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to record rejected scan", "reason", reason, "err", err)
+	}
+}
+
+/*
+CheckOut records when an attendee leaves an activity, for activities whose
+credits depend on how long attendees stayed.
+
+Accepts JSON:
+
+	{
+	  "attendee_id": "uuid-string",
+	  "activity_id": "uuid-string"
+	}
+
+Returns:
+- 200 OK with the check-in JSON, now carrying checked_out_at
+- 400 Bad Request for invalid input
+- 403 Forbidden without the checkin.create permission
+- 404 Not Found if the attendee is not checked in to the activity
+- 409 Conflict if already checked out
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) CheckOut(w http.ResponseWriter, r *http.Request) {
+	fbUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	var c models.CheckInLogRequest
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("attendee_id", c.UserID.String()), slog.String("activity_id", c.ActivityID.String()))
+
+	if !h.requireActivityPermission(w, r, fbUser.UID, c.ActivityID, permissions.CheckInCreate, "You are not authorized to check attendees out") {
+		return
+	}
+
+	var checkIn *models.CheckInLog
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		eventId, err := tx.GetEventIdByActivity(r.Context(), c.ActivityID)
+		if err != nil {
+			return err
+		}
+
+		id, err := tx.CheckInExists(r.Context(), c.UserID, c.ActivityID)
+		if err != nil {
+			return err
+		}
+		checkIn, err = tx.GetCheckInLog(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if checkIn.Status != "checked" {
+			return db.ErrNotFound
+		}
+		if checkIn.CheckedOutAt != nil {
+			return db.ErrAlreadyExists
+		}
+
+		before := *checkIn
+		now := time.Now()
+		checkIn.CheckedOutAt = &now
+		if err := tx.UpdateCheckInLog(r.Context(), checkIn); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditCheckInCheckOut,
+			EntityType: "checkin",
+			EntityId:   checkIn.ID.String(),
+			Before:     before,
+			After:      checkIn,
+		})
+	})
+
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Attendee is not checked in")
+		return
+	}
+	if errors.Is(err, db.ErrAlreadyExists) {
+		utils.RespondWithError(w, http.StatusConflict, "Already checked out")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check out", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check out")
 		return
 	}
 
-	user.Status = "checked"
-	user.ScannedBy = fbuser.Email
-	err = h.DB.UpdateCheckInLog(user)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(checkIn)
 }
 
 /*
@@ -118,9 +296,16 @@ Accepts JSON:
 Returns:
 - 200 OK with updated check-in JSON on success
 - 400 Bad Request for invalid ID or input
+- 403 Forbidden without the checkin.undo permission
 - 500 Internal Server Error on DB failure
 */
 func (h *Handler) ModifyCheckIn(w http.ResponseWriter, r *http.Request) {
+	fbUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	if idStr == "" {
@@ -134,13 +319,17 @@ func (h *Handler) ModifyCheckIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	checkIn, err := h.DB.GetCheckInLog(id)
+	checkIn, err := h.DB.GetCheckInLog(r.Context(), id)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "check in id not found")
 		return
 	}
 
-	user, err := h.DB.GetUser(checkIn.UserID)
+	if !h.requireActivityPermission(w, r, fbUser.UID, checkIn.ActivityID, permissions.CheckInUndo, "You are not authorized to change check-ins") {
+		return
+	}
+
+	user, err := h.DB.GetUser(r.Context(), checkIn.UserID)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "user id not found")
 		return
@@ -151,46 +340,54 @@ func (h *Handler) ModifyCheckIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := *checkIn
+	eventType := webhooks.CheckInCreated
 	if checkIn.Status == "checked" {
+		// an undo keeps who scanned and when, for the record
 		checkIn.Status = "unchecked"
-		err := h.DB.UpdateCheckInLog(checkIn)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
-			return
+		eventType = webhooks.CheckInReverted
+	} else {
+		// a re-check is a new scan by the caller, as in CreateCheckIn
+		checkIn.Status = "checked"
+		checkIn.ScannedAt = time.Now()
+		checkIn.ScannedBy = fbUser.Email
+		checkIn.ScannedByUID = fbUser.UID
+	}
+	// a checkout belonged to the old status either way
+	checkIn.CheckedOutAt = nil
+
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.UpdateCheckInLog(r.Context(), checkIn); err != nil {
+			return err
 		}
-
-		checkInReponse := models.CheckInRespose{
-			ID:         checkIn.ID,
-			UserID:     checkIn.UserID,
-			ActivityID: checkIn.ActivityID,
-			Status:     checkIn.Status,
-			FullName:   user.FullName,
-			ScannedAt:  checkIn.ScannedAt,
-			ScannedBy:  checkIn.ScannedBy,
+		if err := h.notify(r, tx, user.EventId, eventType, checkIn); err != nil {
+			return err
 		}
-
-		w.WriteHeader(http.StatusCreated)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(checkInReponse)
-		return
-	}
-
-	checkIn.Status = "checked"
-	err = h.DB.UpdateCheckInLog(checkIn)
+		return h.audit(r, tx, auditChange{
+			EventId:    user.EventId,
+			Action:     models.AuditCheckInUpdate,
+			EntityType: "checkin",
+			EntityId:   checkIn.ID.String(),
+			Before:     before,
+			After:      checkIn,
+		})
+	})
 
 	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to update check-in", "err", err)
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
 
 	checkInReponse := models.CheckInRespose{
-		ID:         checkIn.ID,
-		UserID:     checkIn.UserID,
-		ActivityID: checkIn.ActivityID,
-		Status:     checkIn.Status,
-		FullName:   user.FullName,
-		ScannedAt:  checkIn.ScannedAt,
-		ScannedBy:  checkIn.ScannedBy,
+		ID:           checkIn.ID,
+		UserID:       checkIn.UserID,
+		ActivityID:   checkIn.ActivityID,
+		Status:       checkIn.Status,
+		FullName:     user.FullName,
+		ScannedAt:    checkIn.ScannedAt,
+		ScannedBy:    checkIn.ScannedBy,
+		ScannedByUID: checkIn.ScannedByUID,
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -203,67 +400,77 @@ GetCheckIn , retrives all check Ins
 
 Returns:
 - 200 OK with updated check-in JSON on success
+- 403 Forbidden for API keys, which only see their own event
 - 500 Internal Server Error on DB failure
 */
 
 func (h *Handler) GetCheckIn(w http.ResponseWriter, r *http.Request) {
-	checkInLogs, err := h.DB.GetAllCheckInLog()
-	if err != nil {
-		log.Print(err.Error())
-		utils.RespondWithError(w, http.StatusInternalServerError, "Can't get check-in logs")
+	fbUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+	if !h.requireUser(w, fbUser.UID, "API keys can only list their event's check-ins") {
 		return
 	}
 
-	var responses []models.CheckInRespose
-
-	for _, logItem := range checkInLogs {
-		user, err := h.DB.GetUser(logItem.UserID)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Can't get user details")
-			return
-		}
-		activity, err := h.DB.GetActivity(logItem.ActivityID)
-		if err != nil {
-			resp := models.CheckInRespose{
-				ID:           logItem.ID,
-				FullName:     user.FullName,
-				UserID:       logItem.UserID,
-				ActivityName: "Cant Find",
-				ActivityID:   logItem.ActivityID,
-				ScannedAt:    logItem.ScannedAt,
-				ScannedBy:    logItem.ScannedBy,
-				Status:       logItem.Status,
-			}
-			responses = append(responses, resp)
-			continue
-		}
+	streamCheckIns(w, r, func(fn func(*models.CheckInRespose) error) error {
+		return h.DB.EachCheckIn(r.Context(), fn)
+	})
+}
 
-		resp := models.CheckInRespose{
-			ID:           logItem.ID,
-			FullName:     user.FullName,
-			UserID:       logItem.UserID,
-			ActivityName: activity.Name,
-			ActivityID:   logItem.ActivityID,
-			ScannedAt:    logItem.ScannedAt,
-			ScannedBy:    logItem.ScannedBy,
-			Status:       logItem.Status,
+// streamCheckIns writes every check-in produced by each as a JSON array while
+// rows are still being read, instead of collecting them first.
+func streamCheckIns(w http.ResponseWriter, r *http.Request, each func(fn func(*models.CheckInRespose) error) error) {
+	stream := utils.NewJSONArrayStream(w)
+	err := each(func(c *models.CheckInRespose) error {
+		return stream.Write(c)
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to stream check-in logs", "err", err)
+		if !stream.Started() {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Can't get check-in logs")
 		}
-		responses = append(responses, resp)
+		// once the array has started the status is already sent; the client
+		// sees a truncated body instead
+		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(responses)
+	stream.Close()
 }
 
 /*
-Export CheckIn , retrives all check Ins
+ExportCheckIn downloads an event's check-ins as XLSX (the default), CSV,
+NDJSON or a printable PDF attendance sheet.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Query Params (all optional):
+
+	format       xlsx, csv, ndjson or pdf; otherwise picked from the Accept header
+	activity_id  only this activity
+	from, to     RFC 3339 bounds on scanned_at; from is inclusive, to exclusive
+	status       checked or unchecked
+	role         attendee role, e.g. speaker
+	scanned_by      email of the staff member who scanned, as it was at the time
+	scanned_by_uid  Firebase UID of the staff member who scanned
 
 Returns:
-- 200 OK with updated check-in JSON on success
+- 200 OK with the file as an attachment named after the event and the time of export
+- 400 Bad Request for an invalid id, filter or format
+- 403 Forbidden without the export.run permission
+- 404 Not Found if the event does not exist
+- 406 Not Acceptable if no format matches the Accept header
 - 500 Internal Server Error on DB failure
 */
 func (h *Handler) ExportCheckIn(w http.ResponseWriter, r *http.Request) {
+	fbUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
 	vars := mux.Vars(r)
 	idStr := vars["event_id"]
 	if idStr == "" {
@@ -277,55 +484,89 @@ func (h *Handler) ExportCheckIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	checkInLogs, err := h.DB.GetAllCheckInOfEvents(id)
+	format, err := export.Negotiate(r.URL.Query().Get("format"), r.Header.Get("Accept"), export.XLSX)
+	if errors.Is(err, export.ErrNotAcceptable) {
+		utils.RespondWithError(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
 	if err != nil {
-		log.Print(err.Error())
-		utils.RespondWithError(w, http.StatusInternalServerError, "Can't get check-in logs")
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	f := excelize.NewFile()
-	sheet := "CheckIns"
-	f.SetSheetName("Sheet1", sheet)
+	filter, err := parseCheckInFilter(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	headers := []string{"ID", "Full Name", "Activity ", "Scanned At", "Scanned By", "Status"}
-	for i, header := range headers {
-		cell := fmt.Sprintf("%c1", 'A'+i)
-		f.SetCellValue(sheet, cell, header)
+	if !h.requirePermission(w, r, fbUser.UID, idStr, permissions.ExportRun, "You are not authorized to export check-ins") {
+		return
 	}
 
-	for i, logItem := range checkInLogs {
-		user, err := h.DB.GetUser(logItem.UserID)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Can't get user details")
-			return
+	event, err := h.DB.GetEventByFirebaseUser(r.Context(), fbUser.UID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get event", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get event")
+		return
+	}
+
+	download := export.NewDownload(w, format, export.Filename(event.Name+" check-ins", time.Now(), format))
+	doc, err := format.New(download, export.Table{
+		Title:   event.Name + " - Check-ins",
+		Columns: reports.CheckInColumns,
+	})
+	if err == nil {
+		err = h.DB.EachCheckInOfEvent(r.Context(), id, filter, func(c *models.CheckInRespose) error {
+			return doc.WriteRow(reports.CheckInRow(c))
+		})
+		if err == nil {
+			err = doc.Close()
 		}
-		activity, err := h.DB.GetActivity(logItem.ActivityID)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Can't get user details")
-			return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to export check-ins", "format", format.Name, "err", err)
+		// once the file has started the status is already sent; the client
+		// sees a truncated download instead
+		if !download.Started() {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Can't export check-in logs")
 		}
-
-		rowNum := i + 2 // excel rows start at 1 and row 1 is the header so manually +2
-
-		f.SetCellValue(sheet, fmt.Sprintf("A%d", rowNum), user.AutoId)
-		f.SetCellValue(sheet, fmt.Sprintf("B%d", rowNum), user.FullName)
-		f.SetCellValue(sheet, fmt.Sprintf("C%d", rowNum), activity.Name)
-		f.SetCellValue(sheet, fmt.Sprintf("D%d", rowNum), logItem.ScannedAt.Format(time.RFC3339))
-		f.SetCellValue(sheet, fmt.Sprintf("E%d", rowNum), logItem.ScannedBy)
-		f.SetCellValue(sheet, fmt.Sprintf("F%d", rowNum), logItem.Status)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", `attachment; filename="checkins.xlsx"`)
-	w.WriteHeader(http.StatusOK)
-
-	err = f.Write(w)
-	if err != nil {
-		log.Printf("Error writing Excel file: %v", err)
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to write Excel file")
+func parseCheckInFilter(r *http.Request) (models.CheckInFilter, error) {
+	q := r.URL.Query()
+	filter := models.CheckInFilter{
+		Status:       q.Get("status"),
+		Role:         q.Get("role"),
+		ScannedBy:    q.Get("scanned_by"),
+		ScannedByUID: q.Get("scanned_by_uid"),
 	}
 
+	if v := q.Get("activity_id"); v != "" {
+		activityId, err := uuid.Parse(v)
+		if err != nil {
+			return filter, errors.New("activity_id must be a uuid")
+		}
+		filter.ActivityID = &activityId
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := q.Get(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", bound.name)
+			}
+			*bound.dst = &t
+		}
+	}
+	return filter, nil
 }
 
 /*
@@ -333,11 +574,17 @@ GetCheckInById , retrives all check Ins
 
 Returns:
 - 200 OK with updated check-in JSON on success
+- 400 Bad Request for a missing or invalid event_id
+- 403 Forbidden without the checkin.read permission
 - 500 Internal Server Error on DB failure
 */
 
 func (h *Handler) GetCheckInByEventId(w http.ResponseWriter, r *http.Request) {
-	log.Println("Executing GetCheckInByEventId handler")
+	fbUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
 	vars := mux.Vars(r)
 	eventIdStr := vars["event_id"]
 	if eventIdStr == "" {
@@ -351,45 +598,13 @@ func (h *Handler) GetCheckInByEventId(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	checkInLogs, err := h.DB.GetAllCheckInOfEvents(event_id)
-	if err != nil {
-		log.Print(err.Error())
-		utils.RespondWithError(w, http.StatusInternalServerError, "Can't get check-in logs")
+	if !h.requirePermission(w, r, fbUser.UID, eventIdStr, permissions.CheckInRead, "You are not authorized to see check-ins of this event") {
 		return
 	}
 
-	var responses []models.CheckInRespose
-
-	for _, logItem := range checkInLogs {
-		user, err := h.DB.GetUser(logItem.UserID)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Can't get user details")
-			return
-		}
-
-		activity, err := h.DB.GetActivity(logItem.ActivityID)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Can't get activity name"+err.Error())
-			return
-		}
-
-		resp := models.CheckInRespose{
-			ID:           logItem.ID,
-			FullName:     user.FullName,
-			UserID:       logItem.UserID,
-			ActivityName: activity.Name,
-			ActivityID:   logItem.ActivityID,
-			ScannedAt:    logItem.ScannedAt,
-			ScannedBy:    logItem.ScannedBy,
-			Status:       logItem.Status,
-		}
-
-		responses = append(responses, resp)
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(responses)
+	streamCheckIns(w, r, func(fn func(*models.CheckInRespose) error) error {
+		return h.DB.EachCheckInOfEvent(r.Context(), event_id, models.CheckInFilter{}, fn)
+	})
 }
 
 /*
@@ -421,19 +636,17 @@ func (h *Handler) GetCheckInByActivityId(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	eventId, err := h.DB.GetEventIdByActivity(activityId)
+	eventId, err := h.DB.GetEventIdByActivity(r.Context(), activityId)
 	if err != nil {
-		log.Println("here1")
-		log.Println(err.Error())
+		slog.ErrorContext(r.Context(), "failed to get event id by activity", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get event ID by activity")
 		return
 	}
 
-	access, err := h.DB.CanSeeScanned(fbUser.UID, eventId.String())
+	access, err := h.DB.HasPermission(r.Context(), fbUser.UID, eventId.String(), permissions.CheckInRead)
 
 	if err != nil {
-		log.Println("here2")
-		log.Println(err.Error())
+		slog.ErrorContext(r.Context(), "failed to check event access", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
 		return
 	}
@@ -443,10 +656,9 @@ func (h *Handler) GetCheckInByActivityId(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	checkInLogs, err := h.DB.GetAllCheckInOfActivity(activityId)
+	checkInLogs, err := h.DB.GetAllCheckInOfActivity(r.Context(), activityId)
 	if err != nil {
-		log.Println("here3")
-		log.Print(err.Error())
+		slog.ErrorContext(r.Context(), "failed to get check-in logs", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Can't get check-in logs")
 		return
 	}
@@ -463,9 +675,16 @@ Returns:
 - 200 OK with updated check-in JSON on success
 - 500 Internal Server Error on DB failure
 - 400 Bad Request
+- 403 Forbidden without the checkin.read permission on the attendee's event
+- 404 Not Found if the attendee does not exist
 */
 
 func (h *Handler) GetCheckInByUserId(w http.ResponseWriter, r *http.Request) {
+	fbUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
 	vars := mux.Vars(r)
 	checkInLogs := []models.CheckInRespose{}
 	activityIdStr := vars["attendee_id"]
@@ -480,12 +699,25 @@ func (h *Handler) GetCheckInByUserId(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	checkInLogs, err = h.DB.GetAllCheckInOfUser(activityId)
+	attendee, err := h.DB.GetUser(r.Context(), activityId)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Attendee not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get attendee", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get attendee")
+		return
+	}
+	if !h.requirePermission(w, r, fbUser.UID, attendee.EventId, permissions.CheckInRead, "You are not authorized to see check-ins of this event") {
+		return
+	}
+
+	checkInLogs, err = h.DB.GetAllCheckInOfUser(r.Context(), activityId)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Can't get check-in logs")
 		return
 	}
-	log.Print(checkInLogs)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(checkInLogs)
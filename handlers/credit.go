@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/features/credits"
+	"github.com/koiraladarwin/scanin/features/export"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+/*
+GetCreditTranscript lists every credit-bearing activity of an event for one
+attendee with what they earned for it and, when nothing, why. Each entry of
+activities carries activity_id, name, start_time, end_time, credits,
+credit_rule, checked_in_at, checked_out_at, dwell_percent, earned and reason.
+
+Path Params:
+
+	id          (uuid-string of the event)
+	attendee_id (uuid-string)
+
+Returns:
+- 200 OK with { "attendee_id", "full_name", "badge_number", "total_credits", "available_credits", "activities": [...] }
+- 400 Bad Request for an invalid id
+- 403 Forbidden without the attendee.read permission
+- 404 Not Found if the attendee is not part of the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetCreditTranscript(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+	attendeeId, err := uuid.Parse(vars["attendee_id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid attendee id format")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.AttendeeRead, "You are not authorized to see attendees") {
+		return
+	}
+
+	attendee, err := h.DB.GetUser(r.Context(), attendeeId)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && attendee.EventId != eventId.String() {
+		utils.RespondWithError(w, http.StatusNotFound, "Attendee not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get attendee", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get attendee")
+		return
+	}
+
+	activities, err := h.DB.GetActivitiesByEvent(r.Context(), fireBaseUser.UID, eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get activities", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get activities")
+		return
+	}
+	scans, err := h.DB.GetCreditScans(r.Context(), eventId, attendeeId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get check-ins", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get check-ins")
+		return
+	}
+
+	lines, earned, available := credits.Transcript(sortedByStart(activities), credits.ByAttendee(scans)[attendeeId])
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.CreditTranscript{
+		AttendeeID:       attendee.ID,
+		FullName:         attendee.FullName,
+		BadgeNumber:      attendee.BadgeNumber,
+		TotalCredits:     earned,
+		AvailableCredits: available,
+		Activities:       lines,
+	})
+}
+
+/*
+ExportCredits downloads the credits every attendee of an event earned, one
+row per attendee with a column per credit-bearing activity, as XLSX (the
+default) or any other export format.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	format  xlsx, csv, ndjson or pdf; otherwise picked from the Accept header
+
+Returns:
+- 200 OK with the file as an attachment
+- 400 Bad Request for an invalid id or format
+- 403 Forbidden without the export.run permission
+- 404 Not Found if the event does not exist
+- 406 Not Acceptable if no format matches the Accept header
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) ExportCredits(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	format, err := export.Negotiate(r.URL.Query().Get("format"), r.Header.Get("Accept"), export.XLSX)
+	if errors.Is(err, export.ErrNotAcceptable) {
+		utils.RespondWithError(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.ExportRun, "You are not authorized to export credits") {
+		return
+	}
+
+	event, err := h.DB.GetEventByFirebaseUser(r.Context(), fireBaseUser.UID, eventId)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get event", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get event")
+		return
+	}
+
+	activities, err := h.DB.GetActivitiesByEvent(r.Context(), fireBaseUser.UID, eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get activities", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get activities")
+		return
+	}
+	attendees, err := h.DB.GetUsersByEvent(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch attendees", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "failed to fetch attendees")
+		return
+	}
+	scans, err := h.DB.GetCreditScans(r.Context(), eventId, uuid.Nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get check-ins", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get check-ins")
+		return
+	}
+
+	activities = sortedByStart(activities)
+	columns := []export.Column{
+		{Key: "badge_number", Title: "Badge", Width: 0.9},
+		{Key: "full_name", Title: "Full Name", Width: 1.6},
+		{Key: "company", Title: "Company", Width: 1.3},
+		{Key: "role", Title: "Role", Width: 0.9},
+	}
+	keys := map[string]int{}
+	for _, a := range activities {
+		if a.Credits <= 0 {
+			continue
+		}
+		key := export.Slug(a.Name)
+		if key == "" {
+			key = "activity"
+		}
+		if keys[key]++; keys[key] > 1 {
+			key = key + "-" + a.ID.String()[:8]
+		}
+		columns = append(columns, export.Column{Key: key, Title: a.Name, Width: 0.9})
+	}
+	columns = append(columns, export.Column{Key: "total_credits", Title: "Total Credits", Width: 0.9})
+
+	byAttendee := credits.ByAttendee(scans)
+	download := export.NewDownload(w, format, export.Filename(event.Name+" credits", time.Now(), format))
+	doc, err := format.New(download, export.Table{
+		Title:   event.Name + " - Credits",
+		Columns: columns,
+	})
+	if err == nil {
+		for _, a := range attendees {
+			lines, earned, _ := credits.Transcript(activities, byAttendee[a.ID])
+			row := []any{a.BadgeNumber, a.FullName, a.Company, a.Role}
+			for _, l := range lines {
+				row = append(row, l.Earned)
+			}
+			if err = doc.WriteRow(append(row, earned)); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = doc.Close()
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to export credits", "format", format.Name, "err", err)
+		if !download.Started() {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Can't export credits")
+		}
+	}
+}
+
+// sortedByStart orders activities the way a transcript reads, earliest first.
+func sortedByStart(activities []models.Activity) []models.Activity {
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].StartTime.Before(activities[j].StartTime)
+	})
+	return activities
+}
@@ -4,12 +4,16 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/apikeys"
 	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/permissions"
 	"github.com/koiraladarwin/scanin/models"
 	"github.com/koiraladarwin/scanin/utils"
 )
@@ -18,15 +22,46 @@ import (
 Returns:
 - 201 Created with created check-in JSON on success
 - 400 Bad Request for invalid input
+- 403 Forbidden when called with an API key
 - 500 Internal Server Error on DB failure
 */
 func (h *Handler) CreateEvent(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+	if !h.requireUser(w, fireBaseUser.UID, "API keys can't create events") {
+		return
+	}
+
 	var c models.EventCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
-	if err := h.DB.CreateEvent(&c); err != nil {
+
+	// the event and its creator role are written together so an event can
+	// never exist without someone who is allowed to manage it
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		eventId, err := tx.CreateEvent(r.Context(), &c)
+		if err != nil {
+			return err
+		}
+		logging.AddAttrs(r.Context(), slog.String("event_id", eventId.String()))
+		if err := tx.AddOwnerToEvent(r.Context(), fireBaseUser.UID, eventId.String()); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditEventCreate,
+			EntityType: "event",
+			EntityId:   eventId.String(),
+			After:      c,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create event", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create Event")
 		return
 	}
@@ -36,18 +71,46 @@ func (h *Handler) CreateEvent(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) ModifyEvent(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
 	var c models.EventModifyRequest
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
-	if err := h.DB.UpdateEvent(&c); err != nil {
+	logging.AddAttrs(r.Context(), slog.String("event_id", c.ID.String()))
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, c.ID.String(), permissions.EventManage, "You are not authorized to change this event") {
+		return
+	}
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetEventByFirebaseUser(r.Context(), fireBaseUser.UID, c.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateEvent(r.Context(), &c); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    c.ID.String(),
+			Action:     models.AuditEventUpdate,
+			EntityType: "event",
+			EntityId:   c.ID.String(),
+			Before:     models.EventModifyRequest{ID: before.ID, Name: before.Name, Description: before.Description, Location: before.Location},
+			After:      c,
+		})
+	})
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			utils.RespondWithError(w, http.StatusNotFound, "Event not found")
 			return
 		}
+		slog.ErrorContext(r.Context(), "failed to modify event", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to modify Event")
-    log.Println("Failed to modify Event:", err.Error())
 		return
 	}
 
@@ -55,6 +118,22 @@ func (h *Handler) ModifyEvent(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(c)
 }
 
+/*
+AddEventWithEventCode joins the caller to an event by code:
+  - a 6 character staff code adds them as staff without permissions
+  - a 7 character admin code makes them a co-organizer (creator rights)
+  - a 12 character invitation code adds them with the invitation's permissions
+
+Redeeming a code for an event you already belong to is not an error; an admin
+code still promotes existing staff, but nothing is ever downgraded.
+
+Returns:
+- 200 OK with the event JSON
+- 400 Bad Request if the code has the wrong length
+- 403 Forbidden when called with an API key
+- 404 Not Found if no event has that code
+- 500 Internal Server Error on DB failure
+*/
 func (h *Handler) AddEventWithEventCode(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	code := vars["code"]
@@ -64,39 +143,145 @@ func (h *Handler) AddEventWithEventCode(w http.ResponseWriter, r *http.Request)
 		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
 		return
 	}
+	if !h.requireUser(w, fireBaseUser.UID, "API keys can't join events") {
+		return
+	}
+
+	if len(code) == models.InvitationCodeLength {
+		h.redeemInvitation(w, r, code, fireBaseUser)
+		return
+	}
 
 	if len(code) != 6 && len(code) != 7 {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid staff_code or admin_code length")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid staff_code, admin_code or invitation length")
 		return
 	}
+	isAdminCode := len(code) == 7
 
 	var event *models.Event
-	var err error
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		var err error
+		if isAdminCode {
+			event, err = tx.GetEventByAdminId(r.Context(), code)
+		} else {
+			event, err = tx.GetEventByStaffId(r.Context(), code)
+		}
+		if err != nil {
+			return err
+		}
+		logging.AddAttrs(r.Context(), slog.String("event_id", event.ID.String()))
 
-	if len(code) == 6 {
-		event, err = h.DB.GetEventByStaffId(code)
-	} else {
-		event, err = h.DB.GetEventByAdminId(code)
+		via := "staff_code"
+		if isAdminCode {
+			via = "admin_code"
+			err = tx.AddAdminToEvent(r.Context(), fireBaseUser.UID, event.ID.String())
+		} else {
+			err = tx.AddStaffToEvent(r.Context(), fireBaseUser.UID, event.ID.String())
+		}
+		if err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    event.ID.String(),
+			Action:     models.AuditStaffJoin,
+			EntityType: "staff",
+			EntityId:   fireBaseUser.UID,
+			After:      map[string]any{"via": via, "is_creator": isAdminCode},
+		})
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
 	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to join event by code", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add staff to event")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
+
+/*
+TransferOwnership accepts JSON:
+
+	{
+	  "event_id": "uuid-string",
+	  "firebase_id": "uid of the new owner"
+	}
+
+The new owner must already have a role on the event and becomes a creator if
+they weren't one. The caller stays on as a co-organizer.
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request for invalid input or an API key as the new owner
+- 403 Forbidden if the caller is not the event owner
+- 404 Not Found if the new owner has no role on the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	var req models.TransferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FireBaseId == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	if _, err := uuid.Parse(req.EventId); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event_id format")
+		return
+	}
+	if apikeys.IsUID(req.FireBaseId) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Ownership can't be transferred to an API key")
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("event_id", req.EventId))
 
+	isOwner, err := h.DB.IsOwner(r.Context(), fireBaseUser.UID, req.EventId)
 	if err != nil {
-		log.Println("Failed to fetch event by code:", err.Error())
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch event by code")
+		slog.ErrorContext(r.Context(), "failed to check owner status", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check owner status")
 		return
 	}
-	if event == nil {
-		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+	if !isOwner {
+		utils.RespondWithError(w, http.StatusForbidden, "Only the event owner can transfer ownership")
+		return
+	}
+	if req.FireBaseId == fireBaseUser.UID {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	err = h.DB.AddStaffToEvent(fireBaseUser.UID, event.ID.String())
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.TransferEventOwnership(r.Context(), req.EventId, fireBaseUser.UID, req.FireBaseId); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    req.EventId,
+			Action:     models.AuditEventTransferOwner,
+			EntityType: "event",
+			EntityId:   req.EventId,
+			Before:     map[string]string{"owner": fireBaseUser.UID},
+			After:      map[string]string{"owner": req.FireBaseId},
+		})
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "The new owner has no role on this event")
+		return
+	}
 	if err != nil {
-		log.Println("Failed to fetch event by code:2", err.Error())
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add staff to event")
+		slog.ErrorContext(r.Context(), "failed to transfer ownership", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to transfer ownership")
 		return
 	}
 
-	json.NewEncoder(w).Encode(event)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 /*
@@ -105,17 +290,16 @@ Returns:
 - 500 Internal Server Error if DB query fails
 */
 func (h *Handler) GetEvent(w http.ResponseWriter, r *http.Request) {
-	// events, err := h.DB.GetAllEvents()
+	// events, err := h.DB.GetAllEvents(r.Context())
 	firebaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
 	if !ok {
-		log.Println("Unauthorized: no user in context")
 		http.Error(w, "Unauthorized: no user in context", http.StatusUnauthorized)
 		return
 	}
 
-	events, err := h.DB.GetEventsByFirebaseUser(firebaseUser.UID)
+	events, err := h.DB.GetEventsByFirebaseUser(r.Context(), firebaseUser.UID)
 	if err != nil {
-		log.Println("Failed to fetch events:", err)
+		slog.ErrorContext(r.Context(), "failed to fetch events", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch events")
 		return
 	}
@@ -150,9 +334,9 @@ func (h *Handler) GetEventInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	event, err := h.DB.GetEventByFirebaseUser(fireBaseUser.UID, eventID)
+	event, err := h.DB.GetEventByFirebaseUser(r.Context(), fireBaseUser.UID, eventID)
 	if err != nil {
-		log.Println("Failed to fetch event:", err)
+		slog.ErrorContext(r.Context(), "failed to fetch event", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch event: "+err.Error())
 		return
 	}
@@ -161,9 +345,9 @@ func (h *Handler) GetEventInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	activities, err := h.DB.GetActivitiesByEvent(fireBaseUser.UID, eventID)
+	activities, err := h.DB.GetActivitiesByEvent(r.Context(), fireBaseUser.UID, eventID)
 	if err != nil {
-		log.Println("Failed to fetch event1 :", err)
+		slog.ErrorContext(r.Context(), "failed to fetch activities", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch activities: "+err.Error())
 		return
 	}
@@ -173,7 +357,6 @@ func (h *Handler) GetEventInfo(w http.ResponseWriter, r *http.Request) {
 		Activities: activities,
 	}
 
-	log.Print("event info response: ", resp)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
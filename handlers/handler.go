@@ -1,15 +1,28 @@
 package handlers
 
 import (
+	"sync/atomic"
+
+	"github.com/koiraladarwin/scanin/config"
 	"github.com/koiraladarwin/scanin/database"
 	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/reports"
+	"github.com/koiraladarwin/scanin/features/webhooks"
 )
 
 type Handler struct {
-	DB     db.Database
-	FbAuth *firebaseauth.FirebaseAuth
+	DB       db.Database
+	FbAuth   *firebaseauth.FirebaseAuth
+	Config   *config.Config
+	draining atomic.Bool
+
+	// Reports sends report emails; nil when SMTP isn't configured.
+	Reports *reports.Scheduler
+	// Webhooks sends queued webhook deliveries; nil when they are turned
+	// off, and then nothing is queued either.
+	Webhooks *webhooks.Dispatcher
 }
 
-func New(db db.Database,fbAuth *firebaseauth.FirebaseAuth) *Handler {
-	return &Handler{DB: db,FbAuth: fbAuth}
+func New(db db.Database,fbAuth *firebaseauth.FirebaseAuth, cfg *config.Config) *Handler {
+	return &Handler{DB: db,FbAuth: fbAuth, Config: cfg}
 }
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds each dependency check so a hung database doesn't
+// make the probe itself time out.
+const readinessTimeout = 2 * time.Second
+
+/*
+Healthz reports that the process is up. It never touches dependencies.
+
+Returns:
+- 200 OK with { "status": "ok" }
+*/
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+/*
+Readyz reports whether the instance should receive traffic: the database
+answers a ping and the Firebase token keys are reachable. Once shutdown has
+started it always fails so the load balancer drains the instance.
+
+Returns:
+- 200 OK with { "status": "ok", "checks": { "database": "ok", "auth": "ok" } }
+- 503 Service Unavailable with the failing check marked "unavailable" (the
+  error is only logged), or status "draining"
+*/
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if h.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	status := "ok"
+	checks := map[string]string{"database": "ok", "auth": "ok"}
+
+	if err := h.DB.Ping(ctx); err != nil {
+		slog.WarnContext(r.Context(), "readiness: database ping failed", "err", err)
+		checks["database"] = "unavailable"
+		status = "unavailable"
+	}
+	if err := h.FbAuth.Ping(ctx); err != nil {
+		slog.WarnContext(r.Context(), "readiness: auth provider unreachable", "err", err)
+		checks["auth"] = "unavailable"
+		status = "unavailable"
+	}
+
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
+
+// StartDraining makes Readyz fail from now on; called when shutdown begins.
+func (h *Handler) StartDraining() {
+	h.draining.Store(true)
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+const (
+	defaultInvitationTTL = 7 * 24 * time.Hour
+	maxInvitationTTL     = 30 * 24 * time.Hour
+	maxInvitationUses    = 1000
+)
+
+var (
+	errInvitationRevoked   = errors.New("invitation has been revoked")
+	errInvitationExpired   = errors.New("invitation has expired")
+	errInvitationUsedUp    = errors.New("invitation has no uses left")
+	errInvitationWrongUser = errors.New("invitation is for a different email address")
+)
+
+/*
+CreateInvitation accepts JSON:
+
+	{
+	  "event_id": "uuid-string",
+	  "email": "optional, only this (verified) address can redeem it",
+	  "role": "scanner",
+	  "permissions": ["checkin.read"],
+	  "max_uses": 1,
+	  "expires_at": "2025-07-08T15:30:00Z"
+	}
+
+role and permissions are what the invitee gets (the scanner role when both are
+empty); the caller must hold all of them. max_uses defaults to 1 and
+expires_at to 7 days from now; invitations can't live longer than 30 days. The returned code is redeemed through
+/addeventwithcode/{code} like a staff code.
+
+Returns:
+- 201 Created with the invitation JSON, including its code
+- 400 Bad Request for invalid input
+- 403 Forbidden without the staff.manage permission
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	var req models.InvitationCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("event_id", req.EventId.String()))
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, req.EventId.String(), permissions.StaffManage, "You are not authorized to invite staff") {
+		return
+	}
+
+	now := time.Now()
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.ExpiresAt.IsZero() {
+		req.ExpiresAt = now.Add(defaultInvitationTTL)
+	}
+	if req.MaxUses < 1 || req.MaxUses > maxInvitationUses {
+		utils.RespondWithError(w, http.StatusBadRequest, "max_uses must be between 1 and 1000")
+		return
+	}
+	if !req.ExpiresAt.After(now) || req.ExpiresAt.After(now.Add(maxInvitationTTL)) {
+		utils.RespondWithError(w, http.StatusBadRequest, "expires_at must be in the next 30 days")
+		return
+	}
+
+	if req.Role == "" && len(req.Permissions) == 0 {
+		req.Role = permissions.DefaultRole
+	}
+	if !h.requireGrantable(w, r, fireBaseUser.UID, req.EventId.String(), req.Role, req.Permissions) {
+		return
+	}
+
+	inv := &models.Invitation{
+		EventId:     req.EventId,
+		Role:        req.Role,
+		Permissions: req.Permissions,
+		MaxUses:     req.MaxUses,
+		ExpiresAt:   req.ExpiresAt,
+		CreatedBy:   fireBaseUser.UID,
+	}
+	if email := strings.TrimSpace(req.Email); email != "" {
+		inv.Email = &email
+	}
+
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.CreateInvitation(r.Context(), inv); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    inv.EventId.String(),
+			Action:     models.AuditInvitationCreate,
+			EntityType: "invitation",
+			EntityId:   inv.ID.String(),
+			After:      redactedInvitation(inv),
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create invitation", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create invitation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+/*
+GetInvitationsByEvent lists every invitation of an event, newest first,
+including expired and revoked ones.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Returns:
+- 200 OK with JSON array of invitations
+- 400 Bad Request if event_id is invalid
+- 403 Forbidden without the staff.manage permission
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetInvitationsByEvent(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["event_id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event_id format")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.StaffManage, "You are not authorized to see invitations") {
+		return
+	}
+
+	invitations, err := h.DB.GetInvitationsByEvent(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get invitations", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get invitations")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+/*
+RevokeInvitation stops an invitation from being redeemed again. Staff who
+already joined with it keep their role.
+
+Path Param:
+
+	id (uuid-string of the invitation)
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request if id is invalid
+- 403 Forbidden without the staff.manage permission
+- 404 Not Found if the invitation does not exist or is already revoked
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid invitation id")
+		return
+	}
+
+	inv, err := h.DB.GetInvitation(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Invitation not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get invitation", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get invitation")
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("event_id", inv.EventId.String()))
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, inv.EventId.String(), permissions.StaffManage, "You are not authorized to revoke invitations") {
+		return
+	}
+
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.RevokeInvitation(r.Context(), id); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    inv.EventId.String(),
+			Action:     models.AuditInvitationRevoke,
+			EntityType: "invitation",
+			EntityId:   id.String(),
+			Before:     redactedInvitation(inv),
+		})
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, "Invitation not found or already revoked")
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to revoke invitation", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke invitation")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+RotateEventCodes replaces an event's staff and admin codes. The old codes
+stop working immediately; staff who already joined keep their roles.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Returns:
+- 200 OK with { "staff_code": "...", "admin_code": "..." }
+- 400 Bad Request if event_id is invalid
+- 403 Forbidden without the staff.manage permission
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) RotateEventCodes(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["event_id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event_id format")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId.String(), permissions.EventManage, "You are not authorized to rotate codes") {
+		return
+	}
+
+	var codes *models.EventCodes
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		var err error
+		codes, err = tx.RotateEventCodes(r.Context(), eventId)
+		if err != nil {
+			return err
+		}
+		// the codes themselves are credentials and stay out of the log
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditEventRotateCodes,
+			EntityType: "event",
+			EntityId:   eventId.String(),
+		})
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to rotate event codes", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to rotate event codes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
+// redeemInvitation joins the caller to the invitation's event with its
+// permissions. Redeeming an event you already belong to doesn't use it up.
+func (h *Handler) redeemInvitation(w http.ResponseWriter, r *http.Request, code string, fireBaseUser *auth.UserRecord) {
+	var event *models.Event
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		inv, err := tx.GetInvitationByCode(r.Context(), code)
+		if err != nil {
+			return err
+		}
+		logging.AddAttrs(r.Context(), slog.String("event_id", inv.EventId.String()))
+
+		if err := invitationUsableBy(inv, fireBaseUser, time.Now()); err != nil {
+			return err
+		}
+
+		added, err := tx.AddStaffWithRole(r.Context(), models.RoleRequest{
+			EventId:     inv.EventId.String(),
+			FireBaseId:  fireBaseUser.UID,
+			Role:        inv.Role,
+			Permissions: inv.Permissions,
+		})
+		if err != nil {
+			return err
+		}
+		if added {
+			if err := tx.UseInvitation(r.Context(), inv.ID); err != nil {
+				return err
+			}
+			err := h.audit(r, tx, auditChange{
+				EventId:    inv.EventId.String(),
+				Action:     models.AuditStaffJoin,
+				EntityType: "staff",
+				EntityId:   fireBaseUser.UID,
+				After: map[string]any{
+					"via":           "invitation",
+					"invitation_id": inv.ID,
+					"role":          inv.Role,
+					"permissions":   inv.Permissions,
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		event, err = tx.GetEventByFirebaseUser(r.Context(), fireBaseUser.UID, inv.EventId)
+		return err
+	})
+
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(event)
+	case errors.Is(err, db.ErrNotFound), errors.Is(err, sql.ErrNoRows):
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+	case errors.Is(err, errInvitationRevoked), errors.Is(err, errInvitationExpired), errors.Is(err, errInvitationUsedUp):
+		utils.RespondWithError(w, http.StatusGone, err.Error())
+	case errors.Is(err, errInvitationWrongUser):
+		utils.RespondWithError(w, http.StatusForbidden, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), "failed to redeem invitation", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to redeem invitation")
+	}
+}
+
+func invitationUsableBy(inv *models.Invitation, user *auth.UserRecord, now time.Time) error {
+	if inv.RevokedAt != nil {
+		return errInvitationRevoked
+	}
+	if !now.Before(inv.ExpiresAt) {
+		return errInvitationExpired
+	}
+	if inv.Uses >= inv.MaxUses {
+		return errInvitationUsedUp
+	}
+	if inv.Email != nil && (!user.EmailVerified || !strings.EqualFold(*inv.Email, user.Email)) {
+		return errInvitationWrongUser
+	}
+	return nil
+}
+
+
+// redactedInvitation is inv without its code, which would let anyone reading
+// the audit log join the event.
+func redactedInvitation(inv *models.Invitation) models.Invitation {
+	redacted := *inv
+	redacted.Code = ""
+	return redacted
+}
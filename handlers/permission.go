@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/apikeys"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+// requirePermission writes a 403 (or 500) and returns false unless uid may
+// perform action on eventId.
+func (h *Handler) requirePermission(w http.ResponseWriter, r *http.Request, uid, eventId string, action permissions.Action, forbidden string) bool {
+	allowed, err := h.DB.HasPermission(r.Context(), uid, eventId, action)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check permission", "permission", action, "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
+		return false
+	}
+	if !allowed {
+		utils.RespondWithError(w, http.StatusForbidden, forbidden)
+		return false
+	}
+	return true
+}
+
+// requireUser writes a 403 and returns false if uid is an API key. Keys only
+// act on their own event with their scopes, so they can't take a role on
+// any event, their own included.
+func (h *Handler) requireUser(w http.ResponseWriter, uid string, forbidden string) bool {
+	if apikeys.IsUID(uid) {
+		utils.RespondWithError(w, http.StatusForbidden, forbidden)
+		return false
+	}
+	return true
+}
+
+// requireCreator writes a 403 (or 500) and returns false unless uid created
+// eventId.
+func (h *Handler) requireCreator(w http.ResponseWriter, r *http.Request, uid, eventId string, forbidden string) bool {
+	isCreator, err := h.DB.IsCreator(r.Context(), uid, eventId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(r.Context(), "failed to check creator status", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check creator status")
+		return false
+	}
+	if !isCreator {
+		utils.RespondWithError(w, http.StatusForbidden, forbidden)
+		return false
+	}
+	return true
+}
+
+// requireGrantable validates a role and extra permissions about to be handed
+// out on eventId, and makes sure uid holds all of them: staff managers can't
+// give anyone, including themselves, more than they have. It writes the
+// error response and returns false when the grant isn't allowed.
+func (h *Handler) requireGrantable(w http.ResponseWriter, r *http.Request, uid, eventId, role string, extra []permissions.Action) bool {
+	if err := permissions.Validate(extra); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	granted := extra
+	if role != "" {
+		template, err := h.DB.GetRoleTemplate(r.Context(), eventId, role)
+		if errors.Is(err, db.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Unknown role "+role)
+			return false
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get role template", "role", role, "err", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check role")
+			return false
+		}
+		granted = append(append([]permissions.Action{}, template.Permissions...), extra...)
+	}
+
+	held, err := h.DB.GetPermissions(r.Context(), uid, eventId)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "failed to get permissions", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
+		return false
+	}
+	if !permissions.Includes(held, granted) {
+		utils.RespondWithError(w, http.StatusForbidden, "You can't grant permissions you don't have")
+		return false
+	}
+	return true
+}
+
+// requireActivityPermission is requirePermission for the event activityId
+// belongs to.
+func (h *Handler) requireActivityPermission(w http.ResponseWriter, r *http.Request, uid string, activityId uuid.UUID, action permissions.Action, forbidden string) bool {
+	allowed, err := h.DB.HasActivityPermission(r.Context(), uid, activityId, action)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check permission", "permission", action, "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
+		return false
+	}
+	if !allowed {
+		utils.RespondWithError(w, http.StatusForbidden, forbidden)
+		return false
+	}
+	return true
+}
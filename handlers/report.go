@@ -0,0 +1,608 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/reports"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+/*
+GetReportSchedules lists an event's report schedules and whether the caller
+is subscribed to the emails.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 200 OK with { "schedules": [models.ReportSchedule], "subscribed": bool, "email_enabled": bool }
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetReportSchedules(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage reports") {
+		return
+	}
+
+	schedules, err := h.DB.GetReportSchedules(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get report schedules", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get report schedules")
+		return
+	}
+	subscribers, err := h.DB.GetReportSubscribers(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get report subscribers", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get report schedules")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Schedules    []models.ReportSchedule `json:"schedules"`
+		Subscribed   bool                    `json:"subscribed"`
+		EmailEnabled bool                    `json:"email_enabled"`
+	}{schedules, slices.Contains(subscribers, fireBaseUser.UID), h.Reports != nil})
+}
+
+/*
+CreateReportSchedule accepts JSON:
+
+	{
+	  "kind": "summary" | "final",
+	  "cron": "0 18 * * *",
+	  "time_zone": "Asia/Kathmandu",
+	  "format": "xlsx" | "csv",
+	  "enabled": true
+	}
+
+A summary is sent whenever cron fires, read in time_zone (UTC by default);
+cron is five fields, minute hour day-of-month month day-of-week, or one of
+@hourly, @daily, @weekly and @monthly. A final report has no cron and is sent
+once when the event ends. Both attach the check-in export in format, xlsx by
+default.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 201 Created with the schedule JSON, next_run_at included
+- 400 Bad Request for invalid input
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the event does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) CreateReportSchedule(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	var req models.ReportScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	if req.Kind != models.ReportSummary && req.Kind != models.ReportFinal {
+		utils.RespondWithError(w, http.StatusBadRequest, "kind must be summary or final")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage reports") {
+		return
+	}
+	event, ok := h.reportEvent(w, r, fireBaseUser.UID, eventId)
+	if !ok {
+		return
+	}
+
+	schedule := &models.ReportSchedule{
+		EventID:   eventId,
+		Kind:      req.Kind,
+		Enabled:   true,
+		CreatedBy: fireBaseUser.UID,
+	}
+	if err := applyReportRequest(schedule, req, event); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.CreateReportSchedule(r.Context(), schedule); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditReportCreate,
+			EntityType: "report_schedule",
+			EntityId:   schedule.ID.String(),
+			After:      schedule,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create report schedule", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create report schedule")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+/*
+UpdateReportSchedule accepts the same JSON as CreateReportSchedule, except
+that kind can't change; fields left out keep their value. Re-enabling a
+final report that was already sent does not send it again.
+
+Path Params:
+
+	id        (uuid-string of the event)
+	report_id (uuid-string)
+
+Returns:
+- 200 OK with the updated schedule JSON
+- 400 Bad Request for invalid input
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the event or schedule does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) UpdateReportSchedule(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, reportId, ok := parseReportIds(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.ReportScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage reports") {
+		return
+	}
+	event, ok := h.reportEvent(w, r, fireBaseUser.UID, eventId)
+	if !ok {
+		return
+	}
+
+	var schedule models.ReportSchedule
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetReportSchedule(r.Context(), reportId)
+		if err != nil {
+			return err
+		}
+		if before.EventID != eventId {
+			return db.ErrNotFound
+		}
+		if req.Kind != "" && req.Kind != before.Kind {
+			return errReportKindChange
+		}
+		schedule = *before
+		if err := applyReportRequest(&schedule, req, event); err != nil {
+			return err
+		}
+		if err := tx.UpdateReportSchedule(r.Context(), &schedule); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditReportUpdate,
+			EntityType: "report_schedule",
+			EntityId:   schedule.ID.String(),
+			Before:     before,
+			After:      schedule,
+		})
+	})
+	var invalid reportRequestError
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(schedule)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Report schedule not found")
+	case errors.As(err, &invalid):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), "failed to update report schedule", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update report schedule")
+	}
+}
+
+/*
+DeleteReportSchedule stops and removes a report schedule; its past deliveries
+are kept.
+
+Path Params:
+
+	id        (uuid-string of the event)
+	report_id (uuid-string)
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the schedule does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) DeleteReportSchedule(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, reportId, ok := parseReportIds(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage reports") {
+		return
+	}
+
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetReportSchedule(r.Context(), reportId)
+		if err != nil {
+			return err
+		}
+		if before.EventID != eventId {
+			return db.ErrNotFound
+		}
+		if err := tx.DeleteReportSchedule(r.Context(), reportId); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditReportDelete,
+			EntityType: "report_schedule",
+			EntityId:   reportId.String(),
+			Before:     before,
+		})
+	})
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Report schedule not found")
+	default:
+		slog.ErrorContext(r.Context(), "failed to delete report schedule", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete report schedule")
+	}
+}
+
+/*
+SendReport emails a schedule's report to the subscribers right away, leaving
+the schedule itself alone. Useful to check the SMTP setup, e.g. against a
+local catcher.
+
+Path Params:
+
+	id        (uuid-string of the event)
+	report_id (uuid-string)
+
+Returns:
+- 200 OK with a JSON array of models.ReportDelivery, one per subscriber
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the event or schedule does not exist
+- 500 Internal Server Error on DB failure
+- 503 Service Unavailable if report emails are not configured
+*/
+func (h *Handler) SendReport(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, reportId, ok := parseReportIds(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage reports") {
+		return
+	}
+	if h.Reports == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Report emails are not configured")
+		return
+	}
+	event, ok := h.reportEvent(w, r, fireBaseUser.UID, eventId)
+	if !ok {
+		return
+	}
+
+	schedule, err := h.DB.GetReportSchedule(r.Context(), reportId)
+	if errors.Is(err, db.ErrNotFound) || err == nil && schedule.EventID != eventId {
+		utils.RespondWithError(w, http.StatusNotFound, "Report schedule not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get report schedule", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get report schedule")
+		return
+	}
+
+	deliveries, sendErr := h.Reports.Send(r.Context(), *schedule, *event)
+
+	// the emails are out whatever happens here, so a failure to record the
+	// send is only logged
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditReportSend,
+			EntityType: "report_schedule",
+			EntityId:   reportId.String(),
+			After:      deliveries,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to audit report send", "err", err)
+	}
+
+	if sendErr != nil {
+		slog.ErrorContext(r.Context(), "failed to send report", "err", sendErr)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to send report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+/*
+GetReportDeliveries lists the latest report emails of an event, newest first,
+with whether each was sent.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	limit  how many, 50 by default and at most 500
+
+Returns:
+- 200 OK with a JSON array of models.ReportDelivery
+- 400 Bad Request for an invalid id or limit
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetReportDeliveries(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLimit))
+			return
+		}
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage reports") {
+		return
+	}
+
+	deliveries, err := h.DB.GetReportDeliveries(r.Context(), eventId, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get report deliveries", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get report deliveries")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+/*
+SubscribeReports (PUT) and UnsubscribeReports (DELETE) switch the report
+emails of an event on and off for the calling creator; they go to the email
+of their account at the time of sending.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) SubscribeReports(w http.ResponseWriter, r *http.Request) {
+	h.setReportSubscription(w, r, true)
+}
+
+func (h *Handler) UnsubscribeReports(w http.ResponseWriter, r *http.Request) {
+	h.setReportSubscription(w, r, false)
+}
+
+func (h *Handler) setReportSubscription(w http.ResponseWriter, r *http.Request, subscribed bool) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can subscribe to reports") {
+		return
+	}
+
+	action := models.AuditReportUnsubscribe
+	if subscribed {
+		action = models.AuditReportSubscribe
+	}
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.SetReportSubscription(r.Context(), eventId, fireBaseUser.UID, subscribed); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     action,
+			EntityType: "report_subscription",
+			EntityId:   fireBaseUser.UID,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to change report subscription", "subscribed", subscribed, "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to change report subscription")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reportRequestError is invalid report schedule input, reported as a 400.
+type reportRequestError struct{ msg string }
+
+func (e reportRequestError) Error() string { return e.msg }
+
+var errReportKindChange = reportRequestError{"kind can't be changed; create a new schedule instead"}
+
+// applyReportRequest copies the fields set in req onto s, validates the
+// result and works out its next run.
+func applyReportRequest(s *models.ReportSchedule, req models.ReportScheduleRequest, event *models.Event) error {
+	if req.Cron != "" {
+		s.Cron = req.Cron
+	}
+	if req.TimeZone != "" {
+		s.TimeZone = req.TimeZone
+	}
+	if s.TimeZone == "" {
+		s.TimeZone = "UTC"
+	}
+	if req.Format != "" {
+		s.Format = req.Format
+	}
+	if s.Format == "" {
+		s.Format = reports.Formats[0]
+	}
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+
+	if !slices.Contains(reports.Formats, s.Format) {
+		return reportRequestError{fmt.Sprintf("format must be one of %v", reports.Formats)}
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return reportRequestError{fmt.Sprintf("unknown time zone %q", s.TimeZone)}
+	}
+	switch s.Kind {
+	case models.ReportFinal:
+		if s.Cron != "" {
+			return reportRequestError{"final reports are sent when the event ends and take no cron"}
+		}
+	default:
+		if s.Cron == "" {
+			return reportRequestError{"cron is required for summary reports"}
+		}
+	}
+
+	s.NextRunAt = nil
+	if s.Enabled {
+		next, err := reports.NextRun(*s, *event, time.Now())
+		if err != nil {
+			return reportRequestError{"cron: " + err.Error()}
+		}
+		if next == nil && s.Kind == models.ReportSummary {
+			return reportRequestError{"cron never fires"}
+		}
+		s.NextRunAt = next
+	}
+	return nil
+}
+
+// reportEvent fetches the event a report is about, writing a 404 (or 500)
+// and returning false when it can't.
+func (h *Handler) reportEvent(w http.ResponseWriter, r *http.Request, uid string, eventId uuid.UUID) (*models.Event, bool) {
+	event, err := h.DB.GetEventByFirebaseUser(r.Context(), uid, eventId)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get event", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get event")
+		return nil, false
+	}
+	return event, true
+}
+
+func parseReportIds(w http.ResponseWriter, r *http.Request) (eventId, reportId uuid.UUID, ok bool) {
+	vars := mux.Vars(r)
+	eventId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return eventId, reportId, false
+	}
+	reportId, err = uuid.Parse(vars["report_id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid report id format")
+		return eventId, reportId, false
+	}
+	return eventId, reportId, true
+}
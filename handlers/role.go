@@ -1,16 +1,43 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/apikeys"
 	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/permissions"
 	"github.com/koiraladarwin/scanin/models"
 	"github.com/koiraladarwin/scanin/utils"
 )
 
+/*
+GiveRoleToStaff accepts JSON:
+
+	{
+	  "event_id": "uuid",
+	  "firebase_id": "uid of the staff member",
+	  "role": "registrar",
+	  "permissions": ["export.run"]
+	}
+
+role names a built-in or custom role template; permissions are granted on
+top of it. The caller must hold every permission they hand out.
+
+Returns:
+- 201 Created on success
+- 400 Bad Request for invalid input or an unknown role/permission
+- 403 Forbidden without the staff.manage permission, or when granting more than the caller has
+- 409 Conflict if the user already has a role on the event
+- 500 Internal Server Error on DB failure
+*/
 func (h *Handler) GiveRoleToStaff(w http.ResponseWriter, r *http.Request) {
 	editRoleReq := &models.RoleRequest{}
 	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
@@ -24,20 +51,37 @@ func (h *Handler) GiveRoleToStaff(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
-
-	isCreator, err := h.DB.IsCreator(fireBaseUser.UID, editRoleReq.EventId)
-
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check creator status")
+	if apikeys.IsUID(editRoleReq.FireBaseId) {
+		utils.RespondWithError(w, http.StatusBadRequest, "API keys can't be given a role")
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.String("event_id", editRoleReq.EventId))
 
-	if !isCreator {
-		utils.RespondWithError(w, http.StatusForbidden, "You are not authorized to give roles")
+	if !h.requirePermission(w, r, fireBaseUser.UID, editRoleReq.EventId, permissions.StaffManage, "You are not authorized to give roles") {
+		return
+	}
+	if !h.requireGrantable(w, r, fireBaseUser.UID, editRoleReq.EventId, editRoleReq.Role, editRoleReq.Permissions) {
 		return
 	}
 
-	if err := h.DB.AddEventRole(*editRoleReq); err != nil {
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.AddEventRole(r.Context(), *editRoleReq); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    editRoleReq.EventId,
+			Action:     models.AuditStaffGrant,
+			EntityType: "staff",
+			EntityId:   editRoleReq.FireBaseId,
+			After:      editRoleReq,
+		})
+	})
+	if errors.Is(err, db.ErrAlreadyExists) {
+		utils.RespondWithError(w, http.StatusConflict, "User already has a role on this event")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create role", "staff_uid", editRoleReq.FireBaseId, "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create role")
 		return
 	}
@@ -45,8 +89,19 @@ func (h *Handler) GiveRoleToStaff(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+/*
+ModifyRoleToStaff replaces a staff member's role and extra permissions. It
+takes the same JSON as GiveRoleToStaff.
+
+Returns:
+- 201 Created on success
+- 400 Bad Request for invalid input or an unknown role/permission
+- 403 Forbidden without the staff.manage permission, or when granting more than the caller has
+- 404 Not Found if the user has no role on the event
+- 500 Internal Server Error on DB failure
+*/
 func (h *Handler) ModifyRoleToStaff(w http.ResponseWriter, r *http.Request) {
-	createRoleReq := &models.EditRoleRequest{}
+	createRoleReq := &models.RoleRequest{}
 	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
 
 	if !ok {
@@ -58,22 +113,38 @@ func (h *Handler) ModifyRoleToStaff(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.String("event_id", createRoleReq.EventId))
 
-	isCreator, err := h.DB.IsCreator(fireBaseUser.UID, createRoleReq.EventId)
-
-	if err != nil {
-    log.Printf("Failed to check creator status for user %s in event %s: %v", fireBaseUser.UID, createRoleReq.EventId, err)
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check creator status")
+	if !h.requirePermission(w, r, fireBaseUser.UID, createRoleReq.EventId, permissions.StaffManage, "You are not authorized to give roles") {
 		return
 	}
-
-	if !isCreator {
-		utils.RespondWithError(w, http.StatusForbidden, "You are not authorized to give roles")
+	if !h.requireGrantable(w, r, fireBaseUser.UID, createRoleReq.EventId, createRoleReq.Role, createRoleReq.Permissions) {
 		return
 	}
 
-	if err := h.DB.ModifyEventRole(*createRoleReq); err != nil {
-    log.Printf("Failed to modify role for user %s in event %s: %v", createRoleReq.FireBaseId, createRoleReq.EventId, err)
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := findStaff(r, tx, createRoleReq.EventId, createRoleReq.FireBaseId)
+		if err != nil {
+			return err
+		}
+		if err := tx.ModifyEventRole(r.Context(), *createRoleReq); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    createRoleReq.EventId,
+			Action:     models.AuditStaffUpdate,
+			EntityType: "staff",
+			EntityId:   createRoleReq.FireBaseId,
+			Before:     before,
+			After:      createRoleReq,
+		})
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Staff not found on this event")
+		return
+	}
+	if err != nil {
+    slog.ErrorContext(r.Context(), "failed to modify role", "staff_uid", createRoleReq.FireBaseId, "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create role")
 		return
 	}
@@ -90,43 +161,46 @@ func (h *Handler) GetStaffsByEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isCreator, err := h.DB.IsCreator(fireBaseUser.UID, eventId)
-
-	if err != nil {
-    log.Printf("Failed to check creator status for user %s in event %s: %v", fireBaseUser.UID, eventId, err)
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check creator status")
-		return
-	}
-
-	if !isCreator {
-		utils.RespondWithError(w, http.StatusForbidden, "You are not authorized to give roles")
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.StaffManage, "You are not authorized to see staff") {
 		return
 	}
 
-	staffsFirebaseIds, err := h.DB.GetStaffByEvent(eventId)
+	staffsFirebaseIds, err := h.DB.GetStaffByEvent(r.Context(), eventId)
 	if err != nil {
-    log.Printf("Failed to get staffs for event %s: %v", eventId, err)
+    slog.ErrorContext(r.Context(), "failed to get staffs", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get staffs")
 		return
 	}
 
   ctx := r.Context()
+  uids := make([]string, 0, len(staffsFirebaseIds))
+  for _, staffId := range staffsFirebaseIds {
+    uids = append(uids, staffId.FireBaseId)
+  }
+  firebaseUsers, err := h.FbAuth.GetUsers(ctx, uids)
+  if err != nil {
+    slog.ErrorContext(ctx, "failed to fetch firebase users", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get staffs")
+		return
+  }
+
   for _, staffId := range staffsFirebaseIds {
     staff  := models.Staff{}
 
-    firebaseUser, err := h.FbAuth.GetUserByIdToken(ctx,staffId.FireBaseId)
-    if err != nil { 
-      log.Printf("Failed to fetch Firebase user info for ID %s: %v", staffId.FireBaseId, err)
-      continue 
+    firebaseUser, ok := firebaseUsers[staffId.FireBaseId]
+    if !ok {
+      slog.WarnContext(ctx, "staff has no firebase account", "staff_uid", staffId.FireBaseId)
+      continue
     }
 
     staff.Name = firebaseUser.DisplayName
     staff.FireBaseId = firebaseUser.UID
     staff.ImageUrl = firebaseUser.PhotoURL 
     staff.Email = firebaseUser.Email
-    staff.CanSeeScanned = staffId.CanSeeScanned
-    staff.CanCreateAttendee = staffId.CanCreateAttendee
-    staff.CanSeeAttendee = staffId.CanSeeAttendee
+    staff.Role = staffId.Role
+    staff.Permissions = staffId.Permissions
+    staff.IsCreator = staffId.IsCreator
+    staff.IsOwner = staffId.IsOwner
 
     staffs = append(staffs, staff) 
   }
@@ -134,3 +208,143 @@ func (h *Handler) GetStaffsByEvent(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
   json.NewEncoder(w).Encode(staffs)
 }
+
+/*
+RemoveStaff revokes a staff member's role on an event. They lose access to
+the event on their next request.
+
+Path Params:
+
+	event_id (uuid-string)
+	firebase_id (uid of the staff member)
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request if event_id is invalid
+- 403 Forbidden without the staff.manage permission, or for a co-organizer when the caller is not a creator
+- 404 Not Found if the user has no role on the event
+- 409 Conflict when removing the owner or the last creator
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) RemoveStaff(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventId, staffId := vars["event_id"], vars["firebase_id"]
+
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+	if _, err := uuid.Parse(eventId); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event_id format")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.StaffManage, "You are not authorized to remove staff") {
+		return
+	}
+
+	// staff managers can't remove co-organizers; only another creator can
+	targetIsCreator, err := h.DB.IsCreator(r.Context(), staffId, eventId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(r.Context(), "failed to check creator status", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check creator status")
+		return
+	}
+	if targetIsCreator {
+		callerIsCreator, err := h.DB.IsCreator(r.Context(), fireBaseUser.UID, eventId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to check creator status", "err", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check creator status")
+			return
+		}
+		if !callerIsCreator {
+			utils.RespondWithError(w, http.StatusForbidden, "Only event creators can remove co-organizers")
+			return
+		}
+	}
+
+	h.removeStaff(w, r, eventId, staffId, fireBaseUser.UID)
+}
+
+/*
+LeaveEvent removes the caller's own role on an event. The owner has to
+transfer ownership first, and the last creator can't leave.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request if event_id is invalid
+- 404 Not Found if the caller has no role on the event
+- 409 Conflict for the owner or the last creator
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) LeaveEvent(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+	if _, err := uuid.Parse(eventId); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event_id format")
+		return
+	}
+
+	h.removeStaff(w, r, eventId, fireBaseUser.UID, fireBaseUser.UID)
+}
+
+func (h *Handler) removeStaff(w http.ResponseWriter, r *http.Request, eventId, staffId, removedBy string) {
+	logging.AddAttrs(r.Context(), slog.String("staff_uid", staffId))
+
+	action := models.AuditStaffRemove
+	if staffId == removedBy {
+		action = models.AuditStaffLeave
+	}
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := findStaff(r, tx, eventId, staffId)
+		if err != nil {
+			return err
+		}
+		if err := tx.RemoveStaffFromEvent(r.Context(), eventId, staffId); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId,
+			Action:     action,
+			EntityType: "staff",
+			EntityId:   staffId,
+			Before:     before,
+		})
+	})
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Staff not found on this event")
+	case errors.Is(err, db.ErrIsOwner), errors.Is(err, db.ErrLastCreator):
+		utils.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), "failed to remove staff", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to remove staff")
+	}
+}
+
+// findStaff returns fbId's role and effective permissions on the event, or
+// db.ErrNotFound if they have none.
+func findStaff(r *http.Request, q db.Database, eventId, fbId string) (*models.Staff, error) {
+	staffs, err := q.GetStaffByEvent(r.Context(), eventId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range staffs {
+		if staffs[i].FireBaseId == fbId {
+			return &staffs[i], nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
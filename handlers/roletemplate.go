@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+/*
+GetRoleTemplates lists the roles that can be given out on an event: the
+built-in ones followed by the event's custom roles.
+
+Path Param:
+
+	event_id (uuid-string)
+
+Returns:
+- 200 OK with [{ "name", "description", "permissions": [...], "built_in" }]
+- 403 Forbidden if the caller has no role on the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetRoleTemplates(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	member, err := h.DB.CanSeeEventInfo(r.Context(), fireBaseUser.UID, eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check event access", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
+		return
+	}
+	if !member {
+		utils.RespondWithError(w, http.StatusForbidden, "You are not a member of this event")
+		return
+	}
+
+	roles, err := h.DB.GetRoleTemplates(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get role templates", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get roles")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+/*
+SaveRoleTemplate creates or replaces a custom role on an event. Accepts JSON:
+
+	{
+	  "name": "door-lead",
+	  "description": "string",
+	  "permissions": ["checkin.create", "checkin.undo"]
+	}
+
+Staff already holding the role get the new permissions on their next request.
+Built-in role names can't be reused, and the caller must hold every
+permission in the role.
+
+Returns:
+- 200 OK with the saved role
+- 400 Bad Request for invalid input, an unknown permission or a built-in name
+- 403 Forbidden without the staff.manage permission, or when the role grants more than the caller has
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) SaveRoleTemplate(w http.ResponseWriter, r *http.Request) {
+	eventId := mux.Vars(r)["event_id"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	var req models.RoleTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if permissions.IsBuiltIn(req.Name) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Built-in roles can't be changed")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.StaffManage, "You are not authorized to manage roles") {
+		return
+	}
+	if !h.requireGrantable(w, r, fireBaseUser.UID, eventId, "", req.Permissions) {
+		return
+	}
+
+	role := permissions.Role{Name: req.Name, Description: req.Description, Permissions: req.Permissions}
+	if role.Permissions == nil {
+		role.Permissions = []permissions.Action{}
+	}
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetRoleTemplate(r.Context(), eventId, role.Name)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		if err := tx.SaveRoleTemplate(r.Context(), eventId, role); err != nil {
+			return err
+		}
+		change := auditChange{
+			EventId:    eventId,
+			Action:     models.AuditRoleTemplateSave,
+			EntityType: "role_template",
+			EntityId:   role.Name,
+			After:      role,
+		}
+		if before != nil {
+			change.Before = before
+		}
+		return h.audit(r, tx, change)
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to save role template", "role", role.Name, "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save role")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+/*
+DeleteRoleTemplate removes a custom role from an event.
+
+Path Params:
+
+	event_id (uuid-string)
+	name (role name)
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request for a built-in role
+- 403 Forbidden without the staff.manage permission
+- 404 Not Found if the event has no such custom role
+- 409 Conflict while staff or open invitations still use the role
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) DeleteRoleTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventId, name := vars["event_id"], vars["name"]
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+	if permissions.IsBuiltIn(name) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Built-in roles can't be deleted")
+		return
+	}
+
+	if !h.requirePermission(w, r, fireBaseUser.UID, eventId, permissions.StaffManage, "You are not authorized to manage roles") {
+		return
+	}
+
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetRoleTemplate(r.Context(), eventId, name)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteRoleTemplate(r.Context(), eventId, name); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId,
+			Action:     models.AuditRoleTemplateDelete,
+			EntityType: "role_template",
+			EntityId:   name,
+			Before:     before,
+		})
+	})
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Role not found")
+	case errors.Is(err, db.ErrAlreadyExists):
+		utils.RespondWithError(w, http.StatusConflict, "Role is still assigned to staff or invitations")
+	default:
+		slog.ErrorContext(r.Context(), "failed to delete role template", "role", name, "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete role")
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/features/export"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+// staffStatsJSON is the plain JSON response, offered alongside the export
+// formats; it isn't written through export.
+var staffStatsJSON = export.Format{Name: "json", MediaType: "application/json"}
+
+var staffStatsFormats = []export.Format{staffStatsJSON, export.XLSX, export.CSV, export.NDJSON, export.PDF}
+
+/*
+GetStaffStats reports how each staff member scanned at an event: check-ins
+in total and per activity, duplicate and otherwise rejected attempts, check-ins
+they undid, their first and last scan, and throughput as scans per active
+minute along with their busiest minute. Staff are told apart by Firebase UID;
+check-ins from before UIDs were recorded fall back to the email.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	format  json (the default), xlsx, csv, ndjson or pdf; otherwise picked from the Accept header
+
+Returns:
+- 200 OK with a JSON array of models.StaffStats, or the file as an attachment
+- 400 Bad Request for an invalid id or format
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the event does not exist
+- 406 Not Acceptable if no format matches the Accept header
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetStaffStats(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	format, err := export.NegotiateFrom(r.URL.Query().Get("format"), r.Header.Get("Accept"), staffStatsFormats)
+	if errors.Is(err, export.ErrNotAcceptable) {
+		utils.RespondWithError(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can see staff statistics") {
+		return
+	}
+
+	event, err := h.DB.GetEventByFirebaseUser(r.Context(), fireBaseUser.UID, eventId)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get event", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get event")
+		return
+	}
+
+	stats, err := h.DB.GetStaffStats(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to compute staff stats", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to compute staff statistics")
+		return
+	}
+
+	if format.Name == staffStatsJSON.Name {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+
+	columns, activityColumn := staffStatsColumns(stats)
+	download := export.NewDownload(w, format, export.Filename(event.Name+" staff stats", time.Now(), format))
+	doc, err := format.New(download, export.Table{
+		Title:   event.Name + " - Staff Statistics",
+		Columns: columns,
+	})
+	if err == nil {
+		for _, s := range stats {
+			row := []any{s.Email, s.StaffUID, s.Scans, s.Duplicates, s.Rejected, s.Undos,
+				timeOrNil(s.FirstScan), timeOrNil(s.LastScan), s.ActiveMinutes, s.ScansPerMin, s.PeakPerMin}
+			perActivity := make([]any, len(activityColumn))
+			for i := range perActivity {
+				perActivity[i] = 0
+			}
+			for _, a := range s.Activities {
+				perActivity[activityColumn[a.ActivityID]] = a.Scans
+			}
+			if err = doc.WriteRow(append(row, perActivity...)); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = doc.Close()
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to export staff stats", "format", format.Name, "err", err)
+		if !download.Started() {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Can't export staff statistics")
+		}
+	}
+}
+
+// staffStatsColumns lays out the export: the fixed statistics, then a column
+// for every activity anyone scanned at, in the order they first appear.
+// activityColumn maps each activity to its position after the fixed ones.
+func staffStatsColumns(stats []models.StaffStats) ([]export.Column, map[uuid.UUID]int) {
+	columns := []export.Column{
+		{Key: "email", Title: "Email", Width: 1.8},
+		{Key: "staff_uid", Title: "Staff UID", Width: 1.6},
+		{Key: "scans", Title: "Scans", Width: 0.7},
+		{Key: "duplicates", Title: "Duplicates", Width: 0.8},
+		{Key: "rejected", Title: "Rejected", Width: 0.8},
+		{Key: "undos", Title: "Undos", Width: 0.7},
+		{Key: "first_scan", Title: "First Scan", Width: 1.3},
+		{Key: "last_scan", Title: "Last Scan", Width: 1.3},
+		{Key: "active_minutes", Title: "Active Minutes", Width: 0.9},
+		{Key: "scans_per_minute", Title: "Scans/Minute", Width: 0.9},
+		{Key: "peak_scans_per_minute", Title: "Peak/Minute", Width: 0.9},
+	}
+	activityColumn := map[uuid.UUID]int{}
+	keys := map[string]int{}
+	for _, s := range stats {
+		for _, a := range s.Activities {
+			if _, ok := activityColumn[a.ActivityID]; ok {
+				continue
+			}
+			key := export.Slug(a.Name)
+			if key == "" {
+				key = "activity"
+			}
+			if keys[key]++; keys[key] > 1 {
+				key = key + "-" + a.ActivityID.String()[:8]
+			}
+			activityColumn[a.ActivityID] = len(activityColumn)
+			columns = append(columns, export.Column{Key: key, Title: a.Name, Width: 0.9})
+		}
+	}
+	return columns, activityColumn
+}
+
+// timeOrNil unwraps t for export rows, which take a nil but not a nil *time.Time.
+func timeOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
@@ -1,4 +0,0 @@
-package handlers
-
-var superAdminsEmails = []string{"darwinkoirala123@gmail.com"}
-
@@ -1,16 +1,28 @@
 package handlers
 
 import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/export"
 	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/logging"
+	"github.com/koiraladarwin/scanin/features/permissions"
+	"github.com/koiraladarwin/scanin/features/metrics"
+	"github.com/koiraladarwin/scanin/features/webhooks"
 	"github.com/koiraladarwin/scanin/models"
 	"github.com/koiraladarwin/scanin/utils"
 	"github.com/xuri/excelize/v2"
@@ -37,8 +49,9 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.String("event_id", u.EventId))
 
-	access, err := h.DB.CanCreateAttendee(fireBaseUser.UID, u.EventId)
+	access, err := h.DB.HasPermission(r.Context(), fireBaseUser.UID, u.EventId, permissions.AttendeeWrite)
 
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
@@ -54,7 +67,24 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.DB.CreateUser(&u)
+	var user *models.User
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		var err error
+		user, err = tx.CreateUser(r.Context(), &u)
+		if err != nil {
+			return err
+		}
+		if err := h.notify(r, tx, user.EventId, webhooks.AttendeeCreated, user); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    user.EventId,
+			Action:     models.AuditAttendeeCreate,
+			EntityType: "attendee",
+			EntityId:   user.ID.String(),
+			After:      user,
+		})
+	})
 
 	if errors.Is(err, db.ErrAlreadyExists) {
 		utils.RespondWithError(w, http.StatusConflict, "User Already Exists")
@@ -62,7 +92,7 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		fmt.Print(err.Error())
+		slog.ErrorContext(r.Context(), "failed to create user", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
@@ -84,8 +114,9 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.String("event_id", u.EventId))
 
-	access, err := h.DB.CanCreateAttendee(fireBaseUser.UID, u.EventId)
+	access, err := h.DB.HasPermission(r.Context(), fireBaseUser.UID, u.EventId, permissions.AttendeeWrite)
 
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
@@ -102,15 +133,46 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.DB.UpdateUser(&u)
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetUser(r.Context(), u.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if before.EventId != u.EventId {
+			// the permission check above was for u.EventId
+			return db.ErrNotFound
+		}
+		if err := tx.UpdateUser(r.Context(), &u); err != nil {
+			return err
+		}
+		if err := h.notify(r, tx, u.EventId, webhooks.AttendeeUpdated, u); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    u.EventId,
+			Action:     models.AuditAttendeeUpdate,
+			EntityType: "attendee",
+			EntityId:   u.ID.String(),
+			Before:     before,
+			After:      u,
+		})
+	})
 
 	if errors.Is(err, db.ErrNotFound) {
 		utils.RespondWithError(w, http.StatusNotFound, "User Not Found")
 		return
 	}
 
+	if errors.Is(err, db.ErrAlreadyExists) {
+		utils.RespondWithError(w, http.StatusConflict, "Auto ID already taken for this role")
+		return
+	}
+
 	if err != nil {
-		fmt.Print(err.Error())
+		slog.ErrorContext(r.Context(), "failed to update user", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
@@ -142,7 +204,7 @@ func (h *Handler) GetUsersByEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	access, err := h.DB.CanSeeAttendee(fireBaseUser.UID, eventIDStr)
+	access, err := h.DB.HasPermission(r.Context(), fireBaseUser.UID, eventIDStr, permissions.AttendeeRead)
 
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
@@ -153,7 +215,7 @@ func (h *Handler) GetUsersByEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exists, err := h.DB.EventExists(eventID)
+	exists, err := h.DB.EventExists(r.Context(), eventID)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "database error")
 		return
@@ -164,9 +226,9 @@ func (h *Handler) GetUsersByEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	attendees, err := h.DB.GetUsersByEvent(eventID)
+	attendees, err := h.DB.GetUsersByEvent(r.Context(), eventID)
 	if err != nil {
-    log.Print(err.Error())
+		slog.ErrorContext(r.Context(), "failed to fetch attendees", "err", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "failed to fetch attendees")
 		return
 	}
@@ -175,6 +237,110 @@ func (h *Handler) GetUsersByEvent(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(attendees)
 }
 
+/*
+ExportAttendees downloads an event's attendees as XLSX (the default) or CSV,
+in the same Role, Full Name, Position, Company layout ImportUser reads, so an
+edited export can be imported into another event.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	format  xlsx or csv; otherwise picked from the Accept header
+
+Returns:
+- 200 OK with the file as an attachment
+- 400 Bad Request for an invalid id or format
+- 403 Forbidden without the attendee.read and export.run permissions
+- 404 Not Found if the event does not exist
+- 406 Not Acceptable if no format matches the Accept header
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) ExportAttendees(w http.ResponseWriter, r *http.Request) {
+	fbUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	idStr := mux.Vars(r)["id"]
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	format, err := export.NegotiateFrom(r.URL.Query().Get("format"), r.Header.Get("Accept"), []export.Format{export.XLSX, export.CSV})
+	if errors.Is(err, export.ErrNotAcceptable) {
+		utils.RespondWithError(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.requirePermission(w, r, fbUser.UID, idStr, permissions.AttendeeRead, "You are not authorized to see attendees") ||
+		!h.requirePermission(w, r, fbUser.UID, idStr, permissions.ExportRun, "You are not authorized to export attendees") {
+		return
+	}
+
+	event, err := h.DB.GetEventByFirebaseUser(r.Context(), fbUser.UID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get event", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get event")
+		return
+	}
+
+	attendees, err := h.DB.GetUsersByEvent(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to fetch attendees", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "failed to fetch attendees")
+		return
+	}
+
+	download := export.NewDownload(w, format, export.Filename(event.Name+" attendees", time.Now(), format))
+	doc, err := format.New(download, export.Table{
+		Title:   event.Name + " - Attendees",
+		Columns: attendeeExportColumns,
+	})
+	if err == nil {
+		for _, a := range attendees {
+			if err = doc.WriteRow([]any{a.Role, a.FullName, a.Position, a.Company}); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = doc.Close()
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to export attendees", "format", format.Name, "err", err)
+		if !download.Started() {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Can't export attendees")
+		}
+	}
+}
+
+// attendeeExportColumns is the layout ImportUser expects.
+var attendeeExportColumns = []export.Column{
+	{Key: "role", Title: "Role", Width: 0.8},
+	{Key: "full_name", Title: "Full Name", Width: 1.6},
+	{Key: "position", Title: "Position", Width: 1.3},
+	{Key: "company", Title: "Company", Width: 1.3},
+}
+
+/*
+ImportUser creates attendees from an uploaded spreadsheet (multipart field
+"file"), either XLSX or CSV. The first row is a header; every other row holds
+Role, Full Name, Position, Company, the same layout ExportAttendees writes.
+*/
 func (h *Handler) ImportUser(w http.ResponseWriter, r *http.Request) {
 	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
 	if !ok {
@@ -195,7 +361,7 @@ func (h *Handler) ImportUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	access, err := h.DB.CanCreateAttendee(fireBaseUser.UID, streventID)
+	access, err := h.DB.HasPermission(r.Context(), fireBaseUser.UID, streventID, permissions.AttendeeWrite)
 
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check event access")
@@ -212,39 +378,41 @@ func (h *Handler) ImportUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, _, err := r.FormFile("file")
+	file, header, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "Failed to get uploaded file", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	f, err := excelize.OpenReader(file)
-	if err != nil {
-		http.Error(w, "Failed to read Excel file", http.StatusBadRequest)
-		return
-	}
-
-	sheetName := f.GetSheetName(0)
-	rows, err := f.GetRows(sheetName)
+	rows, err := readImportRows(file, header)
 	if err != nil {
-		http.Error(w, "Failed to read Excel rows", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	var users []models.UserRequest
+	// rowNumbers[i] is the spreadsheet row users[i] came from
+	var rowNumbers []int
 
 	for i, row := range rows {
 		if i == 0 {
 			continue
 		}
 
+		// excel drops trailing empty cells, so an attendee without a company
+		// comes back from our own export one column short
+		if len(row) > 0 && len(row) < 4 {
+			row = append(row, make([]string, 4-len(row))...)
+		}
 		if len(row) != 4 {
 			continue
 		}
 
-		if row[0] == "" {
-			failedLog = append(failedLog, fmt.Sprintf("Failed to create user in row %v username:%s - company:%s - role:%s - position:%s because a field is empty", i+1, row[0], row[1], row[2], row[3]))
+		// the role may be empty, as it is for attendees exported without one
+		if row[1] == "" {
+			metrics.ImportRows.WithLabelValues("failed").Inc()
+			failedLog = append(failedLog, fmt.Sprintf("Failed to create user in row %v role:%s - full name:%s - position:%s - company:%s because the full name is empty", i+1, row[0], row[1], row[2], row[3]))
 			continue
 		}
 
@@ -255,23 +423,71 @@ func (h *Handler) ImportUser(w http.ResponseWriter, r *http.Request) {
 			Company:  row[3],
 		}
 		users = append(users, user)
+		rowNumbers = append(rowNumbers, i+1)
 	}
 
 	for i, user := range users {
-		user, err := h.DB.CreateUser(&models.UserRequest{
-			FullName:  user.FullName,
-			Company:   user.Company,
-			Position:  user.Position,
-			Image_url: "https://res.cloudinary.com/dcvr2byrp/image/upload/v1753007426/qocwao1uaykjjnkzqxvo.jpg",
-			Role:      user.Role,
-			EventId:   eventID.String(),
+		err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+			created, err := tx.CreateUser(r.Context(), &models.UserRequest{
+				FullName:  user.FullName,
+				Company:   user.Company,
+				Position:  user.Position,
+				Image_url: h.Config.DefaultAvatarURL,
+				Role:      user.Role,
+				EventId:   eventID.String(),
+			})
+			if err != nil {
+				return err
+			}
+			if err := h.notify(r, tx, created.EventId, webhooks.AttendeeCreated, created); err != nil {
+				return err
+			}
+			return h.audit(r, tx, auditChange{
+				EventId:    created.EventId,
+				Action:     models.AuditAttendeeCreate,
+				EntityType: "attendee",
+				EntityId:   created.ID.String(),
+				After:      created,
+			})
 		})
 		if err != nil {
-			failedLog = append(failedLog, fmt.Sprintf("Failed to create user in row %v username:%s - company:%s - role:%s - position:%s because %v", i+2, user.FullName, user.Company, user.Role, user.Position, err.Error()))
+			metrics.ImportRows.WithLabelValues("failed").Inc()
+			failedLog = append(failedLog, fmt.Sprintf("Failed to create user in row %v role:%s - full name:%s - position:%s - company:%s because %v", rowNumbers[i], user.Role, user.FullName, user.Position, user.Company, err.Error()))
 			continue
 		}
+		metrics.ImportRows.WithLabelValues("processed").Inc()
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(failedLog)
 
 }
+
+// readImportRows returns every row of an uploaded attendee sheet. CSV is
+// recognised by extension or content type, anything else is read as XLSX.
+func readImportRows(file multipart.File, header *multipart.FileHeader) ([][]string, error) {
+	if strings.EqualFold(filepath.Ext(header.Filename), "."+export.CSV.Extension) ||
+		strings.HasPrefix(header.Header.Get("Content-Type"), export.CSV.MediaType) {
+		// Excel adds a byte order mark, and so does our own export
+		br := bufio.NewReader(file)
+		if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+			br.Discard(3)
+		}
+		cr := csv.NewReader(br)
+		cr.FieldsPerRecord = -1
+		rows, err := cr.ReadAll()
+		if err != nil {
+			return nil, errors.New("Failed to read CSV file")
+		}
+		return rows, nil
+	}
+
+	f, err := excelize.OpenReader(file)
+	if err != nil {
+		return nil, errors.New("Failed to read Excel file")
+	}
+	rows, err := f.GetRows(f.GetSheetName(0))
+	if err != nil {
+		return nil, errors.New("Failed to read Excel rows")
+	}
+	return rows, nil
+}
@@ -0,0 +1,586 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/koiraladarwin/scanin/database"
+	"github.com/koiraladarwin/scanin/features/firebaseauth"
+	"github.com/koiraladarwin/scanin/features/webhooks"
+	"github.com/koiraladarwin/scanin/models"
+	"github.com/koiraladarwin/scanin/utils"
+)
+
+// notify queues a webhook delivery of data to the event's endpoints
+// subscribed to eventType. Pass the transaction the change was written in,
+// so nothing is sent for a change that rolls back.
+func (h *Handler) notify(r *http.Request, q db.Database, eventId, eventType string, data any) error {
+	if h.Webhooks == nil {
+		return nil
+	}
+	payload, err := webhooks.Payload(eventId, eventType, data, time.Now())
+	if err != nil {
+		return err
+	}
+	return q.EnqueueWebhook(r.Context(), eventId, eventType, payload)
+}
+
+/*
+GetWebhooks lists an event's webhook endpoints, without their secrets.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 200 OK with a JSON array of models.Webhook
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage webhooks") {
+		return
+	}
+
+	hooks, err := h.DB.GetWebhooks(r.Context(), eventId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get webhooks", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get webhooks")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+/*
+CreateWebhook accepts JSON:
+
+	{
+	  "url": "https://example.com/scanin",
+	  "event_types": ["checkin.created", "checkin.reverted", "attendee.created", "attendee.updated", "activity.updated"],
+	  "enabled": true
+	}
+
+Each change of a subscribed type is POSTed to url as a JSON envelope signed
+with the secret; see package webhooks for the headers and how to verify
+them. Failed deliveries are retried with exponential backoff. url must
+resolve to public addresses; loopback and private networks are refused.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Returns:
+- 201 Created with the webhook JSON, the only time the secret is shown besides rotating it
+- 400 Bad Request for invalid input or a url on a private address
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage webhooks") {
+		return
+	}
+
+	hook := &models.Webhook{
+		EventID:   eventId,
+		Enabled:   true,
+		CreatedBy: fireBaseUser.UID,
+	}
+	if err := applyWebhookRequest(r.Context(), hook, req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hook.Secret, err = webhooks.NewSecret()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate webhook secret", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		if err := tx.CreateWebhook(r.Context(), hook); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditWebhookCreate,
+			EntityType: "webhook",
+			EntityId:   hook.ID.String(),
+			After:      webhookAuditState(hook),
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create webhook", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+/*
+UpdateWebhook accepts the same JSON as CreateWebhook; fields left out keep
+their value. Deliveries already queued are still sent unless the webhook is
+disabled, in which case they wait until it is enabled again.
+
+Path Params:
+
+	id         (uuid-string of the event)
+	webhook_id (uuid-string)
+
+Returns:
+- 200 OK with the updated webhook JSON, without the secret
+- 400 Bad Request for invalid input
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the webhook does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, webhookId, ok := parseWebhookIds(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage webhooks") {
+		return
+	}
+
+	var hook models.Webhook
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetWebhook(r.Context(), webhookId)
+		if err != nil {
+			return err
+		}
+		if before.EventID != eventId {
+			return db.ErrNotFound
+		}
+		hook = *before
+		if err := applyWebhookRequest(r.Context(), &hook, req); err != nil {
+			return err
+		}
+		if err := tx.UpdateWebhook(r.Context(), &hook); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditWebhookUpdate,
+			EntityType: "webhook",
+			EntityId:   hook.ID.String(),
+			Before:     webhookAuditState(before),
+			After:      webhookAuditState(&hook),
+		})
+	})
+	var invalid webhookRequestError
+	switch {
+	case err == nil:
+		hook.Secret = ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hook)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Webhook not found")
+	case errors.As(err, &invalid):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), "failed to update webhook", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update webhook")
+	}
+}
+
+/*
+RotateWebhookSecret replaces a webhook's signing secret. Deliveries are
+signed with the new one from their next attempt on.
+
+Path Params:
+
+	id         (uuid-string of the event)
+	webhook_id (uuid-string)
+
+Returns:
+- 200 OK with the webhook JSON, new secret included
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the webhook does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) RotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, webhookId, ok := parseWebhookIds(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage webhooks") {
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate webhook secret", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to rotate webhook secret")
+		return
+	}
+
+	var hook *models.Webhook
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		var err error
+		hook, err = tx.GetWebhook(r.Context(), webhookId)
+		if err != nil {
+			return err
+		}
+		if hook.EventID != eventId {
+			return db.ErrNotFound
+		}
+		if err := tx.RotateWebhookSecret(r.Context(), webhookId, secret); err != nil {
+			return err
+		}
+		hook.Secret = secret
+		// the secret itself stays out of the audit log
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditWebhookRotateSecret,
+			EntityType: "webhook",
+			EntityId:   webhookId.String(),
+		})
+	})
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hook)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Webhook not found")
+	default:
+		slog.ErrorContext(r.Context(), "failed to rotate webhook secret", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to rotate webhook secret")
+	}
+}
+
+/*
+DeleteWebhook removes a webhook along with its delivery log; queued
+deliveries are dropped.
+
+Path Params:
+
+	id         (uuid-string of the event)
+	webhook_id (uuid-string)
+
+Returns:
+- 204 No Content on success
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the webhook does not exist
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, webhookId, ok := parseWebhookIds(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage webhooks") {
+		return
+	}
+
+	err := h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		before, err := tx.GetWebhook(r.Context(), webhookId)
+		if err != nil {
+			return err
+		}
+		if before.EventID != eventId {
+			return db.ErrNotFound
+		}
+		if err := tx.DeleteWebhook(r.Context(), webhookId); err != nil {
+			return err
+		}
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditWebhookDelete,
+			EntityType: "webhook",
+			EntityId:   webhookId.String(),
+			Before:     webhookAuditState(before),
+		})
+	})
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, db.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Webhook not found")
+	default:
+		slog.ErrorContext(r.Context(), "failed to delete webhook", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete webhook")
+	}
+}
+
+/*
+GetWebhookDeliveries lists an event's webhook deliveries, newest first, with
+the payload and the outcome of the latest attempt.
+
+Path Param:
+
+	id (uuid-string of the event)
+
+Query Params (optional):
+
+	webhook_id  only this webhook's deliveries
+	status      pending, delivered or failed
+	limit       how many, 50 by default and at most 500
+
+Returns:
+- 200 OK with a JSON array of models.WebhookDelivery
+- 400 Bad Request for an invalid id, status or limit
+- 403 Forbidden if the user did not create the event
+- 500 Internal Server Error on DB failure
+*/
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	eventId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := models.WebhookDeliveryFilter{Status: q.Get("status"), Limit: defaultDeliveryLimit}
+	if v := q.Get("webhook_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid webhook_id format")
+			return
+		}
+		filter.WebhookID = &id
+	}
+	switch filter.Status {
+	case "", models.WebhookPending, models.WebhookDelivered, models.WebhookFailed:
+	default:
+		utils.RespondWithError(w, http.StatusBadRequest, "status must be pending, delivered or failed")
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxDeliveryLimit {
+			utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLimit))
+			return
+		}
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage webhooks") {
+		return
+	}
+
+	deliveries, err := h.DB.GetWebhookDeliveries(r.Context(), eventId, filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get webhook deliveries", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get webhook deliveries")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+/*
+RedeliverWebhook sends a delivery again right away, whatever its status,
+with the same payload and a fresh set of retries should it fail.
+
+Path Params:
+
+	id          (uuid-string of the event)
+	delivery_id (integer)
+
+Returns:
+- 200 OK with the delivery JSON after the attempt
+- 400 Bad Request for an invalid id
+- 403 Forbidden if the user did not create the event
+- 404 Not Found if the delivery does not exist
+- 500 Internal Server Error on DB failure
+- 503 Service Unavailable if webhooks are turned off
+*/
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	fireBaseUser, ok := firebaseauth.FbUserFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: no user in context")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return
+	}
+	deliveryId, err := strconv.ParseInt(vars["delivery_id"], 10, 64)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid delivery id format")
+		return
+	}
+
+	if !h.requireCreator(w, r, fireBaseUser.UID, eventId.String(), "Only event creators can manage webhooks") {
+		return
+	}
+	if h.Webhooks == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Webhooks are turned off")
+		return
+	}
+
+	delivery, err := h.DB.GetWebhookDelivery(r.Context(), deliveryId)
+	if errors.Is(err, db.ErrNotFound) || err == nil && delivery.EventID != eventId {
+		utils.RespondWithError(w, http.StatusNotFound, "Webhook delivery not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get webhook delivery", "err", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get webhook delivery")
+		return
+	}
+
+	before := delivery
+	delivery, redeliverErr := h.Webhooks.Redeliver(r.Context(), deliveryId)
+
+	// the request has gone out whatever happens here, so a failure to record
+	// it is only logged
+	err = h.DB.WithTx(r.Context(), func(tx db.Database) error {
+		return h.audit(r, tx, auditChange{
+			EventId:    eventId.String(),
+			Action:     models.AuditWebhookRedeliver,
+			EntityType: "webhook_delivery",
+			EntityId:   strconv.FormatInt(deliveryId, 10),
+			Before:     before,
+			After:      delivery,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to audit webhook redelivery", "err", err)
+	}
+
+	if redeliverErr != nil {
+		slog.ErrorContext(r.Context(), "failed to redeliver webhook", "err", redeliverErr)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to redeliver webhook")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// webhookRequestError is invalid webhook input, reported as a 400.
+type webhookRequestError struct{ msg string }
+
+func (e webhookRequestError) Error() string { return e.msg }
+
+// applyWebhookRequest copies the fields set in req onto hook and validates
+// the result. A new URL must resolve to public addresses only.
+func applyWebhookRequest(ctx context.Context, hook *models.Webhook, req models.WebhookRequest) error {
+	if req.URL != "" {
+		if err := webhooks.ValidateURL(ctx, req.URL); err != nil {
+			return webhookRequestError{err.Error()}
+		}
+		hook.URL = req.URL
+	}
+	if req.EventTypes != nil {
+		hook.EventTypes = slices.Compact(slices.Sorted(slices.Values(req.EventTypes)))
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+
+	if hook.URL == "" {
+		return webhookRequestError{"url must be an absolute http or https URL"}
+	}
+	if err := webhooks.ValidateTypes(hook.EventTypes); err != nil {
+		return webhookRequestError{err.Error()}
+	}
+	return nil
+}
+
+// webhookAuditState is a webhook as recorded in the audit log, without its
+// secret.
+func webhookAuditState(hook *models.Webhook) models.Webhook {
+	state := *hook
+	state.Secret = ""
+	return state
+}
+
+func parseWebhookIds(w http.ResponseWriter, r *http.Request) (eventId, webhookId uuid.UUID, ok bool) {
+	vars := mux.Vars(r)
+	eventId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid event id format")
+		return eventId, webhookId, false
+	}
+	webhookId, err = uuid.Parse(vars["webhook_id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid webhook id format")
+		return eventId, webhookId, false
+	}
+	return eventId, webhookId, true
+}
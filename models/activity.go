@@ -13,6 +13,26 @@ type Activity struct {
 	StartTime           time.Time `json:"start_time"`
 	EndTime             time.Time `json:"end_time"`
 	NumberOfScanedUsers int       `json:"number_of_scaned_users"`
+	Credits             float64   `json:"credits"`
+	CreditRule          string    `json:"credit_rule"`
+	MinDwellPercent     int       `json:"min_dwell_percent"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	// Sequence goes up each time the start or end time changes.
+	Sequence int `json:"-"`
+}
+
+// ActivityUpdateRequest is the body of an activity update. The credit
+// settings are optional: clients that predate them leave them out, and the
+// activity keeps its current values.
+type ActivityUpdateRequest struct {
+	ID              uuid.UUID `json:"id"`
+	Name            string    `json:"name"`
+	Type            string    `json:"type"`
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	Credits         *float64  `json:"credits"`
+	CreditRule      *string   `json:"credit_rule"`
+	MinDwellPercent *int      `json:"min_dwell_percent"`
 }
 
 type ActivityCreateRequest struct {
@@ -22,6 +42,7 @@ type ActivityCreateRequest struct {
 	StartTime           time.Time `json:"start_time"`
 	EndTime             time.Time `json:"end_time"`
 	NumberOfScanedUsers int       `json:"number_of_scaned_users"`
+	Credits             float64   `json:"credits"`
+	CreditRule          string    `json:"credit_rule"`
+	MinDwellPercent     int       `json:"min_dwell_percent"`
 }
-
-
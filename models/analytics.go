@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventAnalytics summarises how an event's check-ins went. Only standing
+// check-ins count; undone ones are left out everywhere.
+type EventAnalytics struct {
+	EventID         uuid.UUID          `json:"event_id"`
+	Interval        string             `json:"interval"`
+	Registered      int                `json:"registered"`
+	UniqueAttendees int                `json:"unique_attendees"`
+	NoShowRate      float64            `json:"no_show_rate"`
+	TotalCheckIns   int                `json:"total_check_ins"`
+	PeakArrival     *PeakArrival       `json:"peak_arrival"`
+	Timeline        []ActivityTimeline `json:"timeline"`
+	ByStaff         []StaffScans       `json:"by_staff"`
+	ByRole          []GroupAttendance  `json:"by_role"`
+	ByCompany       []GroupAttendance  `json:"by_company"`
+	Repeat          []RepeatAttendance `json:"repeat_attendance"`
+}
+
+// PeakArrival is the minute the most attendees had their first scan of the
+// event in.
+type PeakArrival struct {
+	Minute   time.Time `json:"minute"`
+	Arrivals int       `json:"arrivals"`
+}
+
+// ActivityTimeline is an activity's check-ins per interval; intervals
+// without any are left out.
+type ActivityTimeline struct {
+	ActivityID uuid.UUID    `json:"activity_id"`
+	Name       string       `json:"name"`
+	Buckets    []TimeBucket `json:"buckets"`
+}
+
+type TimeBucket struct {
+	Start    time.Time `json:"start"`
+	CheckIns int       `json:"check_ins"`
+}
+
+type StaffScans struct {
+	ScannedByUID string `json:"scanned_by_uid"`
+	ScannedBy    string `json:"scanned_by"`
+	Scans        int    `json:"scans"`
+}
+
+// GroupAttendance compares registered and attending attendees within a
+// role or company.
+type GroupAttendance struct {
+	Name       string `json:"name"`
+	Registered int    `json:"registered"`
+	Attended   int    `json:"attended"`
+}
+
+// RepeatAttendance is how many attendees checked into exactly Activities
+// activities.
+type RepeatAttendance struct {
+	Activities int `json:"activities"`
+	Attendees  int `json:"attendees"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/features/permissions"
+)
+
+// APIKey lets an integration act on one event without a Firebase account.
+// Only a hash of the key is stored; Token is filled in once, when the key is
+// created, and Prefix identifies it afterwards.
+type APIKey struct {
+	ID         uuid.UUID            `json:"id"`
+	EventID    uuid.UUID            `json:"event_id"`
+	Name       string               `json:"name"`
+	Prefix     string               `json:"prefix"`
+	Token      string               `json:"token,omitempty"`
+	Scopes     []permissions.Action `json:"scopes"`
+	ExpiresAt  *time.Time           `json:"expires_at"`
+	LastUsedAt *time.Time           `json:"last_used_at"`
+	CreatedBy  string               `json:"created_by"`
+	CreatedAt  time.Time            `json:"created_at"`
+	RevokedAt  *time.Time           `json:"revoked_at"`
+}
+
+type APIKeyRequest struct {
+	Name      string               `json:"name"`
+	Scopes    []permissions.Action `json:"scopes"`
+	ExpiresAt *time.Time           `json:"expires_at"`
+}
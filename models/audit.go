@@ -0,0 +1,78 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit actions are named <entity>.<verb>.
+const (
+	AuditAttendeeCreate      = "attendee.create"
+	AuditAttendeeUpdate      = "attendee.update"
+	AuditEventCreate         = "event.create"
+	AuditEventUpdate         = "event.update"
+	AuditEventRotateCodes    = "event.rotate_codes"
+	AuditEventRotateCalendar = "event.rotate_calendar_token"
+	AuditEventTransferOwner  = "event.transfer_ownership"
+	AuditBadgeSettingsUpdate = "badge_settings.update"
+	AuditBadgeLayoutUpdate   = "badge_layout.update"
+	AuditCertificateUpdate   = "certificate_template.update"
+	AuditActivityCreate      = "activity.create"
+	AuditActivityUpdate      = "activity.update"
+	AuditCheckInCreate       = "checkin.create"
+	AuditCheckInUpdate       = "checkin.update"
+	AuditCheckInCheckOut     = "checkin.check_out"
+	AuditStaffJoin           = "staff.join"
+	AuditStaffGrant          = "staff.grant"
+	AuditStaffUpdate         = "staff.update"
+	AuditStaffRemove         = "staff.remove"
+	AuditStaffLeave          = "staff.leave"
+	AuditInvitationCreate    = "invitation.create"
+	AuditInvitationRevoke    = "invitation.revoke"
+	AuditRoleTemplateSave    = "role_template.save"
+	AuditRoleTemplateDelete  = "role_template.delete"
+	AuditReportCreate        = "report_schedule.create"
+	AuditReportUpdate        = "report_schedule.update"
+	AuditReportDelete        = "report_schedule.delete"
+	AuditReportSend          = "report_schedule.send"
+	AuditReportSubscribe     = "report_subscription.subscribe"
+	AuditReportUnsubscribe   = "report_subscription.unsubscribe"
+	AuditWebhookCreate       = "webhook.create"
+	AuditWebhookUpdate       = "webhook.update"
+	AuditWebhookDelete       = "webhook.delete"
+	AuditWebhookRotateSecret = "webhook.rotate_secret"
+	AuditWebhookRedeliver    = "webhook_delivery.redeliver"
+	AuditAPIKeyCreate        = "api_key.create"
+	AuditAPIKeyRevoke        = "api_key.revoke"
+)
+
+// AuditEntry is one state change. Before and After hold the entity as JSON
+// and are null for creations and deletions respectively.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	EventId    string          `json:"event_id"`
+	ActorUID   string          `json:"actor_uid"`
+	ActorEmail string          `json:"actor_email"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityId   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"user_agent"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows an event's audit log. Empty fields match everything;
+// BeforeID pages backwards from the entry with that id.
+type AuditFilter struct {
+	EventId    string
+	ActorUID   string
+	Action     string
+	EntityType string
+	EntityId   string
+	From       *time.Time
+	To         *time.Time
+	BeforeID   int64
+	Limit      int
+}
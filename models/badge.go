@@ -0,0 +1,18 @@
+package models
+
+type BadgeSettings struct {
+	EventId     string            `json:"event_id"`
+	StartNumber int               `json:"start_number"`
+	Padding     int               `json:"padding"`
+	Prefixes    map[string]string `json:"prefixes"`
+}
+
+// BadgeLayout is how an event's printable badge sheet looks: Columns x Rows
+// badges per A4 page, each with a band in its attendee role's color.
+type BadgeLayout struct {
+	EventId      string            `json:"event_id"`
+	Columns      int               `json:"columns"`
+	Rows         int               `json:"rows"`
+	DefaultColor string            `json:"default_color"`
+	RoleColors   map[string]string `json:"role_colors"`
+}
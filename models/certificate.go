@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CertificateTemplate is what every attendance certificate of an event
+// shares. Logo is a PNG or JPEG image, base64 encoded in JSON.
+type CertificateTemplate struct {
+	EventId        string `json:"event_id"`
+	Title          string `json:"title"`
+	Signatory      string `json:"signatory"`
+	SignatoryTitle string `json:"signatory_title"`
+	Logo           []byte `json:"logo,omitempty"`
+}
+
+// AttendedActivity is an activity an attendee has a standing check-in for.
+type AttendedActivity struct {
+	UserId    uuid.UUID
+	Name      string
+	StartTime time.Time
+	EndTime   time.Time
+}
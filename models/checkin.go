@@ -6,28 +6,66 @@ import (
 )
 
 type CheckInLog struct {
-	ID         uuid.UUID `json:"id"`
-	UserID     uuid.UUID `json:"user_id"`
-	ActivityID uuid.UUID `json:"activity_id"`
-	ScannedAt  time.Time `json:"scanned_at"`
-	Status     string    `json:"status"`
-	ScannedBy  string    `json:"scanned_by"`
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	ActivityID   uuid.UUID  `json:"activity_id"`
+	ScannedAt    time.Time  `json:"scanned_at"`
+	Status       string     `json:"status"`
+	ScannedBy    string     `json:"scanned_by"`
+	ScannedByUID string     `json:"scanned_by_uid"`
+	CheckedOutAt *time.Time `json:"checked_out_at,omitempty"`
 }
 
 type CheckInRespose struct {
-	ID           uuid.UUID `json:"id"`
-	FullName     string    `json:"full_name"`
-	AutoId       string    `json:"auto_id"`
-	Role         string    `json:"role"`
-	UserID       uuid.UUID `json:"user_id"`
-	ActivityName string    `json:"activity_name"`
-	ActivityID   uuid.UUID `json:"activity_id"`
-	ScannedAt    time.Time `json:"scanned_at"`
-	Status       string    `json:"status"`
-	ScannedBy    string    `json:"scanned_by"`
+	ID            uuid.UUID `json:"id"`
+	FullName      string    `json:"full_name"`
+	AutoId        string    `json:"auto_id"`
+	Role          string    `json:"role"`
+	UserID        uuid.UUID `json:"user_id"`
+	ActivityName  string    `json:"activity_name"`
+	ActivityID    uuid.UUID `json:"activity_id"`
+	ScannedAt     time.Time `json:"scanned_at"`
+	Status        string    `json:"status"`
+	ScannedBy     string    `json:"scanned_by"`
+	ScannedByUID  string    `json:"scanned_by_uid"`
+	Company       string    `json:"company"`
+	BadgeNumber   string    `json:"badge_number"`
+	ActivityStart time.Time `json:"activity_start"`
+	ActivityEnd   time.Time `json:"activity_end"`
+}
+
+// CheckInFilter narrows the check-ins of an event; empty fields match
+// everything. From is inclusive and To exclusive.
+type CheckInFilter struct {
+	ActivityID *uuid.UUID
+	From       *time.Time
+	To         *time.Time
+	Status     string
+	Role       string
+	ScannedBy  string
+	// ScannedByUID is the Firebase UID of the scanner, which unlike the
+	// email in ScannedBy never changes.
+	ScannedByUID string
 }
 
 type CheckInLogRequest struct {
 	UserID     uuid.UUID `json:"attendee_id"`
 	ActivityID uuid.UUID `json:"activity_id"`
 }
+
+const (
+	// ScanDuplicate is a scan of an attendee already checked in.
+	ScanDuplicate = "duplicate"
+	// ScanForbidden is a scan by staff not allowed to check in to the activity.
+	ScanForbidden = "forbidden"
+)
+
+// RejectedScan is a scan attempt that did not check anyone in.
+type RejectedScan struct {
+	EventID    uuid.UUID
+	ActivityID uuid.UUID
+	AttendeeID uuid.UUID
+	StaffUID   string
+	StaffEmail string
+	Reason     string
+}
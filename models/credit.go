@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreditScan is a standing check-in as far as credits are concerned: when
+// the attendee was scanned in and, if they were, out again.
+type CreditScan struct {
+	UserID       uuid.UUID
+	ActivityID   uuid.UUID
+	CheckedInAt  time.Time
+	CheckedOutAt *time.Time
+}
+
+// CreditLine is one credit-bearing activity on an attendee's transcript.
+type CreditLine struct {
+	ActivityID   uuid.UUID  `json:"activity_id"`
+	Name         string     `json:"name"`
+	StartTime    time.Time  `json:"start_time"`
+	EndTime      time.Time  `json:"end_time"`
+	Credits      float64    `json:"credits"`
+	CreditRule   string     `json:"credit_rule"`
+	CheckedInAt  *time.Time `json:"checked_in_at,omitempty"`
+	CheckedOutAt *time.Time `json:"checked_out_at,omitempty"`
+	DwellPercent *float64   `json:"dwell_percent,omitempty"`
+	Earned       float64    `json:"earned"`
+	// Reason says why nothing was earned; empty when Earned is the full credit.
+	Reason string `json:"reason,omitempty"`
+}
+
+type CreditTranscript struct {
+	AttendeeID       uuid.UUID    `json:"attendee_id"`
+	FullName         string       `json:"full_name"`
+	BadgeNumber      string       `json:"badge_number"`
+	TotalCredits     float64      `json:"total_credits"`
+	AvailableCredits float64      `json:"available_credits"`
+	Activities       []CreditLine `json:"activities"`
+}
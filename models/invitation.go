@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koiraladarwin/scanin/features/permissions"
+)
+
+// InvitationCodeLength sets invitation codes apart from the 6 character staff
+// and 7 character admin codes on the shared redemption route.
+const InvitationCodeLength = 12
+
+type Invitation struct {
+	ID          uuid.UUID            `json:"id"`
+	EventId     uuid.UUID            `json:"event_id"`
+	Code        string               `json:"code"`
+	Email       *string              `json:"email"`
+	Role        string               `json:"role"`
+	Permissions []permissions.Action `json:"permissions"`
+	MaxUses     int                  `json:"max_uses"`
+	Uses        int                  `json:"uses"`
+	ExpiresAt   time.Time            `json:"expires_at"`
+	CreatedBy   string               `json:"created_by"`
+	CreatedAt   time.Time            `json:"created_at"`
+	RevokedAt   *time.Time           `json:"revoked_at"`
+}
+
+type InvitationCreateRequest struct {
+	EventId     uuid.UUID            `json:"event_id"`
+	Email       string               `json:"email"`
+	Role        string               `json:"role"`
+	Permissions []permissions.Action `json:"permissions"`
+	MaxUses     int                  `json:"max_uses"`
+	ExpiresAt   time.Time            `json:"expires_at"`
+}
+
+type EventCodes struct {
+	StaffCode string `json:"staff_code"`
+	AdminCode string `json:"admin_code"`
+}
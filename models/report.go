@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Report kinds.
+const (
+	// ReportSummary is an attendance summary sent on a cron schedule, e.g.
+	// daily while the event runs.
+	ReportSummary = "summary"
+	// ReportFinal is sent once, when the event ends.
+	ReportFinal = "final"
+)
+
+// Delivery statuses.
+const (
+	DeliverySent   = "sent"
+	DeliveryFailed = "failed"
+)
+
+// ReportSchedule emails an event's report to the creators subscribed to it.
+// Cron is evaluated in TimeZone; final reports have no Cron. NextRunAt is
+// nil once a final report has gone out or when nothing is left to fire.
+type ReportSchedule struct {
+	ID        uuid.UUID  `json:"id"`
+	EventID   uuid.UUID  `json:"event_id"`
+	Kind      string     `json:"kind"`
+	Cron      string     `json:"cron"`
+	TimeZone  string     `json:"time_zone"`
+	Format    string     `json:"format"`
+	Enabled   bool       `json:"enabled"`
+	NextRunAt *time.Time `json:"next_run_at"`
+	LastRunAt *time.Time `json:"last_run_at"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ReportScheduleRequest struct {
+	Kind     string `json:"kind"`
+	Cron     string `json:"cron"`
+	TimeZone string `json:"time_zone"`
+	Format   string `json:"format"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+// DueReport is a schedule the scheduler claimed, with the event it reports on.
+type DueReport struct {
+	Schedule ReportSchedule
+	Event    Event
+}
+
+// ReportDelivery records one email of a report to one recipient.
+type ReportDelivery struct {
+	ID         int64      `json:"id"`
+	EventID    uuid.UUID  `json:"event_id"`
+	ScheduleID *uuid.UUID `json:"schedule_id"`
+	Kind       string     `json:"kind"`
+	Recipient  string     `json:"recipient"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
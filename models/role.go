@@ -1,26 +1,19 @@
 package models
 
-type RoleRequest struct {
-	EventId        string `json:"event_id"`
-	FireBaseId     string `json:"firebase_id"`
-	CanAddAttendee bool   `json:"can_add_attendee"`
-	CanSeeAttendee bool   `json:"can_see_attendee"`
-	CanSeeScanned  bool   `json:"can_see_scanned"`
-}
+import "github.com/koiraladarwin/scanin/features/permissions"
 
-type EditRoleRequest struct {
-	FireBaseId     string `json:"firebase_id"`
-	EventId        string `json:"event_id"`
-	CanAddAttendee bool   `json:"can_add_attendee"`
-	CanSeeAttendee bool   `json:"can_see_attendee"`
-	CanSeeScanned  bool   `json:"can_see_scanned"`
+// RoleRequest assigns a staff member a role template and, optionally,
+// permissions on top of it. Either may be empty.
+type RoleRequest struct {
+	EventId     string               `json:"event_id"`
+	FireBaseId  string               `json:"firebase_id"`
+	Role        string               `json:"role"`
+	Permissions []permissions.Action `json:"permissions"`
 }
 
-type Role struct {
-	ID                string `json:"id"`
-	IsCreator         bool   `json:"is_creator"`
-	CanSeeScanned     bool   `json:"can_see_scanned"`
-	CanCreateActivity bool   `json:"can_create_activity"`
-	CanCreateAttendee bool   `json:"can_create_attendee"`
-	CanSeeAttendee    bool   `json:"can_see_attendee"`
+// RoleTemplateRequest creates or replaces a custom role on an event.
+type RoleTemplateRequest struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Permissions []permissions.Action `json:"permissions"`
 }
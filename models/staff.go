@@ -1,11 +1,21 @@
 package models
 
+import "github.com/koiraladarwin/scanin/features/permissions"
+
 type Staff struct {
-	FireBaseId     string `json:"firebase_id"`
-	Name           string `json:"name"`
-	Email          string `json:"email"`
-	ImageUrl       string `json:"image_url"`
-	CanSeeScanned  bool   `json:"can_see_scanned"`
-	CanCreateAttendee bool   `json:"can_add_attendee"`
-	CanSeeAttendee bool   `json:"can_see_attendee"`
+	FireBaseId string `json:"firebase_id"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	ImageUrl   string `json:"image_url"`
+	Role       string `json:"role"`
+	// Permissions are the effective ones: the role's plus any granted
+	// directly, or every permission for creators.
+	Permissions []permissions.Action `json:"permissions"`
+	IsCreator   bool                 `json:"is_creator"`
+	IsOwner     bool                 `json:"is_owner"`
+}
+
+type TransferOwnershipRequest struct {
+	EventId    string `json:"event_id"`
+	FireBaseId string `json:"firebase_id"`
 }
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StaffStats is how one staff member scanned at an event. StaffUID is empty
+// for scans recorded before scanners were identified by UID.
+type StaffStats struct {
+	StaffUID      string          `json:"staff_uid"`
+	Email         string          `json:"email"`
+	Scans         int             `json:"scans"`
+	Duplicates    int             `json:"duplicates"`
+	Rejected      int             `json:"rejected"`
+	Undos         int             `json:"undos"`
+	FirstScan     *time.Time      `json:"first_scan"`
+	LastScan      *time.Time      `json:"last_scan"`
+	ActiveMinutes int             `json:"active_minutes"`
+	ScansPerMin   float64         `json:"scans_per_minute"`
+	PeakPerMin    int             `json:"peak_scans_per_minute"`
+	Activities    []ActivityScans `json:"activities"`
+}
+
+type ActivityScans struct {
+	ActivityID uuid.UUID `json:"activity_id"`
+	Name       string    `json:"name"`
+	Scans      int       `json:"scans"`
+}
@@ -3,14 +3,15 @@ package models
 import "github.com/google/uuid"
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	FullName  string    `json:"full_name"`
-	Company   string    `json:"company"`
-	Position  string    `json:"position"`
-	Image_url string    `json:"image_url"`
-	AutoId    int       `json:"auto_id"`
-	EventId   string    `json:"event_id"`
-	Role      string    `json:"role"`
+	ID          uuid.UUID `json:"id"`
+	FullName    string    `json:"full_name"`
+	Company     string    `json:"company"`
+	Position    string    `json:"position"`
+	Image_url   string    `json:"image_url"`
+	AutoId      int       `json:"auto_id"`
+	BadgeNumber string    `json:"badge_number"`
+	EventId     string    `json:"event_id"`
+	Role        string    `json:"role"`
 }
 
 type UserModifyRequest struct {
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the address the request came from. trustedProxies is how
+// many proxies in front of the service append to X-Forwarded-For; the entry
+// the outermost of them added is the client, and anything to its left was
+// sent by the client and can't be trusted. With none, or no header, it is
+// the connection's address.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		if len(hops) > 0 {
+			i := max(len(hops)-trustedProxies, 0)
+			if ip := strings.TrimSpace(hops[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JSONArrayStream writes a JSON array to the response one element at a time.
+// Headers are only sent on the first Write (or on Close for an empty array),
+// so an error before any row is read can still become a proper error response.
+type JSONArrayStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	started bool
+}
+
+func NewJSONArrayStream(w http.ResponseWriter) *JSONArrayStream {
+	return &JSONArrayStream{w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *JSONArrayStream) Started() bool {
+	return s.started
+}
+
+func (s *JSONArrayStream) Write(v any) error {
+	sep := ","
+	if !s.started {
+		s.start()
+		sep = "["
+	}
+	if _, err := s.w.Write([]byte(sep)); err != nil {
+		return err
+	}
+	return s.enc.Encode(v)
+}
+
+func (s *JSONArrayStream) Close() error {
+	if !s.started {
+		s.start()
+		_, err := s.w.Write([]byte("[]"))
+		return err
+	}
+	_, err := s.w.Write([]byte("]"))
+	return err
+}
+
+func (s *JSONArrayStream) start() {
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.WriteHeader(http.StatusOK)
+}